@@ -7,6 +7,35 @@ import (
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+// AdoptClientIdAnnotation names an existing Maskinporten client the operator should take over
+// instead of registering a new one, used when migrating apps with hand-made clients.
+// It must be set when the resource is created, and is only read until the client is recorded in the app secret.
+const AdoptClientIdAnnotation = "client.altinn.operator/adopt-client-id"
+
+// DryRunAnnotation set to 'true' makes the operator plan reconciliation of the resource without executing anything.
+// The planned actions are reported in the status and as events, e.g. to review the effect of operator upgrades.
+// Deleting a resource in dry-run removes it without cleaning up the client and the app secret.
+const DryRunAnnotation = "client.altinn.operator/dry-run"
+
+// Values of `MaskinportenClientStatus.Origin`
+const (
+	ClientOriginCreated = "Created"
+	ClientOriginAdopted = "Adopted"
+)
+
+// DeletionPolicy decides what happens to the client in Maskinporten when the MaskinportenClient is deleted
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete deletes the client in Maskinporten
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyRetain leaves the client active in Maskinporten, so that it can be adopted by another
+	// MaskinportenClient, e.g. in another cluster. The description is marked so that the orphan collector skips it.
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+	// DeletionPolicyDeactivate keeps the client in Maskinporten, but deactivates it
+	DeletionPolicyDeactivate DeletionPolicy = "Deactivate"
+)
+
 // MaskinportenClientSpec defines the desired state of MaskinportenClient
 type MaskinportenClientSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
@@ -14,6 +43,66 @@ type MaskinportenClientSpec struct {
 
 	// Scopes is a list of Maskinporten scopes that the client should have access to
 	Scopes []string `json:"scopes,omitempty"`
+
+	// Profile selects the Maskinporten environment the client is registered in,
+	// as configured in the operator. Uses the default profile if empty.
+	//
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9]*$`
+	// +optional
+	Profile string `json:"profile,omitempty"`
+
+	// DeletionPolicy decides what happens to the client in Maskinporten when this resource is deleted.
+	// The secret content is always removed.
+	//
+	// +kubebuilder:validation:Enum=Delete;Retain;Deactivate
+	// +kubebuilder:default=Delete
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// Suspend stops the operator from changing the client in Maskinporten and the app secret.
+	// The current state is still fetched, and the actions the operator would take are reported in the status.
+	// Deleting a suspended resource removes it without cleaning up the client and the app secret.
+	//
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+}
+
+// Max number of entries in `MaskinportenClientStatus.History`, older entries are dropped
+const MaxActionHistory = 20
+
+// ActionDescription describes what an action of the operator changes
+type ActionDescription struct {
+	// Action is the type of the action, e.g. 'UpdateClientInApiCommand'
+	Action string `json:"action"`
+	// Summary is a human-readable description of the change
+	Summary  string `json:"summary"`
+	ClientId string `json:"clientId,omitempty"`
+	Profile  string `json:"profile,omitempty"`
+
+	ScopesAdded   []string `json:"scopesAdded,omitempty"`
+	ScopesRemoved []string `json:"scopesRemoved,omitempty"`
+	KeysAdded     []string `json:"keysAdded,omitempty"`
+	KeysRetired   []string `json:"keysRetired,omitempty"`
+	// Changes lists other changed values, such as the authority
+	Changes []ValueChange `json:"changes,omitempty"`
+}
+
+// ValueChange is a single value changed by an action
+type ValueChange struct {
+	Field string `json:"field"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+}
+
+// ActionRecord is an action executed by the operator
+type ActionRecord struct {
+	ActionDescription `json:",inline"`
+	// Timestamp is when the action was executed
+	//
+	// +kubebuilder:validation:Format: date-time
+	Timestamp metav1.Time `json:"timestamp"`
+	// Generation of the resource the action was executed for
+	Generation int64 `json:"generation"`
 }
 
 // MaskinportenClientStatus defines the observed state of MaskinportenClient
@@ -22,9 +111,14 @@ type MaskinportenClientStatus struct {
 	// Important: Run "make" to regenerate code after modifying this file
 
 	// ClientId is the client id of the client posted to Maskinporten API
-	ClientId  string   `json:"clientId,omitempty"`
-	Authority string   `json:"authority,omitempty"`
-	KeyIds    []string `json:"keyIds,omitempty"`
+	ClientId  string `json:"clientId,omitempty"`
+	Authority string `json:"authority,omitempty"`
+	// Origin is 'Created' if the operator registered the client, or 'Adopted' if it was taken over
+	// through the adopt annotation
+	Origin string `json:"origin,omitempty"`
+	// Profile is the Maskinporten profile the client is currently registered in
+	Profile string   `json:"profile,omitempty"`
+	KeyIds  []string `json:"keyIds,omitempty"`
 	// LastSynced is the timestamp of the last successful sync towards Maskinporten API
 	//
 	// +kubebuilder:validation:Format: date-time
@@ -33,6 +127,11 @@ type MaskinportenClientStatus struct {
 	Reason             string       `json:"reason,omitempty"`
 	ObservedGeneration int64        `json:"observedGeneration,omitempty"`
 	LastActions        []string     `json:"lastActions,omitempty"`
+	// History holds the most recent actions executed by the operator, oldest first
+	History []ActionRecord `json:"history,omitempty"`
+	// PlannedActions describe the actions the operator would take, reported while reconciliation
+	// is suspended or in dry-run
+	PlannedActions []string `json:"plannedActions,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -8,6 +8,63 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ActionDescription) DeepCopyInto(out *ActionDescription) {
+	*out = *in
+	if in.ScopesAdded != nil {
+		in, out := &in.ScopesAdded, &out.ScopesAdded
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ScopesRemoved != nil {
+		in, out := &in.ScopesRemoved, &out.ScopesRemoved
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.KeysAdded != nil {
+		in, out := &in.KeysAdded, &out.KeysAdded
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.KeysRetired != nil {
+		in, out := &in.KeysRetired, &out.KeysRetired
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Changes != nil {
+		in, out := &in.Changes, &out.Changes
+		*out = make([]ValueChange, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ActionDescription.
+func (in *ActionDescription) DeepCopy() *ActionDescription {
+	if in == nil {
+		return nil
+	}
+	out := new(ActionDescription)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ActionRecord) DeepCopyInto(out *ActionRecord) {
+	*out = *in
+	in.ActionDescription.DeepCopyInto(&out.ActionDescription)
+	in.Timestamp.DeepCopyInto(&out.Timestamp)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ActionRecord.
+func (in *ActionRecord) DeepCopy() *ActionRecord {
+	if in == nil {
+		return nil
+	}
+	out := new(ActionRecord)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MaskinportenClient) DeepCopyInto(out *MaskinportenClient) {
 	*out = *in
@@ -104,6 +161,18 @@ func (in *MaskinportenClientStatus) DeepCopyInto(out *MaskinportenClientStatus)
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make([]ActionRecord, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.PlannedActions != nil {
+		in, out := &in.PlannedActions, &out.PlannedActions
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MaskinportenClientStatus.
@@ -115,3 +184,18 @@ func (in *MaskinportenClientStatus) DeepCopy() *MaskinportenClientStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ValueChange) DeepCopyInto(out *ValueChange) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ValueChange.
+func (in *ValueChange) DeepCopy() *ValueChange {
+	if in == nil {
+		return nil
+	}
+	out := new(ValueChange)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"crypto/tls"
 	"flag"
+	"fmt"
 	"os"
+	"slices"
 	"time"
 
 	"github.com/go-errors/errors"
@@ -27,8 +29,11 @@ import (
 
 	resourcesv1alpha1 "github.com/altinn/altinn-k8s-operator/api/v1alpha1"
 	"github.com/altinn/altinn-k8s-operator/internal"
+	"github.com/altinn/altinn-k8s-operator/internal/config"
 	"github.com/altinn/altinn-k8s-operator/internal/controller"
+	"github.com/altinn/altinn-k8s-operator/internal/operatorcontext"
 	"github.com/altinn/altinn-k8s-operator/internal/telemetry"
+	"github.com/altinn/altinn-k8s-operator/internal/tokenservice"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -50,6 +55,9 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var configFile string
+	var printConfig bool
+	var dryRun bool
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metric endpoint binds to. "+
 		"Use the port :8080. If not set, it will be 0 in order to disable the metrics server")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
@@ -60,6 +68,13 @@ func main() {
 		"If set the metrics endpoint is served securely")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&configFile, "config-file", "",
+		"Path to the config file (env or YAML), only used in local and dev environments. Defaults to '<env>.env'")
+	flag.BoolVar(&printConfig, "print-config", false,
+		"Print the merged config with secrets redacted and exit")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Plan reconciliation without changing clients or secrets, same as -controller.dry_run=true")
+	configFlags := config.BindFlags(flag.CommandLine)
 	opts := zap.Options{
 		Development: true,
 	}
@@ -68,8 +83,21 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	overrides := configFlags.Overrides()
+	if dryRun {
+		overrides["controller.dry_run"] = "true"
+	}
+
 	ctx := ctrl.SetupSignalHandler()
 
+	if printConfig {
+		if err := printMergedConfig(ctx, configFile, overrides); err != nil {
+			setupLog.Error(err, "unable to load config")
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Set up OpenTelemetry.
 	otelShutdown, err := telemetry.ConfigureOTel(ctx)
 	if err != nil {
@@ -79,7 +107,7 @@ func main() {
 
 	ctx, span := otel.Tracer(telemetry.ServiceName).Start(ctx, "Main")
 
-	rt, err := internal.NewRuntime(ctx, "")
+	rt, err := internal.NewRuntime(ctx, "", configFile, overrides)
 	if err != nil {
 		setupLog.Error(err, "unable to initialize runtime")
 		span.End()
@@ -148,18 +176,61 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = (controller.NewMaskinportenClientReconciler(
+	reconciler, err := controller.NewMaskinportenClientReconciler(
 		rt,
 		mgr.GetClient(),
 		mgr.GetScheme(),
+		mgr.GetEventRecorderFor("maskinportenclient-controller"),
 		nil,
-	)).SetupWithManager(mgr); err != nil {
+	)
+	if err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "MaskinportenClient")
+		span.End()
+		os.Exit(1)
+	}
+	if err = reconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "MaskinportenClient")
 		span.End()
 		os.Exit(1)
 	}
 	// +kubebuilder:scaffold:builder
 
+	if err := mgr.Add(rt.GetConfigWatcher()); err != nil {
+		setupLog.Error(err, "unable to set up config watcher")
+		span.End()
+		os.Exit(1)
+	}
+
+	orphanCollector, err := controller.NewOrphanCollector(
+		rt,
+		mgr.GetAPIReader(),
+		mgr.GetEventRecorderFor("orphan-collector"),
+	)
+	if err != nil {
+		setupLog.Error(err, "unable to create orphan collector")
+		span.End()
+		os.Exit(1)
+	}
+	if err := mgr.Add(orphanCollector); err != nil {
+		setupLog.Error(err, "unable to set up orphan collector")
+		span.End()
+		os.Exit(1)
+	}
+
+	if rt.GetConfig().TokenService.Enabled {
+		tokenService, err := tokenservice.NewService(rt, mgr.GetClient(), mgr.GetAPIReader())
+		if err != nil {
+			setupLog.Error(err, "unable to create token service")
+			span.End()
+			os.Exit(1)
+		}
+		if err := mgr.Add(tokenService); err != nil {
+			setupLog.Error(err, "unable to set up token service")
+			span.End()
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		span.End()
@@ -179,3 +250,26 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+func printMergedConfig(ctx context.Context, configFile string, overrides config.Overrides) error {
+	operatorContext, err := operatorcontext.Discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.GetConfigWithOverrides(operatorContext, config.ConfigSourceDefault, configFile, overrides)
+	if err != nil {
+		return err
+	}
+
+	values := cfg.Redacted()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		fmt.Printf("%s=%s\n", key, values[key])
+	}
+	return nil
+}
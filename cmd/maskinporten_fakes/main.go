@@ -14,6 +14,7 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/go-errors/errors"
 
@@ -23,6 +24,7 @@ import (
 	"github.com/altinn/altinn-k8s-operator/internal/fakes"
 	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
 	"github.com/altinn/altinn-k8s-operator/internal/operatorcontext"
+	"github.com/jonboulle/clockwork"
 )
 
 type contextKey string
@@ -34,6 +36,12 @@ const GET = "GET"
 const PUT = "PUT"
 const DELETE = "DELETE"
 
+// Issuer of the fake Maskinporten API, expected as audience in JWT grants
+const issuer = "http://localhost:8050"
+
+// Environment variable with the path of the JSON snapshot the state is persisted to, optional
+const EnvSnapshotPath = "FAKES_SNAPSHOT_PATH"
+
 func main() {
 	log.SetOutput(os.Stdout)
 	log.Println("Starting server..")
@@ -44,6 +52,10 @@ func main() {
 	cfg := config.GetConfigOrDie(operatorContext, config.ConfigSourceKoanf, "")
 
 	state := fakes.NewState(cfg)
+	state.SnapshotPath = os.Getenv(EnvSnapshotPath)
+	if err := state.LoadSnapshot(); err != nil {
+		log.Fatalf("couldn't load snapshot: %v", err)
+	}
 	ctx = context.WithValue(ctx, StateKey, state)
 
 	wg.Add(2)
@@ -55,18 +67,17 @@ func main() {
 	log.Println("Shutting down..")
 }
 
-type FakeToken struct {
-	Scopes   []string `json:"scopes"`
-	ClientId string   `json:"client_id"`
-}
-
 func serve(ctx context.Context, name string, addr string, registerHandlers func(*http.ServeMux)) {
+	state := ctx.Value(StateKey).(*fakes.State)
+	assert.Assert(state != nil)
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", healthEndpoint)
+	mux.Handle(fakes.FaultsPath, state.Faults)
 	registerHandlers(mux)
 	server := &http.Server{
 		Addr:    addr,
-		Handler: mux,
+		Handler: state.Faults.Middleware(mux),
 		BaseContext: func(l net.Listener) context.Context {
 			return ctx
 		},
@@ -94,6 +105,10 @@ func runMaskinportenApi(ctx context.Context, wg *sync.WaitGroup) {
 	state := ctx.Value(StateKey).(*fakes.State)
 	assert.Assert(state != nil)
 
+	// Same checks as Maskinporten does for JWT grants
+	clock := clockwork.NewRealClock()
+	jtiCache := crypto.NewJtiCache(clock)
+
 	serve(ctx, name, addr, func(mux *http.ServeMux) {
 		mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
 			if r.Method != POST {
@@ -145,7 +160,7 @@ func runMaskinportenApi(ctx context.Context, wg *sync.WaitGroup) {
 			}
 			client := clients[0]
 
-			claims, err := jwt.DecodeClaims(client.Jwks.Keys[0])
+			claims, err := client.Jwks.Verify(jwt)
 			if err != nil {
 				w.WriteHeader(400)
 				log.Printf("couldn't validate JWT: %v\n", errors.Wrap(err, 0))
@@ -158,9 +173,17 @@ func runMaskinportenApi(ctx context.Context, wg *sync.WaitGroup) {
 				log.Printf("missing issuer\n")
 				return
 			}
-			if clientId != client.ClientId {
+			err = crypto.ValidateClaims(claims, &crypto.ClaimsValidation{
+				Audience:    issuer,
+				Issuer:      client.ClientId,
+				ClockSkew:   10 * time.Second,
+				MaxLifetime: 120 * time.Second,
+				Clock:       clock,
+				JtiCache:    jtiCache,
+			})
+			if err != nil {
 				w.WriteHeader(400)
-				log.Printf("invalid issuer: %s\n", clientId)
+				log.Printf("invalid JWT grant: %v\n", err)
 				return
 			}
 			if claims.Scope == "" {
@@ -180,7 +203,7 @@ func runMaskinportenApi(ctx context.Context, wg *sync.WaitGroup) {
 
 			w.Header().Add("Content-Type", "application/json")
 
-			fakeToken := FakeToken{
+			fakeToken := fakes.FakeToken{
 				Scopes:   requestedScopes,
 				ClientId: client.ClientId,
 			}
@@ -212,9 +235,9 @@ func runMaskinportenApi(ctx context.Context, wg *sync.WaitGroup) {
 			w.Header().Add("Content-Type", "application/json")
 			encoder := json.NewEncoder(w)
 			err := encoder.Encode(maskinporten.WellKnownResponse{
-				Issuer:                            "http://localhost:8050",
-				TokenEndpoint:                     "http://localhost:8050/token",
-				JwksURI:                           "http://localhost:8050/jwks",
+				Issuer:                            issuer,
+				TokenEndpoint:                     issuer + "/token",
+				JwksURI:                           issuer + "/jwks",
 				TokenEndpointAuthMethodsSupported: []string{"private_key_jwt"},
 				GrantTypesSupported:               []string{"urn:ietf:params:oauth:grant-type:jwt-bearer"},
 				TokenEndpointAuthSigningAlgValuesSupported: crypto.SignatureAlgorithmsStr,
@@ -227,7 +250,7 @@ func runMaskinportenApi(ctx context.Context, wg *sync.WaitGroup) {
 	})
 }
 
-func selfServiceAuth(r *http.Request) *FakeToken {
+func selfServiceAuth(r *http.Request) *fakes.FakeToken {
 	if r.Header.Get("Authorization") == "" {
 		return nil
 	}
@@ -242,7 +265,7 @@ func selfServiceAuth(r *http.Request) *FakeToken {
 		return nil
 	}
 
-	var token FakeToken
+	var token fakes.FakeToken
 	err = json.Unmarshal(decoded, &token)
 	if err != nil {
 		return nil
@@ -259,15 +282,75 @@ func handleDumpPlease(w http.ResponseWriter, r *http.Request) {
 	state := r.Context().Value(StateKey).(*fakes.State)
 	assert.Assert(state != nil)
 
+	filter := fakes.DumpFilter{
+		RunId:            r.URL.Query().Get("runId"),
+		ClientNamePrefix: r.URL.Query().Get("clientNamePrefix"),
+	}
+
 	w.Header().Add("Content-Type", "application/json")
 	encoder := json.NewEncoder(w)
-	err := encoder.Encode(state.GetAll())
+	err := encoder.Encode(state.Dump(filter))
 	if err != nil {
 		w.WriteHeader(500)
 		log.Printf("couldn't write response: %v\n", errors.Wrap(err, 0))
 	}
 }
 
+func handleRunReset(w http.ResponseWriter, r *http.Request) {
+	state := r.Context().Value(StateKey).(*fakes.State)
+	assert.Assert(state != nil)
+
+	if r.Method != POST {
+		w.WriteHeader(404)
+		return
+	}
+
+	state.Reset(r.PathValue("runId"))
+	w.WriteHeader(204)
+}
+
+// handleRunSeed resets the run's DB and restores the client records in the body,
+// which has the same format as a single run in the dump, e.g. `curl --data @fixture.json`
+func handleRunSeed(w http.ResponseWriter, r *http.Request) {
+	state := r.Context().Value(StateKey).(*fakes.State)
+	assert.Assert(state != nil)
+
+	if r.Method != POST {
+		w.WriteHeader(404)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var records []fakes.ClientRecord
+	err := decoder.Decode(&records)
+	if err != nil {
+		w.WriteHeader(400)
+		log.Printf("couldn't read request: %v\n", errors.Wrap(err, 0))
+		return
+	}
+
+	err = state.Seed(r.PathValue("runId"), records)
+	if err != nil {
+		w.WriteHeader(400)
+		log.Printf("couldn't seed run: %v\n", err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// persistState saves a snapshot of the state after requests that may have changed it
+func persistState(state *fakes.State, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		if r.Method == GET {
+			return
+		}
+		if err := state.SaveSnapshot(); err != nil {
+			log.Printf("couldn't save snapshot: %v\n", err)
+		}
+	})
+}
+
 func handleClients(w http.ResponseWriter, r *http.Request) {
 	state := r.Context().Value(StateKey).(*fakes.State)
 	assert.Assert(state != nil)
@@ -302,21 +385,19 @@ func handleClients(w http.ResponseWriter, r *http.Request) {
 		var client maskinporten.AddClientRequest
 		err := decoder.Decode(&client)
 		if err != nil {
-			w.WriteHeader(400)
-			log.Printf("couldn't read request: %v\n", errors.Wrap(err, 0))
+			fakes.WriteApiError(w, 400, "couldn't read request", err)
 			return
 		}
 
-		if slices.Contains(client.Scopes, "idporten:dcr.altinn") {
-			w.WriteHeader(400)
-			log.Printf("clients cannot request idporten:dcr.altinn scope\n")
+		err = fakes.ValidateAddClientRequest(&client)
+		if err != nil {
+			fakes.WriteApiError(w, 400, "invalid client", err)
 			return
 		}
 
 		clientRecord, err := state.GetDb(r).Insert(&client, nil, "")
 		if err != nil {
-			w.WriteHeader(400)
-			log.Printf("couldn't insert client: %v\n", errors.Wrap(err, 0))
+			fakes.WriteApiError(w, 400, "couldn't insert client", err)
 			return
 		}
 
@@ -355,7 +436,7 @@ func handleClientByID(w http.ResponseWriter, r *http.Request) {
 
 		clientRecord := state.GetDb(r).Get(clientId)
 		if clientRecord == nil {
-			w.WriteHeader(404)
+			fakes.WriteApiError(w, 404, "client not found: "+clientId, nil)
 			return
 		}
 		w.Header().Add("Content-Type", "application/json")
@@ -375,63 +456,31 @@ func handleClientByID(w http.ResponseWriter, r *http.Request) {
 		var client maskinporten.UpdateClientRequest
 		err := decoder.Decode(&client)
 		if err != nil {
-			w.WriteHeader(400)
-			log.Printf("couldn't read request: %v\n", errors.Wrap(err, 0))
+			fakes.WriteApiError(w, 400, "couldn't read request", err)
 			return
 		}
 
-		if slices.Contains(client.Scopes, "idporten:dcr.altinn") {
-			w.WriteHeader(400)
-			log.Printf("clients cannot request idporten:dcr.altinn scope\n")
+		err = fakes.ValidateUpdateClientRequest(&client)
+		if err != nil {
+			fakes.WriteApiError(w, 400, "invalid client", err)
 			return
 		}
 
-		deleted := state.GetDb(r).Delete(clientId)
-		if !deleted {
-			w.WriteHeader(400)
-			log.Printf(
-				"couldn't read request: client does not exist clientId=%s\n",
-				clientId,
-			)
+		if state.GetDb(r).Get(clientId) == nil {
+			fakes.WriteApiError(w, 404, "client not found: "+clientId, nil)
 			return
 		}
-		addReq := &maskinporten.AddClientRequest{
-			ClientId:                          client.ClientId,
-			ClientName:                        client.ClientName,
-			ClientOrgno:                       client.ClientOrgno,
-			SupplierOrgno:                     client.SupplierOrgno,
-			Description:                       client.Description,
-			Active:                            client.Active,
-			ApplicationType:                   client.ApplicationType,
-			IntegrationType:                   client.IntegrationType,
-			Scopes:                            client.Scopes,
-			GrantTypes:                        client.GrantTypes,
-			TokenEndpointAuthMethod:           client.TokenEndpointAuthMethod,
-			RefreshTokenLifetime:              client.RefreshTokenLifetime,
-			RefreshTokenUsage:                 client.RefreshTokenUsage,
-			AccessTokenLifetime:               client.AccessTokenLifetime,
-			AuthorizationLifetime:             client.AuthorizationLifetime,
-			LogoUri:                           client.LogoUri,
-			RedirectUris:                      client.RedirectUris,
-			PostLogoutRedirectUris:            client.PostLogoutRedirectUris,
-			FrontchannelLogoutSessionRequired: client.FrontchannelLogoutSessionRequired,
-			FrontchannelLogoutUri:             client.FrontchannelLogoutUri,
-			SsoDisabled:                       client.SsoDisabled,
-			CodeChallengeMethod:               client.CodeChallengeMethod,
-		}
-		updatedRecord, err := state.GetDb(r).Insert(addReq, nil, clientId)
+		updatedRecord, err := state.GetDb(r).Update(clientId, &client)
 		if err != nil {
-			w.WriteHeader(400)
-			log.Printf("couldn't insert client: %v\n", errors.Wrap(err, 0))
+			fakes.WriteApiError(w, 400, "couldn't update client", err)
 			return
 		}
 
-		w.WriteHeader(200)
 		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(200)
 		encoder := json.NewEncoder(w)
 		err = encoder.Encode(updatedRecord.Client)
 		if err != nil {
-			w.WriteHeader(500)
 			log.Printf("couldn't write response: %v\n", errors.Wrap(err, 0))
 			return
 		}
@@ -441,6 +490,12 @@ func handleClientByID(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if !state.GetDb(r).Delete(clientId) {
+			fakes.WriteApiError(w, 404, "client not found: "+clientId, nil)
+			return
+		}
+		w.WriteHeader(200)
+
 	default:
 		if selfServiceAuth(r) == nil {
 			w.WriteHeader(401)
@@ -463,14 +518,13 @@ func handleClientJwks(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(401)
 			return
 		}
-		w.Header().Add("Content-Type", "application/json")
-
 		clientRecord := state.GetDb(r).Get(clientId)
 		if clientRecord == nil {
-			w.WriteHeader(404)
+			fakes.WriteApiError(w, 404, "client not found: "+clientId, nil)
 			return
 		}
 
+		w.Header().Add("Content-Type", "application/json")
 		encoder := json.NewEncoder(w)
 		err := encoder.Encode(clientRecord.Jwks)
 		if err != nil {
@@ -488,15 +542,23 @@ func handleClientJwks(w http.ResponseWriter, r *http.Request) {
 		var jwks crypto.Jwks
 		err := decoder.Decode(&jwks)
 		if err != nil {
-			w.WriteHeader(400)
-			log.Printf("couldn't read request: %v\n", errors.Wrap(err, 0))
+			fakes.WriteApiError(w, 400, "couldn't read request", err)
 			return
 		}
 
+		err = fakes.ValidateJwks(&jwks)
+		if err != nil {
+			fakes.WriteApiError(w, 400, "invalid JWKS", err)
+			return
+		}
+
+		if state.GetDb(r).Get(clientId) == nil {
+			fakes.WriteApiError(w, 404, "client not found: "+clientId, nil)
+			return
+		}
 		err = state.GetDb(r).UpdateJwks(clientId, &jwks)
 		if err != nil {
-			w.WriteHeader(400)
-			log.Printf("couldn't update JWKS: %v\n", errors.Wrap(err, 0))
+			fakes.WriteApiError(w, 400, "couldn't update JWKS", err)
 			return
 		}
 		w.WriteHeader(201)
@@ -515,11 +577,19 @@ func runSelfServiceApi(ctx context.Context, wg *sync.WaitGroup) {
 	name := "Self Service API"
 	addr := ":8051"
 
+	state := ctx.Value(StateKey).(*fakes.State)
+	assert.Assert(state != nil)
+
 	serve(ctx, name, addr, func(mux *http.ServeMux) {
 		mux.HandleFunc("/dump/please", handleDumpPlease)
-		mux.HandleFunc("/api/v1/altinn/admin/clients", handleClients)
-		mux.HandleFunc("/api/v1/altinn/admin/clients/{clientId}", handleClientByID)
-		mux.HandleFunc("/api/v1/altinn/admin/clients/{clientId}/jwks", handleClientJwks)
+		mux.Handle("/runs/{runId}/reset", persistState(state, http.HandlerFunc(handleRunReset)))
+		mux.Handle("/runs/{runId}/seed", persistState(state, http.HandlerFunc(handleRunSeed)))
+		mux.Handle("/api/v1/altinn/admin/clients", persistState(state, http.HandlerFunc(handleClients)))
+		mux.Handle("/api/v1/altinn/admin/clients/{clientId}", persistState(state, http.HandlerFunc(handleClientByID)))
+		mux.Handle(
+			"/api/v1/altinn/admin/clients/{clientId}/jwks",
+			persistState(state, http.HandlerFunc(handleClientJwks)),
+		)
 	})
 }
 
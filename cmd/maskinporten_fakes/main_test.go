@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/altinn/altinn-k8s-operator/internal/config"
+	"github.com/altinn/altinn-k8s-operator/internal/crypto"
+	"github.com/altinn/altinn-k8s-operator/internal/fakes"
+	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
+	"github.com/altinn/altinn-k8s-operator/internal/operatorcontext"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/gomega"
+)
+
+type selfServiceFixture struct {
+	g      *WithT
+	server *httptest.Server
+	token  string
+}
+
+func newSelfServiceFixture(g *WithT) *selfServiceFixture {
+	operatorContext := operatorcontext.DiscoverOrDie(context.Background())
+	cfg := config.GetConfigOrDie(operatorContext, config.ConfigSourceKoanf, "")
+	state := fakes.NewState(cfg)
+	ctx := context.WithValue(context.Background(), StateKey, state)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/altinn/admin/clients", handleClients)
+	mux.HandleFunc("/api/v1/altinn/admin/clients/{clientId}", handleClientByID)
+	mux.HandleFunc("/api/v1/altinn/admin/clients/{clientId}/jwks", handleClientJwks)
+	server := httptest.NewUnstartedServer(mux)
+	server.Config.BaseContext = func(l net.Listener) context.Context { return ctx }
+	server.Start()
+
+	token, err := json.Marshal(fakes.FakeToken{Scopes: []string{"idporten:dcr.altinn"}})
+	g.Expect(err).NotTo(HaveOccurred())
+	return &selfServiceFixture{g: g, server: server, token: base64.StdEncoding.EncodeToString(token)}
+}
+
+func (f *selfServiceFixture) do(method string, path string, body any) (int, []byte) {
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		f.g.Expect(err).NotTo(HaveOccurred())
+		reader = bytes.NewReader(content)
+	}
+	req, err := http.NewRequest(method, f.server.URL+path, reader)
+	f.g.Expect(err).NotTo(HaveOccurred())
+	req.Header.Add("Authorization", "Bearer "+f.token)
+	req.Header.Add(fakes.RunIdHeader, "run1")
+	resp, err := http.DefaultClient.Do(req)
+	f.g.Expect(err).NotTo(HaveOccurred())
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	f.g.Expect(err).NotTo(HaveOccurred())
+	return resp.StatusCode, content
+}
+
+func TestSelfServiceResponsesMatchSpec(t *testing.T) {
+	g := NewWithT(t)
+	spec, err := fakes.LoadSpec(fakes.DefaultSpecPath())
+	g.Expect(err).NotTo(HaveOccurred())
+	f := newSelfServiceFixture(g)
+	defer f.server.Close()
+
+	operatorContext := operatorcontext.DiscoverOrDie(context.Background())
+	clientName := maskinporten.GetClientName(operatorContext, "app1")
+	description := "Test client"
+	integrationType := maskinporten.IntegrationTypeMaskinporten
+	appType := maskinporten.ApplicationTypeWeb
+	tokenEndpointMethod := maskinporten.TokenEndpointAuthMethodPrivateKeyJwt
+	req := &maskinporten.AddClientRequest{
+		ClientName:              &clientName,
+		Description:             &description,
+		GrantTypes:              []maskinporten.GrantType{maskinporten.GrantTypeJwtBearer},
+		Scopes:                  []string{"altinn:scope"},
+		IntegrationType:         &integrationType,
+		ApplicationType:         &appType,
+		TokenEndpointAuthMethod: &tokenEndpointMethod,
+	}
+
+	status, body := f.do(POST, "/api/v1/altinn/admin/clients", req)
+	g.Expect(status).To(Equal(200))
+	g.Expect(spec.ValidateJSON("ClientResponse", body)).To(Succeed())
+	var created maskinporten.ClientResponse
+	g.Expect(json.Unmarshal(body, &created)).To(Succeed())
+	clientPath := "/api/v1/altinn/admin/clients/" + created.ClientId
+
+	clock := clockwork.NewFakeClock()
+	service := crypto.NewDefaultService(operatorContext, clock, rand.Reader)
+	jwks, err := service.CreateJwks("app1", clock.Now().Add(30*24*time.Hour))
+	g.Expect(err).NotTo(HaveOccurred())
+	publicJwks, err := jwks.ToPublic()
+	g.Expect(err).NotTo(HaveOccurred())
+	status, _ = f.do(POST, clientPath+"/jwks", publicJwks)
+	g.Expect(status).To(Equal(201))
+
+	status, body = f.do(GET, clientPath+"/jwks", nil)
+	g.Expect(status).To(Equal(200))
+	g.Expect(spec.ValidateJSON("OidcJwksRequestResponse", body)).To(Succeed())
+
+	status, body = f.do(GET, clientPath, nil)
+	g.Expect(status).To(Equal(200))
+	g.Expect(spec.ValidateJSON("ClientResponse", body)).To(Succeed())
+
+	status, body = f.do(PUT, clientPath, &maskinporten.UpdateClientRequest{
+		ClientName:              req.ClientName,
+		Description:             req.Description,
+		GrantTypes:              req.GrantTypes,
+		Scopes:                  []string{"altinn:scope", "altinn:other"},
+		IntegrationType:         req.IntegrationType,
+		ApplicationType:         req.ApplicationType,
+		TokenEndpointAuthMethod: req.TokenEndpointAuthMethod,
+	})
+	g.Expect(status).To(Equal(200))
+	g.Expect(spec.ValidateJSON("ClientResponse", body)).To(Succeed())
+
+	status, body = f.do(GET, "/api/v1/altinn/admin/clients", nil)
+	g.Expect(status).To(Equal(200))
+	var clients []json.RawMessage
+	g.Expect(json.Unmarshal(body, &clients)).To(Succeed())
+	g.Expect(clients).To(HaveLen(2))
+	for _, client := range clients {
+		g.Expect(spec.ValidateJSON("ClientResponse", client)).To(Succeed())
+	}
+
+	// Errors
+	status, body = f.do(POST, clientPath+"/jwks", jwks)
+	g.Expect(status).To(Equal(400))
+	g.Expect(spec.ValidateJSON("ApiErrorResponse", body)).To(Succeed())
+	req.Scopes = []string{"idporten:dcr.altinn"}
+	status, body = f.do(POST, "/api/v1/altinn/admin/clients", req)
+	g.Expect(status).To(Equal(400))
+	g.Expect(spec.ValidateJSON("ApiErrorResponse", body)).To(Succeed())
+
+	status, _ = f.do(DELETE, clientPath, nil)
+	g.Expect(status).To(Equal(200))
+	status, body = f.do(GET, clientPath, nil)
+	g.Expect(status).To(Equal(404))
+	g.Expect(spec.ValidateJSON("ApiErrorResponse", body)).To(Succeed())
+}
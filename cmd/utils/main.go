@@ -52,6 +52,7 @@ func main() {
 			fmt.Fprintf(os.Stderr, "Usage: %s create <subcommand> [options]\n", os.Args[0])
 			fmt.Fprintf(os.Stderr, "Subcommands:\n")
 			fmt.Fprintf(os.Stderr, "  jwk     Create a JSON Web Key Set\n")
+			fmt.Fprintf(os.Stderr, "  ca      Create an operator CA for signing app certificates\n")
 			os.Exit(1)
 		}
 
@@ -59,6 +60,8 @@ func main() {
 		switch subcommand {
 		case "jwk":
 			createJwk()
+		case "ca":
+			createCa()
 		default:
 			fmt.Fprintf(os.Stderr, "Unknown subcommand: %s\n", subcommand)
 			os.Exit(1)
@@ -362,3 +365,90 @@ func createJwk() {
 	fmt.Println("---")
 	fmt.Println(string(publicJwkJson))
 }
+
+func createCa() {
+	fs := flag.NewFlagSet("create ca", flag.ExitOnError)
+	var commonName string
+	var notAfterStr string
+	var certFile string
+	var keyFile string
+	var verbose bool
+	fs.StringVar(&commonName, "common-name", "Altinn Operator CA", "Common name for the CA certificate")
+	fs.StringVar(
+		&notAfterStr,
+		"not-after",
+		"",
+		"CA certificate expiration time (RFC3339 format, e.g., 2030-12-31T23:59:59Z)",
+	)
+	fs.StringVar(&certFile, "cert-file", "", "File to write the PEM encoded CA certificate to (default stdout)")
+	fs.StringVar(&keyFile, "key-file", "", "File to write the PEM encoded CA private key to (default stdout)")
+	fs.BoolVar(&verbose, "verbose", false, "Print CA information to stderr")
+
+	err := fs.Parse(os.Args[3:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse flags: %v\n", err)
+		os.Exit(1)
+	}
+
+	var notAfter time.Time
+	if notAfterStr == "" {
+		// Default to 5 years from now, app certificates can't outlive the CA
+		notAfter = time.Now().Add(time.Hour * 24 * 365 * 5)
+	} else {
+		notAfter, err = time.Parse(time.RFC3339, notAfterStr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to parse not-after time: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Expected RFC3339 format, e.g., 2030-12-31T23:59:59Z\n")
+			os.Exit(1)
+		}
+	}
+
+	ctx := context.Background()
+
+	operatorCtx, err := operatorcontext.Discover(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to discover operator context: %v\n", err)
+		os.Exit(1)
+	}
+
+	clock := clockwork.NewRealClock()
+	cryptoService := crypto.NewDefaultService(operatorCtx, clock, rand.Reader)
+
+	ca, err := cryptoService.CreateCertificateAuthority(commonName, notAfter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create CA: %v\n", err)
+		os.Exit(1)
+	}
+
+	certPem, keyPem, err := ca.EncodePem()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode CA: %v\n", err)
+		os.Exit(1)
+	}
+
+	if verbose {
+		cert := ca.Certificate()
+		fmt.Fprintf(os.Stderr, "CA subject: %s\n", cert.Subject)
+		fmt.Fprintf(os.Stderr, "CA serial number: %s\n", cert.SerialNumber)
+		fmt.Fprintf(os.Stderr, "CA not after: %s\n", cert.NotAfter.Format(time.RFC3339))
+		fmt.Fprintf(os.Stderr, "Configure with 'ca.cert' and 'ca.key'\n")
+		fmt.Fprintf(os.Stderr, "---\n")
+	}
+
+	if certFile != "" {
+		if err := os.WriteFile(certFile, certPem, 0o644); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write CA certificate: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		fmt.Print(string(certPem))
+	}
+	if keyFile != "" {
+		if err := os.WriteFile(keyFile, keyPem, 0o600); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write CA private key: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		fmt.Print(string(keyPem))
+	}
+}
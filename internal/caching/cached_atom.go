@@ -15,6 +15,9 @@ type CachedAtom[T any] struct {
 	current          *T
 	currentFetchedAt time.Time
 	expireAfter      time.Duration
+	// Optional, shortens the expiry for values that are valid for less than `expireAfter`
+	maxAge             func(value *T) time.Duration
+	currentExpireAfter time.Duration
 }
 
 func NewCachedAtom[T any](
@@ -30,29 +33,52 @@ func NewCachedAtom[T any](
 	}
 }
 
+// NewCachedAtomWithMaxAge is like NewCachedAtom, but values are refetched after `maxAge` of the value
+// if that is shorter than `expireAfter`, e.g. for tokens that expire before the cache does
+func NewCachedAtomWithMaxAge[T any](
+	expireAfter time.Duration,
+	clock clockwork.Clock,
+	retriever func(ctx context.Context) (*T, error),
+	maxAge func(value *T) time.Duration,
+) CachedAtom[T] {
+	atom := NewCachedAtom(expireAfter, clock, retriever)
+	atom.maxAge = maxAge
+	return atom
+}
+
 func (c *CachedAtom[T]) Get(ctx context.Context) (*T, error) {
+	value, _, err := c.Lookup(ctx)
+	return value, err
+}
+
+// Lookup is like Get, but also reports whether the value was served from the cache
+func (c *CachedAtom[T]) Lookup(ctx context.Context) (*T, bool, error) {
 	c.mutex.RLock()
 	now := c.clock.Now()
-	if c.currentFetchedAt.IsZero() || now.Sub(c.currentFetchedAt) > c.expireAfter {
+	if c.currentFetchedAt.IsZero() || now.Sub(c.currentFetchedAt) > c.currentExpireAfter {
 		c.mutex.RUnlock()
 		c.mutex.Lock()
 		defer c.mutex.Unlock()
 
 		now = c.clock.Now()
-		if now.Sub(c.currentFetchedAt) <= c.expireAfter {
-			return c.current, nil
+		if !c.currentFetchedAt.IsZero() && now.Sub(c.currentFetchedAt) <= c.currentExpireAfter {
+			return c.current, true, nil
 		}
 
 		value, err := c.retriever(ctx)
 		if err != nil {
-			return nil, err
+			return nil, false, err
 		}
 
 		c.current = value
 		c.currentFetchedAt = now
-		return c.current, nil
+		c.currentExpireAfter = c.expireAfter
+		if c.maxAge != nil {
+			c.currentExpireAfter = min(c.expireAfter, c.maxAge(value))
+		}
+		return c.current, false, nil
 	}
 	defer c.mutex.RUnlock()
 
-	return c.current, nil
+	return c.current, true, nil
 }
@@ -1,16 +1,39 @@
 package config
 
 import (
+	"errors"
 	"fmt"
-	"time"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
 	"github.com/altinn/altinn-k8s-operator/internal/operatorcontext"
+	"github.com/knadh/koanf/providers/confmap"
+	"github.com/knadh/koanf/v2"
 )
 
-func loadFromAzureKeyVault(operatorContext *operatorcontext.Context) (*Config, error) {
+// Environment variable overriding the URL of the Key Vault holding the config
+const EnvAzureKeyVaultUrl = "OPERATOR_AZURE_KEY_VAULT_URL"
+
+func GetAzureKeyVaultUrl(operatorContext *operatorcontext.Context) string {
+	if url := os.Getenv(EnvAzureKeyVaultUrl); url != "" {
+		return url
+	}
+	return fmt.Sprintf("https://altinn-%s-operator-kv.vault.azure.net", operatorContext.Environment)
+}
+
+// AzureKeyVaultSecretName maps a koanf config key to a Key Vault secret name.
+// Secret names can only contain alphanumeric characters and dashes, so
+// 'maskinporten_api.client_id' is stored as 'maskinporten-api--client-id'
+func AzureKeyVaultSecretName(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, ".", "--"), "_", "-")
+}
+
+func loadFromAzureKeyVault(operatorContext *operatorcontext.Context, k *koanf.Koanf) error {
 	span := operatorContext.StartSpan("GetConfig.AzureKeyVault")
 	defer span.End()
 
@@ -24,88 +47,78 @@ func loadFromAzureKeyVault(operatorContext *operatorcontext.Context) (*Config, e
 	}
 
 	if err != nil {
-		return nil, fmt.Errorf("error getting credentials for loading config: %w", err)
+		return fmt.Errorf("error getting credentials for loading config: %w", err)
 	}
 
-	url := fmt.Sprintf("https://altinn-%s-operator-kv.vault.azure.net", operatorContext.Environment)
+	url := GetAzureKeyVaultUrl(operatorContext)
 	client, err := azsecrets.NewClient(url, cred, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error building client for Azure KV: %w", err)
+		return fmt.Errorf("error building client for Azure KV: %w", err)
 	}
 
-	config := &Config{}
-	err = loadMaskinportenApiFromAzureKeyVault(operatorContext, client, config)
-	if err != nil {
-		return nil, err
-	}
+	return loadFromAzureKeyVaultClient(operatorContext, k, client)
+}
 
-	err = loadControllerFromAzureKeyVault(operatorContext, client, config)
-	if err != nil {
-		return nil, err
+// loadFromAzureKeyVaultClient loads a secret for every key in `Config`, see `AzureKeyVaultSecretName`.
+// Missing secrets are left unset, required fields are enforced by validation.
+func loadFromAzureKeyVaultClient(operatorContext *operatorcontext.Context, k *koanf.Koanf, client *azsecrets.Client) error {
+	values := make(map[string]any)
+	if err := getAzureKeyVaultSecrets(operatorContext, client, ConfigKeys(), values); err != nil {
+		return err
 	}
 
-	return config, nil
-}
-
-func loadMaskinportenApiFromAzureKeyVault(
-	operatorContext *operatorcontext.Context,
-	client *azsecrets.Client,
-	config *Config,
-) error {
-	secretKeys := []string{"ClientId", "Url", "Jwk", "Scope"}
-
-	for _, secretKey := range secretKeys {
-		secret, err := client.GetSecret(
-			operatorContext.Context,
-			fmt.Sprintf("%s.%s", "MaskinportenApi", secretKey),
-			"",
-			nil,
-		)
-		if err != nil {
-			return fmt.Errorf("error getting secret: %s, %w", secretKey, err)
+	// Profile names can't be derived from `Config`, so they are listed in a separate secret,
+	// e.g. 'maskinporten-profiles' = 'test,ver2' followed by 'maskinporten-profiles--ver2--client-id' etc
+	profiles := make(map[string]any)
+	if err := getAzureKeyVaultSecrets(operatorContext, client, []string{profilesKey}, profiles); err != nil {
+		return err
+	}
+	if names, ok := profiles[profilesKey].(string); ok {
+		for _, name := range strings.Split(names, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			var keys []string
+			prefix := fmt.Sprintf("%s.%s.", profilesKey, name)
+			walkKoanfFields(reflect.ValueOf(MaskinportenApiConfig{}), prefix, func(key string, _ reflect.StructField, _ reflect.Value) {
+				keys = append(keys, key)
+			})
+			if err := getAzureKeyVaultSecrets(operatorContext, client, keys, values); err != nil {
+				return err
+			}
 		}
+	}
 
-		switch secretKey {
-		case fmt.Sprintf("%s.%s", "MaskinportenApi", "ClientId"):
-			config.MaskinportenApi.ClientId = *secret.Value
-		case fmt.Sprintf("%s.%s", "MaskinportenApi", "AuthorityUrl"):
-			config.MaskinportenApi.AuthorityUrl = *secret.Value
-		case fmt.Sprintf("%s.%s", "MaskinportenApi", "Jwk"):
-			config.MaskinportenApi.Jwk = *secret.Value
-		case fmt.Sprintf("%s.%s", "MaskinportenApi", "Scope"):
-			config.MaskinportenApi.Scope = *secret.Value
-		}
+	if err := k.Load(confmap.Provider(values, "."), nil); err != nil {
+		return fmt.Errorf("error loading config from Azure KV: %w", err)
 	}
 
 	return nil
 }
 
-func loadControllerFromAzureKeyVault(
+const profilesKey = "maskinporten_profiles"
+
+func getAzureKeyVaultSecrets(
 	operatorContext *operatorcontext.Context,
 	client *azsecrets.Client,
-	config *Config,
+	keys []string,
+	values map[string]any,
 ) error {
-	secretKeys := []string{"RequeueAfter"}
-
-	for _, secretKey := range secretKeys {
-		secret, err := client.GetSecret(
-			operatorContext.Context,
-			fmt.Sprintf("%s.%s", "Controller", secretKey),
-			"",
-			nil,
-		)
+	for _, key := range keys {
+		secretName := AzureKeyVaultSecretName(key)
+		secret, err := client.GetSecret(operatorContext.Context, secretName, "", nil)
 		if err != nil {
-			return fmt.Errorf("error getting secret: %s, %w", secretKey, err)
-		}
-
-		switch secretKey {
-		case fmt.Sprintf("%s.%s", "Controller", "RequeueAfter"):
-			config.Controller.RequeueAfter, err = time.ParseDuration(*secret.Value)
-			if err != nil {
-				return err
+			var respErr *azcore.ResponseError
+			if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+				continue
 			}
+			return fmt.Errorf("error getting secret: %s, %w", secretName, err)
+		}
+		if secret.Value == nil {
+			continue
 		}
+		values[key] = *secret.Value
 	}
-
 	return nil
 }
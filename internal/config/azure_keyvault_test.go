@@ -0,0 +1,116 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/altinn/altinn-k8s-operator/internal/fakes/keyvault"
+	"github.com/altinn/altinn-k8s-operator/internal/operatorcontext"
+	"github.com/go-playground/validator/v10"
+	"github.com/knadh/koanf/providers/file"
+	"github.com/knadh/koanf/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestAzureKeyVaultSecretNames(t *testing.T) {
+	RegisterTestingT(t)
+
+	keys := ConfigKeys()
+	Expect(keys).To(ContainElements(
+		"maskinporten_api.client_id",
+		"maskinporten_api.authority_url",
+		"maskinporten_api.self_service_url",
+		"maskinporten_api.jwk",
+		"maskinporten_api.scope",
+		"controller.requeue_after",
+	))
+	Expect(AzureKeyVaultSecretName("maskinporten_api.self_service_url")).To(Equal("maskinporten-api--self-service-url"))
+}
+
+func TestAzureKeyVaultUrl(t *testing.T) {
+	RegisterTestingT(t)
+
+	operatorContext := operatorcontext.DiscoverOrDie(context.Background())
+	operatorContext.OverrideEnvironment("tt02")
+	Expect(GetAzureKeyVaultUrl(operatorContext)).To(Equal("https://altinn-tt02-operator-kv.vault.azure.net"))
+
+	t.Setenv(EnvAzureKeyVaultUrl, "https://other.vault.azure.net")
+	Expect(GetAzureKeyVaultUrl(operatorContext)).To(Equal("https://other.vault.azure.net"))
+}
+
+func TestAzureKeyVaultHasParityWithKoanf(t *testing.T) {
+	RegisterTestingT(t)
+
+	operatorContext := operatorcontext.DiscoverOrDie(context.Background())
+	expected, err := GetConfig(operatorContext, ConfigSourceKoanf, "")
+	Expect(err).NotTo(HaveOccurred())
+
+	// Store every value from the local env file as a secret
+	k := koanf.New(".")
+	err = k.Load(file.Provider(path.Join(TryFindProjectRoot(), "local.env")), envParser)
+	Expect(err).NotTo(HaveOccurred())
+
+	server := keyvault.NewServer()
+	defer server.Close()
+	for key, value := range k.All() {
+		server.Set(AzureKeyVaultSecretName(key), fmt.Sprint(value))
+	}
+	client, err := server.NewClient()
+	Expect(err).NotTo(HaveOccurred())
+
+	cfg, err := loadLayers(func(k *koanf.Koanf) error {
+		return loadFromAzureKeyVaultClient(operatorContext, k, client)
+	}, nil)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(cfg).To(Equal(expected))
+}
+
+func TestAzureKeyVaultMissingSecretsFailValidation(t *testing.T) {
+	RegisterTestingT(t)
+
+	operatorContext := operatorcontext.DiscoverOrDie(context.Background())
+
+	server := keyvault.NewServer()
+	defer server.Close()
+	server.Set(AzureKeyVaultSecretName("maskinporten_api.client_id"), "client-id")
+	server.Set(AzureKeyVaultSecretName("controller.requeue_after"), "1h")
+	client, err := server.NewClient()
+	Expect(err).NotTo(HaveOccurred())
+
+	k := koanf.New(".")
+	err = loadFromAzureKeyVaultClient(operatorContext, k, client)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(k.String("maskinporten_api.client_id")).To(Equal("client-id"))
+	Expect(k.Duration("controller.requeue_after").Hours()).To(Equal(1.0))
+
+	cfg, err := loadLayers(func(k *koanf.Koanf) error {
+		return loadFromAzureKeyVaultClient(operatorContext, k, client)
+	}, nil)
+	Expect(cfg).To(BeNil())
+	Expect(err).To(HaveOccurred())
+	_, ok := err.(validator.ValidationErrors)
+	Expect(ok).To(BeTrue())
+}
+
+func TestAzureKeyVaultLoadsProfiles(t *testing.T) {
+	RegisterTestingT(t)
+
+	operatorContext := operatorcontext.DiscoverOrDie(context.Background())
+
+	server := keyvault.NewServer()
+	defer server.Close()
+	server.Set(AzureKeyVaultSecretName("maskinporten_profiles"), "ver2, test")
+	server.Set(AzureKeyVaultSecretName("maskinporten_profiles.ver2.client_id"), "ver2-client")
+	server.Set(AzureKeyVaultSecretName("maskinporten_profiles.test.client_id"), "test-client")
+	client, err := server.NewClient()
+	Expect(err).NotTo(HaveOccurred())
+
+	k := koanf.New(".")
+	err = loadFromAzureKeyVaultClient(operatorContext, k, client)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(AzureKeyVaultSecretName("maskinporten_profiles.ver2.client_id")).To(Equal("maskinporten-profiles--ver2--client-id"))
+	Expect(k.String("maskinporten_profiles.ver2.client_id")).To(Equal("ver2-client"))
+	Expect(k.String("maskinporten_profiles.test.client_id")).To(Equal("test-client"))
+}
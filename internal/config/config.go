@@ -2,27 +2,129 @@ package config
 
 import (
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/altinn/altinn-k8s-operator/internal/operatorcontext"
 	"github.com/go-playground/validator/v10"
+	"github.com/knadh/koanf/v2"
 )
 
 type Config struct {
+	// The default Maskinporten profile, used when `spec.profile` is unset or 'default'
 	MaskinportenApi MaskinportenApiConfig `koanf:"maskinporten_api" validate:"required"`
-	Controller      ControllerConfig      `koanf:"controller"       validate:"required"`
+	// Additional named Maskinporten profiles, e.g. for clusters where some apps use another Maskinporten environment
+	MaskinportenProfiles map[string]MaskinportenApiConfig `koanf:"maskinporten_profiles" validate:"dive,keys,alphanum,ne=default,endkeys,required"`
+	Controller           ControllerConfig                 `koanf:"controller"            validate:"required"`
+	KeyStore             KeyStoreConfig                   `koanf:"key_store"`
+	CA                   CAConfig                         `koanf:"ca"`
+	TokenService         TokenServiceConfig               `koanf:"token_service"`
+	OrphanGC             OrphanGCConfig                   `koanf:"orphan_gc"`
 }
 
 type MaskinportenApiConfig struct {
 	ClientId       string `koanf:"client_id"        validate:"required"`
 	AuthorityUrl   string `koanf:"authority_url"    validate:"required,http_url"`
 	SelfServiceUrl string `koanf:"self_service_url" validate:"required,http_url"`
-	Jwk            string `koanf:"jwk"              validate:"required,json"`
+	Jwk            string `koanf:"jwk"              validate:"required,json" secret:"true"`
 	Scope          string `koanf:"scope"            validate:"required"`
 }
 
+// Name of the profile configured by `Config.MaskinportenApi`
+const DefaultMaskinportenProfile = "default"
+
+// NormalizeMaskinportenProfile maps an unset profile to `DefaultMaskinportenProfile`
+func NormalizeMaskinportenProfile(name string) string {
+	if name == "" {
+		return DefaultMaskinportenProfile
+	}
+	return name
+}
+
+// MaskinportenProfile returns the Maskinporten config of the named profile
+func (c *Config) MaskinportenProfile(name string) (*MaskinportenApiConfig, error) {
+	name = NormalizeMaskinportenProfile(name)
+	if name == DefaultMaskinportenProfile {
+		return &c.MaskinportenApi, nil
+	}
+	profile, ok := c.MaskinportenProfiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown Maskinporten profile: '%s'", name)
+	}
+	return &profile, nil
+}
+
+// MaskinportenProfileNames returns the names of all configured profiles, including the default
+func (c *Config) MaskinportenProfileNames() []string {
+	names := []string{DefaultMaskinportenProfile}
+	for name := range c.MaskinportenProfiles {
+		names = append(names, name)
+	}
+	slices.Sort(names[1:])
+	return names
+}
+
 type ControllerConfig struct {
 	RequeueAfter time.Duration `koanf:"requeue_after" validate:"required,min=5s,max=72h"`
+	// DryRun plans reconciliation for every MaskinportenClient without executing anything,
+	// see `resourcesv1alpha1.DryRunAnnotation` for single resources.
+	// Deleted resources are removed without cleaning up their clients and secrets
+	DryRun bool `koanf:"dry_run"`
+}
+
+// KeyStoreConfig decides where private keys for app clients are kept.
+// Defaults to the app secret when no backend is configured.
+// Switching back to the secret from an external backend replaces the keys of every client,
+// since the keys referenced from app secrets can't be read anymore.
+type KeyStoreConfig struct {
+	Backend          string `koanf:"backend"             validate:"omitempty,oneof=secret azure_key_vault"`
+	AzureKeyVaultUrl string `koanf:"azure_key_vault_url" validate:"required_if=Backend azure_key_vault,omitempty,http_url"`
+}
+
+// CAConfig configures the optional operator CA, which signs the per-app certificates.
+// App certificates are self-signed when not configured, see `cmd/utils create ca` for bootstrapping.
+type CAConfig struct {
+	// PEM encoded certificate chain, starting with the CA certificate
+	Cert string `koanf:"cert" validate:"required_with=Key"`
+	// PEM encoded private key of the CA certificate (PKCS#8, PKCS#1 or SEC 1), RSA and ECDSA keys are supported
+	Key string `koanf:"key" validate:"required_with=Cert" secret:"true"`
+}
+
+// validateConfig checks rules spanning several sections of the config
+func validateConfig(sl validator.StructLevel) {
+	cfg := sl.Current().Interface().(Config)
+	if cfg.TokenService.Enabled && (cfg.KeyStore.Backend == "" || cfg.KeyStore.Backend == "secret") {
+		// Apps could read the private keys from their secret, defeating the purpose of the token service
+		sl.ReportError(cfg.TokenService.Enabled, "TokenService.Enabled", "Enabled", "external_key_store", "")
+	}
+}
+
+func (c *CAConfig) Enabled() bool {
+	return c.Cert != ""
+}
+
+// TokenServiceConfig configures the optional token service, which mints Maskinporten
+// access tokens on behalf of apps so that private keys never have to leave the operator.
+// Requires an external key store, the secret key store writes private keys into app secrets.
+type TokenServiceConfig struct {
+	Enabled     bool   `koanf:"enabled"`
+	BindAddress string `koanf:"bind_address" validate:"required_if=Enabled true"`
+	// Audience expected in ServiceAccount tokens presented by apps, empty means the API server default
+	Audience string `koanf:"audience"`
+	// How long minted access tokens are served from cache, at most until shortly before they expire
+	CacheDuration time.Duration `koanf:"cache_duration" validate:"required_if=Enabled true,omitempty,min=1s,max=1h"`
+}
+
+// OrphanGCConfig configures the sweeper finding Maskinporten clients owned by this operator
+// which no longer have a MaskinportenClient resource, e.g. after the finalizer was removed by force.
+// Orphans are only reported unless `Delete` is enabled. The operator-wide `ControllerConfig.DryRun`
+// also applies here, no orphans are deleted while it is on.
+type OrphanGCConfig struct {
+	Enabled  bool          `koanf:"enabled"`
+	Delete   bool          `koanf:"delete"`
+	Interval time.Duration `koanf:"interval"     validate:"required_if=Enabled true,omitempty,min=1m"`
+	// How long a client must have been orphaned before it is deleted
+	GracePeriod time.Duration `koanf:"grace_period" validate:"required_if=Enabled true,omitempty,min=1m"`
 }
 
 type ConfigSource int
@@ -33,41 +135,55 @@ const (
 	ConfigSourceAureKeyVault
 )
 
-func GetConfig(operatorContext *operatorcontext.Context, source ConfigSource, configFilePath string) (*Config, error) {
-	span := operatorContext.StartSpan("GetConfig")
-	defer span.End()
-
-	var cfg *Config
-	var err error
-	if source == ConfigSourceKoanf {
-		cfg, err = loadFromKoanf(operatorContext, configFilePath)
-	} else if source == ConfigSourceAureKeyVault {
-		cfg, err = loadFromAzureKeyVault(operatorContext)
-	} else if source == ConfigSourceDefault {
+// ResolveConfigSource resolves `ConfigSourceDefault` to the concrete source for the environment:
+// an env file for local and dev, Azure Key Vault for the other known environments
+func ResolveConfigSource(operatorContext *operatorcontext.Context, source ConfigSource) (ConfigSource, error) {
+	switch source {
+	case ConfigSourceKoanf, ConfigSourceAureKeyVault:
+		return source, nil
+	case ConfigSourceDefault:
 		if operatorContext.Environment == operatorcontext.EnvironmentLocal {
-			cfg, err = loadFromKoanf(operatorContext, configFilePath)
+			return ConfigSourceKoanf, nil
 		} else if operatorContext.Environment == operatorcontext.EnvironmentDev {
-			cfg, err = loadFromKoanf(operatorContext, configFilePath)
-		} else {
-			return nil, fmt.Errorf("could not resolve default config source for env: %s", operatorContext.Environment)
+			return ConfigSourceKoanf, nil
+		} else if slices.Contains(operatorcontext.KnownEnvironments, operatorContext.Environment) {
+			return ConfigSourceAureKeyVault, nil
 		}
-	} else {
-		return nil, fmt.Errorf("invalid config source: %d", source)
+		return source, fmt.Errorf("could not resolve default config source for env: %s", operatorContext.Environment)
+	default:
+		return source, fmt.Errorf("invalid config source: %d", source)
 	}
+}
 
-	if err != nil {
-		return nil, err
-	}
+func GetConfig(operatorContext *operatorcontext.Context, source ConfigSource, configFilePath string) (*Config, error) {
+	return GetConfigWithOverrides(operatorContext, source, configFilePath, nil)
+}
 
-	validate := validator.New(validator.WithRequiredStructEnabled())
+// GetConfigWithOverrides loads and validates the config from layered sources, later layers take precedence:
+//   - built-in defaults for optional settings
+//   - the config file (koanf) or Azure Key Vault, depending on the source
+//   - `ALTINN_OPERATOR_*` environment variables, see `EnvPrefix`
+//   - overrides, usually from command-line flags, see `BindFlags`
+func GetConfigWithOverrides(
+	operatorContext *operatorcontext.Context,
+	source ConfigSource,
+	configFilePath string,
+	overrides Overrides,
+) (*Config, error) {
+	span := operatorContext.StartSpan("GetConfig")
+	defer span.End()
 
-	if err := validate.Struct(cfg); err != nil {
+	source, err := ResolveConfigSource(operatorContext, source)
+	if err != nil {
 		return nil, err
 	}
 
-	// k.Print() // Uncomment to print the config, only for debug, there be secrets
-
-	return cfg, nil
+	return loadLayers(func(k *koanf.Koanf) error {
+		if source == ConfigSourceKoanf {
+			return loadFromKoanf(operatorContext, k, configFilePath)
+		}
+		return loadFromAzureKeyVault(operatorContext, k)
+	}, overrides)
 }
 
 func GetConfigOrDie(operatorContext *operatorcontext.Context, source ConfigSource, configFilePath string) *Config {
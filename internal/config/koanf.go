@@ -7,19 +7,37 @@ import (
 
 	"github.com/altinn/altinn-k8s-operator/internal/operatorcontext"
 	"github.com/knadh/koanf/parsers/dotenv"
+	"github.com/knadh/koanf/parsers/yaml"
 	"github.com/knadh/koanf/providers/file"
 	"github.com/knadh/koanf/v2"
 )
 
-var (
-	k      = koanf.New(".")
-	parser = dotenv.ParserEnv("", ".", func(s string) string { return s })
-)
+var envParser = dotenv.ParserEnv("", ".", func(s string) string { return s })
 
-func loadFromKoanf(operatorContext *operatorcontext.Context, configFilePath string) (*Config, error) {
+// loadFromKoanf loads an env file, or a YAML file if the extension is `.yaml` or `.yml`
+func loadFromKoanf(operatorContext *operatorcontext.Context, k *koanf.Koanf, configFilePath string) error {
 	span := operatorContext.StartSpan("GetConfig.Koanf")
 	defer span.End()
 
+	configFilePath, err := resolveConfigFilePath(operatorContext, configFilePath)
+	if err != nil {
+		return err
+	}
+
+	var parser koanf.Parser = envParser
+	switch path.Ext(configFilePath) {
+	case ".yaml", ".yml":
+		parser = yaml.Parser()
+	}
+
+	if err := k.Load(file.Provider(configFilePath), parser); err != nil {
+		return fmt.Errorf("error loading config '%s': %w", configFilePath, err)
+	}
+
+	return nil
+}
+
+func resolveConfigFilePath(operatorContext *operatorcontext.Context, configFilePath string) (string, error) {
 	rootDir := TryFindProjectRoot()
 
 	if configFilePath == "" {
@@ -27,14 +45,14 @@ func loadFromKoanf(operatorContext *operatorcontext.Context, configFilePath stri
 	}
 
 	if !operatorContext.IsLocal() && !operatorContext.IsDev() {
-		return nil, fmt.Errorf("loading config from koanf is only supported for local environment")
+		return "", fmt.Errorf("loading config from koanf is only supported for local environment")
 	}
 
 	if _, err := os.Stat(configFilePath); os.IsNotExist(err) {
 		if path.IsAbs(configFilePath) {
-			return nil, fmt.Errorf("env file does not exist: '%s'", configFilePath)
+			return "", fmt.Errorf("env file does not exist: '%s'", configFilePath)
 		} else {
-			return nil, fmt.Errorf("env file does not exist in '%s': '%s'", rootDir, configFilePath)
+			return "", fmt.Errorf("env file does not exist in '%s': '%s'", rootDir, configFilePath)
 		}
 	}
 
@@ -42,15 +60,5 @@ func loadFromKoanf(operatorContext *operatorcontext.Context, configFilePath stri
 		configFilePath = path.Join(rootDir, configFilePath)
 	}
 
-	if err := k.Load(file.Provider(configFilePath), parser); err != nil {
-		return nil, fmt.Errorf("error loading config '%s': %w", configFilePath, err)
-	}
-
-	var cfg Config
-
-	if err := k.Unmarshal("", &cfg); err != nil {
-		return nil, fmt.Errorf("error unmarshalling config '%s': %w", configFilePath, err)
-	}
-
-	return &cfg, nil
+	return configFilePath, nil
 }
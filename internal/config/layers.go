@@ -0,0 +1,171 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/knadh/koanf/providers/confmap"
+	"github.com/knadh/koanf/providers/env"
+	"github.com/knadh/koanf/v2"
+)
+
+// Environment variables with this prefix override config keys. Levels are separated by
+// a double underscore, so 'controller.requeue_after' is set with 'ALTINN_OPERATOR_CONTROLLER__REQUEUE_AFTER'
+const EnvPrefix = "ALTINN_OPERATOR_"
+
+const redacted = "[redacted]"
+
+// Defaults for optional settings, every other layer takes precedence
+var defaults = map[string]any{
+	"key_store.backend":            "secret",
+	"token_service.bind_address":   ":8090",
+	"token_service.cache_duration": "1m",
+	"orphan_gc.enabled":            true,
+	"orphan_gc.interval":           "1h",
+	"orphan_gc.grace_period":       "24h",
+}
+
+// Overrides are config values taking precedence over all other layers, keyed by koanf key
+type Overrides map[string]string
+
+// EnvVarName maps a koanf config key to the environment variable overriding it
+func EnvVarName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "__"))
+}
+
+func envVarToKey(name string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimPrefix(name, EnvPrefix)), "__", ".")
+}
+
+// loadLayers loads the layers into a fresh koanf instance, so that nothing lingers between loads
+func loadLayers(loadSource func(k *koanf.Koanf) error, overrides Overrides) (*Config, error) {
+	k := koanf.New(".")
+
+	if err := k.Load(confmap.Provider(defaults, "."), nil); err != nil {
+		return nil, fmt.Errorf("error loading config defaults: %w", err)
+	}
+	if err := loadSource(k); err != nil {
+		return nil, err
+	}
+	if err := k.Load(env.Provider(EnvPrefix, ".", envVarToKey), nil); err != nil {
+		return nil, fmt.Errorf("error loading config from environment: %w", err)
+	}
+	values := make(map[string]any, len(overrides))
+	for key, value := range overrides {
+		values[key] = value
+	}
+	if err := k.Load(confmap.Provider(values, "."), nil); err != nil {
+		return nil, fmt.Errorf("error loading config overrides: %w", err)
+	}
+
+	var cfg Config
+	if err := k.Unmarshal("", &cfg); err != nil {
+		return nil, fmt.Errorf("error unmarshalling config: %w", err)
+	}
+
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	validate.RegisterStructValidation(validateConfig, Config{})
+
+	if err := validate.Struct(&cfg); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// Flags holds a command-line flag per config key, e.g. '-controller.requeue_after=1h'
+type Flags struct {
+	flagSet *flag.FlagSet
+	keys    map[string]bool
+}
+
+// BindFlags registers a flag per config key on the flag set.
+// Only flags passed on the command line override the config. Fields tagged `secret:"true"` get no flag,
+// since command-line arguments are visible in process listings and pod specs.
+func BindFlags(flagSet *flag.FlagSet) *Flags {
+	flags := &Flags{flagSet: flagSet, keys: make(map[string]bool)}
+	walkKoanfFields(reflect.ValueOf(Config{}), "", func(key string, field reflect.StructField, value reflect.Value) {
+		if field.Tag.Get("secret") == "true" {
+			return
+		}
+		flagSet.String(key, "", fmt.Sprintf("Overrides config '%s'", key))
+		flags.keys[key] = true
+	})
+	return flags
+}
+
+// Overrides returns the config keys set on the command line, call after parsing
+func (f *Flags) Overrides() Overrides {
+	overrides := make(Overrides)
+	f.flagSet.Visit(func(fl *flag.Flag) {
+		if f.keys[fl.Name] {
+			overrides[fl.Name] = fl.Value.String()
+		}
+	})
+	return overrides
+}
+
+// ConfigKeys returns the koanf keys of all leaf fields in `Config`, excluding named entries such as profiles
+func ConfigKeys() []string {
+	var keys []string
+	walkKoanfFields(reflect.ValueOf(Config{}), "", func(key string, field reflect.StructField, value reflect.Value) {
+		keys = append(keys, key)
+	})
+	return keys
+}
+
+// Redacted returns the config values by koanf key, with values of fields tagged `secret:"true"` redacted
+func (c *Config) Redacted() map[string]string {
+	values := make(map[string]string)
+	walkKoanfFields(reflect.ValueOf(*c), "", func(key string, field reflect.StructField, value reflect.Value) {
+		if field.Tag.Get("secret") == "true" && !value.IsZero() {
+			values[key] = redacted
+		} else {
+			values[key] = fmt.Sprint(value.Interface())
+		}
+	})
+	return values
+}
+
+// Hash returns a short fingerprint of the config, used to tell config versions apart
+// in metrics and logs. Fields tagged `secret:"true"` are left out, so the hash reveals nothing about them
+func (c *Config) Hash() string {
+	hash := sha256.New()
+	walkKoanfFields(reflect.ValueOf(*c), "", func(key string, field reflect.StructField, value reflect.Value) {
+		if field.Tag.Get("secret") == "true" {
+			return
+		}
+		fmt.Fprintf(hash, "%s=%v\n", key, value.Interface())
+	})
+	return hex.EncodeToString(hash.Sum(nil)[:8])
+}
+
+func walkKoanfFields(v reflect.Value, prefix string, fn func(key string, field reflect.StructField, value reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("koanf")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		key := prefix + tag
+		if field.Type.Kind() == reflect.Struct && field.Type.PkgPath() == t.PkgPath() {
+			walkKoanfFields(v.Field(i), key+".", fn)
+		} else if field.Type.Kind() == reflect.Map && field.Type.Elem().Kind() == reflect.Struct {
+			// Named entries such as Maskinporten profiles, only present when set
+			entries := v.Field(i).MapKeys()
+			slices.SortFunc(entries, func(a, b reflect.Value) int { return strings.Compare(a.String(), b.String()) })
+			for _, entry := range entries {
+				walkKoanfFields(v.Field(i).MapIndex(entry), key+"."+entry.String()+".", fn)
+			}
+		} else {
+			fn(key, field, v.Field(i))
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package config
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/altinn/altinn-k8s-operator/internal/operatorcontext"
+	. "github.com/onsi/gomega"
+)
+
+func TestLayersPrecedence(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext := operatorcontext.DiscoverOrDie(context.Background())
+
+	// Defaults apply when nothing else sets the key
+	cfg, err := GetConfig(operatorContext, ConfigSourceKoanf, "")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(cfg.KeyStore.Backend).To(Equal("secret"))
+	g.Expect(cfg.Controller.RequeueAfter).To(Equal(24 * time.Hour))
+
+	// Environment overrides the file
+	t.Setenv(EnvVarName("controller.requeue_after"), "2h")
+	t.Setenv(EnvVarName("maskinporten_api.scope"), "scope:env")
+	cfg, err = GetConfig(operatorContext, ConfigSourceKoanf, "")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(cfg.Controller.RequeueAfter).To(Equal(2 * time.Hour))
+	g.Expect(cfg.MaskinportenApi.Scope).To(Equal("scope:env"))
+
+	// Overrides win over everything
+	cfg, err = GetConfigWithOverrides(operatorContext, ConfigSourceKoanf, "", Overrides{
+		"controller.requeue_after": "3h",
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(cfg.Controller.RequeueAfter).To(Equal(3 * time.Hour))
+	g.Expect(cfg.MaskinportenApi.Scope).To(Equal("scope:env"))
+
+	// Overridden values are validated as well
+	_, err = GetConfigWithOverrides(operatorContext, ConfigSourceKoanf, "", Overrides{
+		"controller.requeue_after": "1s",
+	})
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestLayersLoadYamlFile(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext := operatorcontext.DiscoverOrDie(context.Background())
+	expected, err := GetConfig(operatorContext, ConfigSourceKoanf, "")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	filePath := path.Join(t.TempDir(), "local.yaml")
+	yaml := "maskinporten_api:\n" +
+		"  client_id: " + expected.MaskinportenApi.ClientId + "\n" +
+		"  authority_url: " + expected.MaskinportenApi.AuthorityUrl + "\n" +
+		"  self_service_url: " + expected.MaskinportenApi.SelfServiceUrl + "\n" +
+		"  jwk: '" + expected.MaskinportenApi.Jwk + "'\n" +
+		"  scope: " + expected.MaskinportenApi.Scope + "\n" +
+		"controller:\n" +
+		"  requeue_after: 24h\n"
+	g.Expect(os.WriteFile(filePath, []byte(yaml), 0o600)).To(Succeed())
+
+	cfg, err := GetConfig(operatorContext, ConfigSourceKoanf, filePath)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(cfg).To(Equal(expected))
+}
+
+func TestTokenServiceRequiresExternalKeyStore(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext := operatorcontext.DiscoverOrDie(context.Background())
+	_, err := GetConfigWithOverrides(operatorContext, ConfigSourceKoanf, "", Overrides{
+		"token_service.enabled": "true",
+	})
+	g.Expect(err).To(MatchError(ContainSubstring("external_key_store")))
+
+	cfg, err := GetConfigWithOverrides(operatorContext, ConfigSourceKoanf, "", Overrides{
+		"token_service.enabled":         "true",
+		"key_store.backend":             "azure_key_vault",
+		"key_store.azure_key_vault_url": "https://example.vault.azure.net",
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(cfg.TokenService.Enabled).To(BeTrue())
+}
+
+func TestFlagsOnlyOverrideWhenSet(t *testing.T) {
+	g := NewWithT(t)
+
+	flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := BindFlags(flagSet)
+	g.Expect(flagSet.Lookup("maskinporten_api.client_id")).NotTo(BeNil())
+	// Secrets can't be passed on the command line
+	g.Expect(flagSet.Lookup("maskinporten_api.jwk")).To(BeNil())
+	g.Expect(flagSet.Lookup("ca.key")).To(BeNil())
+
+	err := flagSet.Parse([]string{"-controller.requeue_after=1h", "-token_service.enabled=true"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(flags.Overrides()).To(Equal(Overrides{
+		"controller.requeue_after": "1h",
+		"token_service.enabled":    "true",
+	}))
+}
+
+func TestRedactedHidesSecrets(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext := operatorcontext.DiscoverOrDie(context.Background())
+	cfg, err := GetConfig(operatorContext, ConfigSourceKoanf, "")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	values := cfg.Redacted()
+	g.Expect(values).To(HaveLen(len(ConfigKeys())))
+	g.Expect(values).To(HaveKeyWithValue("maskinporten_api.jwk", "[redacted]"))
+	g.Expect(values).To(HaveKeyWithValue("maskinporten_api.client_id", "altinn_apps_supplier_client"))
+	// Unset secrets are shown as empty, so that it's clear they are missing
+	g.Expect(values).To(HaveKeyWithValue("ca.key", ""))
+}
+
+func TestHashExcludesSecrets(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext := operatorcontext.DiscoverOrDie(context.Background())
+	initial, err := GetConfig(operatorContext, ConfigSourceKoanf, "")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	changed := *initial
+	changed.MaskinportenApi.Jwk = `{"kid":"other"}`
+	changed.CA.Key = "other"
+	g.Expect(changed.Hash()).To(Equal(initial.Hash()))
+
+	changed.MaskinportenApi.ClientId = "other"
+	g.Expect(changed.Hash()).NotTo(Equal(initial.Hash()))
+}
+
+func TestMaskinportenProfiles(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext := operatorcontext.DiscoverOrDie(context.Background())
+	filePath := path.Join(t.TempDir(), "local.env")
+	writeTestEnvFile(g, filePath, func(line string) string { return line })
+	base, err := GetConfig(operatorContext, ConfigSourceKoanf, filePath)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	profile := "maskinporten_profiles.ver2."
+	content, err := os.ReadFile(filePath)
+	g.Expect(err).NotTo(HaveOccurred())
+	content = append(content, []byte("\n"+
+		profile+"client_id=ver2_client\n"+
+		profile+"authority_url=http://localhost:8060\n"+
+		profile+"self_service_url=http://localhost:8061\n"+
+		profile+"jwk="+base.MaskinportenApi.Jwk+"\n"+
+		profile+"scope=idporten:dcr.altinn\n")...)
+	g.Expect(os.WriteFile(filePath, content, 0o600)).To(Succeed())
+
+	cfg, err := GetConfig(operatorContext, ConfigSourceKoanf, filePath)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(cfg.MaskinportenProfileNames()).To(Equal([]string{DefaultMaskinportenProfile, "ver2"}))
+
+	defaultProfile, err := cfg.MaskinportenProfile("")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(*defaultProfile).To(Equal(cfg.MaskinportenApi))
+	ver2, err := cfg.MaskinportenProfile("ver2")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(ver2.ClientId).To(Equal("ver2_client"))
+	g.Expect(ver2.AuthorityUrl).To(Equal("http://localhost:8060"))
+	_, err = cfg.MaskinportenProfile("prod")
+	g.Expect(err).To(HaveOccurred())
+
+	values := cfg.Redacted()
+	g.Expect(values).To(HaveKeyWithValue("maskinporten_profiles.ver2.client_id", "ver2_client"))
+	g.Expect(values).To(HaveKeyWithValue("maskinporten_profiles.ver2.jwk", "[redacted]"))
+
+	// Profiles are validated like the default one
+	_, err = GetConfigWithOverrides(operatorContext, ConfigSourceKoanf, filePath, Overrides{
+		profile + "authority_url": "not a url",
+	})
+	g.Expect(err).To(HaveOccurred())
+	// The default profile name is reserved
+	_, err = GetConfigWithOverrides(operatorContext, ConfigSourceKoanf, filePath, Overrides{
+		"maskinporten_profiles.default.client_id": "client",
+	})
+	g.Expect(err).To(HaveOccurred())
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/altinn/altinn-k8s-operator/internal/operatorcontext"
+	"github.com/jonboulle/clockwork"
+	"github.com/knadh/koanf/providers/file"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// How often Azure Key Vault is polled for config changes
+const DefaultPollInterval = 5 * time.Minute
+
+// Watcher reloads the config when the source changes and passes valid configs to `onReload`.
+// Env files (koanf) are watched for file changes, Azure Key Vault is polled.
+// Configs failing to load or validate are discarded, the current config stays active.
+type Watcher struct {
+	operatorContext *operatorcontext.Context
+	source          ConfigSource
+	configFilePath  string
+	clock           clockwork.Clock
+	pollInterval    time.Duration
+	load            func() (*Config, error)
+	onReload        func(cfg *Config) error
+
+	mutex   sync.Mutex
+	current *Config
+}
+
+func NewWatcher(
+	operatorContext *operatorcontext.Context,
+	source ConfigSource,
+	configFilePath string,
+	overrides Overrides,
+	current *Config,
+	clock clockwork.Clock,
+	onReload func(cfg *Config) error,
+) (*Watcher, error) {
+	source, err := ResolveConfigSource(operatorContext, source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Watcher{
+		operatorContext: operatorContext,
+		source:          source,
+		configFilePath:  configFilePath,
+		clock:           clock,
+		pollInterval:    DefaultPollInterval,
+		load: func() (*Config, error) {
+			return GetConfigWithOverrides(operatorContext, source, configFilePath, overrides)
+		},
+		onReload: onReload,
+		current:  current,
+	}, nil
+}
+
+// Config reloads should happen on every replica, not just the leader
+func (w *Watcher) NeedLeaderElection() bool {
+	return false
+}
+
+func (w *Watcher) Start(ctx context.Context) error {
+	log := log.FromContext(ctx).WithName("config-watcher")
+
+	trigger := make(chan struct{}, 1)
+	var poll <-chan time.Time
+
+	if w.source == ConfigSourceKoanf {
+		configFilePath, err := resolveConfigFilePath(w.operatorContext, w.configFilePath)
+		if err != nil {
+			return err
+		}
+		// Editors and ConfigMap mounts emit several events per change,
+		// the buffered trigger coalesces them into a single reload
+		err = file.Provider(configFilePath).Watch(func(event interface{}, err error) {
+			if err != nil {
+				log.Error(err, "error watching config file", "path", configFilePath)
+				return
+			}
+			select {
+			case trigger <- struct{}{}:
+			default:
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("error watching config file '%s': %w", configFilePath, err)
+		}
+		log.Info("watching config file for changes", "path", configFilePath)
+	} else {
+		ticker := w.clock.NewTicker(w.pollInterval)
+		defer ticker.Stop()
+		poll = ticker.Chan()
+		log.Info("polling config for changes", "interval", w.pollInterval)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-trigger:
+		case <-poll:
+		}
+
+		reloaded, err := w.Reload()
+		if err != nil {
+			log.Error(err, "error reloading config, keeping current config")
+		} else if reloaded {
+			log.Info("reloaded config", "hash", w.Current().Hash())
+		}
+	}
+}
+
+// Current returns the config most recently passed to `onReload`
+func (w *Watcher) Current() *Config {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.current
+}
+
+// Reload loads the config and passes it to `onReload` if it has changed.
+// Returns true if a new config was applied.
+func (w *Watcher) Reload() (bool, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	cfg, err := w.load()
+	if err != nil {
+		return false, err
+	}
+	if w.current != nil && reflect.DeepEqual(*cfg, *w.current) {
+		return false, nil
+	}
+	if err := w.onReload(cfg); err != nil {
+		return false, err
+	}
+
+	w.current = cfg
+	return true, nil
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/altinn/altinn-k8s-operator/internal/operatorcontext"
+	"github.com/go-errors/errors"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/gomega"
+)
+
+func writeTestEnvFile(g *WithT, filePath string, replace func(line string) string) {
+	data, err := os.ReadFile(path.Join(TryFindProjectRoot(), "local.env"))
+	g.Expect(err).NotTo(HaveOccurred())
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		lines[i] = replace(line)
+	}
+	g.Expect(os.WriteFile(filePath, []byte(strings.Join(lines, "\n")), 0o600)).To(Succeed())
+}
+
+func TestWatcherReloadsChangedEnvFile(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext := operatorcontext.DiscoverOrDie(context.Background())
+	filePath := path.Join(t.TempDir(), "local.env")
+	writeTestEnvFile(g, filePath, func(line string) string { return line })
+	initial, err := GetConfig(operatorContext, ConfigSourceKoanf, filePath)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	reloads := make(chan *Config, 16)
+	watcher, err := NewWatcher(operatorContext, ConfigSourceKoanf, filePath, nil, initial, clockwork.NewRealClock(), func(cfg *Config) error {
+		reloads <- cfg
+		return nil
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = watcher.Start(ctx)
+	}()
+
+	// The watch is set up asynchronously, so keep rewriting until the change is picked up
+	g.Eventually(func() *Config {
+		writeTestEnvFile(g, filePath, func(line string) string {
+			if strings.HasPrefix(line, "controller.requeue_after=") {
+				return "controller.requeue_after=1h"
+			}
+			return line
+		})
+		select {
+		case cfg := <-reloads:
+			return cfg
+		case <-time.After(100 * time.Millisecond):
+			return nil
+		}
+	}, 5*time.Second).Should(HaveField("Controller.RequeueAfter", time.Hour))
+	g.Expect(watcher.Current().Controller.RequeueAfter).To(Equal(time.Hour))
+
+	// Invalid config is not applied
+	writeTestEnvFile(g, filePath, func(line string) string {
+		if strings.HasPrefix(line, "maskinporten_api.jwk=") {
+			return ""
+		}
+		return line
+	})
+	g.Consistently(reloads, 500*time.Millisecond).ShouldNot(Receive())
+	g.Expect(watcher.Current().Controller.RequeueAfter).To(Equal(time.Hour))
+}
+
+func TestWatcherPollsAzureKeyVault(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext := operatorcontext.DiscoverOrDie(context.Background())
+	initial, err := GetConfig(operatorContext, ConfigSourceKoanf, "")
+	g.Expect(err).NotTo(HaveOccurred())
+	clock := clockwork.NewFakeClock()
+
+	reloads := make(chan *Config, 16)
+	watcher, err := NewWatcher(operatorContext, ConfigSourceAureKeyVault, "", nil, initial, clock, func(cfg *Config) error {
+		reloads <- cfg
+		return nil
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	var loaded atomic.Pointer[Config]
+	loaded.Store(initial)
+	watcher.load = func() (*Config, error) {
+		cfg := *loaded.Load()
+		return &cfg, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = watcher.Start(ctx)
+	}()
+	clock.BlockUntil(1)
+
+	// Unchanged config is not reapplied
+	clock.Advance(DefaultPollInterval)
+	g.Consistently(reloads, 100*time.Millisecond).ShouldNot(Receive())
+
+	changed := *initial
+	changed.Controller.RequeueAfter = time.Hour
+	loaded.Store(&changed)
+	clock.Advance(DefaultPollInterval)
+	var cfg *Config
+	g.Eventually(reloads).Should(Receive(&cfg))
+	g.Expect(cfg.Controller.RequeueAfter).To(Equal(time.Hour))
+	g.Expect(cfg.Hash()).NotTo(Equal(initial.Hash()))
+}
+
+func TestWatcherKeepsCurrentConfigWhenReloadFails(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext := operatorcontext.DiscoverOrDie(context.Background())
+	initial, err := GetConfig(operatorContext, ConfigSourceKoanf, "")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	watcher, err := NewWatcher(operatorContext, ConfigSourceKoanf, "", nil, initial, clockwork.NewRealClock(), func(cfg *Config) error {
+		return errors.New("rejected")
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	watcher.load = func() (*Config, error) {
+		cfg := *initial
+		cfg.Controller.RequeueAfter = time.Hour
+		return &cfg, nil
+	}
+
+	reloaded, err := watcher.Reload()
+	g.Expect(err).To(MatchError("rejected"))
+	g.Expect(reloaded).To(BeFalse())
+	g.Expect(watcher.Current()).To(BeIdenticalTo(initial))
+}
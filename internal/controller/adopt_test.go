@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	resourcesv1alpha1 "github.com/altinn/altinn-k8s-operator/api/v1alpha1"
+	"github.com/altinn/altinn-k8s-operator/internal"
+	"github.com/altinn/altinn-k8s-operator/internal/config"
+	"github.com/altinn/altinn-k8s-operator/internal/fakes"
+	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
+)
+
+var errJwksUploadFailed = errors.New("injected JWKS upload failure")
+
+// The adopted client is renamed before the keys are uploaded. Once renamed it is no longer found
+// through the annotation, so the adoption must be recorded even if the upload fails
+func TestAdoptionIsRecordedWhenKeyUploadFails(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	runtime, err := internal.NewRuntime(ctx, "", "", nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	cfg := *runtime.GetConfig()
+	operatorContext := runtime.GetOperatorContext()
+	apiClient := fakes.NewApiClient(fakes.NewDb(runtime.GetClock()), operatorContext)
+	testRuntime := &profileTestRuntime{
+		Runtime:    runtime,
+		apiClients: map[string]*fakes.ApiClient{config.DefaultMaskinportenProfile: apiClient},
+		config:     &cfg,
+	}
+
+	handMadeName := "Client registered by hand"
+	handMade, err := apiClient.Db().Insert(&maskinporten.AddClientRequest{
+		ClientName:  &handMadeName,
+		ClientOrgno: &operatorContext.ServiceOwnerOrgNo,
+		Scopes:      []string{"altinn:serviceowner"},
+	}, nil, "")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	scheme := k8sruntime.NewScheme()
+	g.Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	g.Expect(resourcesv1alpha1.AddToScheme(scheme)).To(Succeed())
+	labels := map[string]string{"app": "local-simapp-deployment"}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithStatusSubresource(&resourcesv1alpha1.MaskinportenClient{}).
+		WithObjects(
+			&resourcesv1alpha1.MaskinportenClient{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "local-simapp",
+					Namespace:   "default",
+					Labels:      labels,
+					Annotations: map[string]string{resourcesv1alpha1.AdoptClientIdAnnotation: handMade.ClientId},
+				},
+				Spec: resourcesv1alpha1.MaskinportenClientSpec{Scopes: []string{"altinn:serviceowner"}},
+			},
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "local-simapp-deployment-secrets", Namespace: "default", Labels: labels},
+				Type:       corev1.SecretTypeOpaque,
+			},
+		).
+		Build()
+
+	reconciler, err := NewMaskinportenClientReconciler(testRuntime, k8sClient, scheme, nil, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	name := types.NamespacedName{Name: "local-simapp", Namespace: "default"}
+	reconcile := func() error {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: name})
+		return err
+	}
+
+	apiClient.OnCall = func(method string) error {
+		if method == "CreateClientJwks" {
+			return errJwksUploadFailed
+		}
+		return nil
+	}
+	g.Expect(reconcile()).To(MatchError(errJwksUploadFailed))
+
+	resource := &resourcesv1alpha1.MaskinportenClient{}
+	g.Expect(k8sClient.Get(ctx, name, resource)).To(Succeed())
+	g.Expect(resource.Status.Origin).To(Equal(resourcesv1alpha1.ClientOriginAdopted))
+	g.Expect(resource.Status.ClientId).To(Equal(handMade.ClientId))
+	renamed := apiClient.Db().Get(handMade.ClientId)
+	g.Expect(*renamed.Client.ClientName).To(Equal(maskinporten.GetClientName(operatorContext, "simapp")))
+
+	// The retry finds the renamed client by name, and only uploads new keys
+	apiClient.OnCall = nil
+	g.Expect(reconcile()).To(Succeed())
+
+	g.Expect(k8sClient.Get(ctx, name, resource)).To(Succeed())
+	g.Expect(resource.Status.Origin).To(Equal(resourcesv1alpha1.ClientOriginAdopted))
+	g.Expect(resource.Status.ClientId).To(Equal(handMade.ClientId))
+	g.Expect(apiClient.Db().Query(func(*fakes.ClientRecord) bool { return true })).To(HaveLen(1))
+
+	secret := &corev1.Secret{}
+	secretName := types.NamespacedName{Name: "local-simapp-deployment-secrets", Namespace: "default"}
+	g.Expect(k8sClient.Get(ctx, secretName, secret)).To(Succeed())
+	content, err := maskinporten.DeserializeSecretStateContent(secret)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(content.ClientId).To(Equal(handMade.ClientId))
+	uploaded := apiClient.Db().Get(handMade.ClientId).Jwks
+	g.Expect(uploaded).NotTo(BeNil())
+	g.Expect(uploaded.Keys[0].KeyID()).To(Equal(content.Jwk.KeyID()))
+}
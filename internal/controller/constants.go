@@ -1,3 +1,15 @@
 package controller
 
 const UnkownStr = "Unknown"
+
+// Values of `MaskinportenClientStatus.State`
+const (
+	StateRecorded   = "recorded"
+	StateReconciled = "reconciled"
+	StateSuspended  = "suspended"
+	StateDryRun     = "dryRun"
+	StateError      = "error"
+)
+
+// Event reason for the actions planned while reconciliation is suspended or in dry-run
+const EventReasonPlanned = "Planned"
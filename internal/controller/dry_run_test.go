@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	resourcesv1alpha1 "github.com/altinn/altinn-k8s-operator/api/v1alpha1"
+	"github.com/altinn/altinn-k8s-operator/internal"
+	"github.com/altinn/altinn-k8s-operator/internal/config"
+	"github.com/altinn/altinn-k8s-operator/internal/fakes"
+	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
+)
+
+// Operator-wide dry-run must not keep deleted resources around, even though nothing is cleaned up
+func TestDryRunStillRemovesFinalizer(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	runtime, err := internal.NewRuntime(ctx, "", "", nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	cfg := *runtime.GetConfig()
+	testRuntime := &profileTestRuntime{
+		Runtime: runtime,
+		apiClients: map[string]*fakes.ApiClient{
+			config.DefaultMaskinportenProfile: fakes.NewApiClient(fakes.NewDb(runtime.GetClock()), runtime.GetOperatorContext()),
+		},
+		config: &cfg,
+	}
+
+	scheme := k8sruntime.NewScheme()
+	g.Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	g.Expect(resourcesv1alpha1.AddToScheme(scheme)).To(Succeed())
+	labels := map[string]string{"app": "local-simapp-deployment"}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithStatusSubresource(&resourcesv1alpha1.MaskinportenClient{}).
+		WithObjects(
+			&resourcesv1alpha1.MaskinportenClient{
+				ObjectMeta: metav1.ObjectMeta{Name: "local-simapp", Namespace: "default", Labels: labels},
+				Spec:       resourcesv1alpha1.MaskinportenClientSpec{Scopes: []string{"altinn:serviceowner"}},
+			},
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "local-simapp-deployment-secrets", Namespace: "default", Labels: labels},
+				Type:       corev1.SecretTypeOpaque,
+			},
+		).
+		Build()
+
+	reconciler, err := NewMaskinportenClientReconciler(testRuntime, k8sClient, scheme, nil, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	name := types.NamespacedName{Name: "local-simapp", Namespace: "default"}
+	reconcile := func() error {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: name})
+		return err
+	}
+	clients := func() []fakes.ClientRecord {
+		return testRuntime.apiClients[config.DefaultMaskinportenProfile].Db().Query(func(*fakes.ClientRecord) bool { return true })
+	}
+
+	g.Expect(reconcile()).To(Succeed())
+	g.Expect(clients()).To(HaveLen(1))
+	resource := &resourcesv1alpha1.MaskinportenClient{}
+	g.Expect(k8sClient.Get(ctx, name, resource)).To(Succeed())
+	g.Expect(resource.Finalizers).To(ContainElement(FinalizerName))
+
+	cfg.Controller.DryRun = true
+	g.Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+	g.Expect(reconcile()).To(Succeed())
+
+	err = k8sClient.Get(ctx, name, resource)
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue(), "resource should be deleted, got %v", err)
+	// Only the finalizer is handled, the client and secret are left as they were
+	g.Expect(clients()).To(HaveLen(1))
+	secret := &corev1.Secret{}
+	secretName := types.NamespacedName{Name: "local-simapp-deployment-secrets", Namespace: "default"}
+	g.Expect(k8sClient.Get(ctx, secretName, secret)).To(Succeed())
+	content, err := maskinporten.DeserializeSecretStateContent(secret)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(content).NotTo(BeNil())
+	g.Expect(content.ClientId).To(Equal(clients()[0].Client.ClientId))
+}
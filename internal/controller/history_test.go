@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	resourcesv1alpha1 "github.com/altinn/altinn-k8s-operator/api/v1alpha1"
+	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
+)
+
+func TestAppendHistoryKeepsMostRecentActions(t *testing.T) {
+	g := NewWithT(t)
+
+	var history []resourcesv1alpha1.ActionRecord
+	for generation := int64(1); generation <= resourcesv1alpha1.MaxActionHistory; generation++ {
+		commands := maskinporten.CommandList{
+			&maskinporten.DeleteClientInApiCommand{Profile: "default", ClientId: "client-id"},
+		}
+		history = appendHistory(history, commands, metav1.Now(), generation)
+	}
+	g.Expect(history).To(HaveLen(resourcesv1alpha1.MaxActionHistory))
+
+	commands := maskinporten.CommandList{
+		&maskinporten.DeleteClientInApiCommand{Profile: "default", ClientId: "client-id"},
+		&maskinporten.DeleteSecretContentCommand{},
+	}
+	history = appendHistory(history, commands, metav1.Now(), resourcesv1alpha1.MaxActionHistory+1)
+	g.Expect(history).To(HaveLen(resourcesv1alpha1.MaxActionHistory))
+	g.Expect(history[0].Generation).To(Equal(int64(3)))
+	last := history[len(history)-1]
+	g.Expect(last.Action).To(Equal("DeleteSecretContentCommand"))
+	g.Expect(last.Generation).To(Equal(int64(resourcesv1alpha1.MaxActionHistory + 1)))
+	g.Expect(history[len(history)-2].Summary).To(Equal("delete client client-id in profile 'default'"))
+}
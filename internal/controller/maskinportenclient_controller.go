@@ -4,7 +4,8 @@ import (
 	"context"
 	"fmt"
 	"math/rand/v2"
-	"reflect"
+	"slices"
+	"strings"
 	"time"
 
 	"go.opentelemetry.io/otel/attribute"
@@ -13,6 +14,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -20,7 +22,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	resourcesv1alpha1 "github.com/altinn/altinn-k8s-operator/api/v1alpha1"
-	"github.com/altinn/altinn-k8s-operator/internal/assert"
+	"github.com/altinn/altinn-k8s-operator/internal/config"
 	"github.com/altinn/altinn-k8s-operator/internal/crypto"
 	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
 	rt "github.com/altinn/altinn-k8s-operator/internal/runtime"
@@ -32,26 +34,36 @@ const FinalizerName = "client.altinn.operator/finalizer"
 // MaskinportenClientReconciler reconciles a MaskinportenClient object
 type MaskinportenClientReconciler struct {
 	client.Client
-	Scheme  *runtime.Scheme
-	runtime rt.Runtime
-	random  *rand.Rand
+	Scheme   *runtime.Scheme
+	runtime  rt.Runtime
+	recorder record.EventRecorder
+	random   *rand.Rand
+	metrics  *reconcilerMetrics
 }
 
+// NewMaskinportenClientReconciler creates the reconciler, events are not recorded if `recorder` is nil
 func NewMaskinportenClientReconciler(
 	rt rt.Runtime,
 	client client.Client,
 	scheme *runtime.Scheme,
+	recorder record.EventRecorder,
 	random *rand.Rand,
-) *MaskinportenClientReconciler {
+) (*MaskinportenClientReconciler, error) {
 	if random == nil {
 		random = rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
 	}
-	return &MaskinportenClientReconciler{
-		Client:  client,
-		Scheme:  scheme,
-		runtime: rt,
-		random:  random,
+	metrics, err := newReconcilerMetrics(rt)
+	if err != nil {
+		return nil, err
 	}
+	return &MaskinportenClientReconciler{
+		Client:   client,
+		Scheme:   scheme,
+		runtime:  rt,
+		recorder: recorder,
+		random:   random,
+		metrics:  metrics,
+	}, nil
 }
 
 // +kubebuilder:rbac:groups=resources.altinn.studio,resources=maskinportenclients,verbs=get;list;watch;create;update;patch;delete
@@ -71,6 +83,10 @@ func (r *MaskinportenClientReconciler) Reconcile(ctx context.Context, kreq ctrl.
 	)
 	defer span.End()
 
+	start := r.runtime.GetClock().Now()
+	outcome := outcomeError
+	defer func() { r.metrics.recordReconcile(ctx, start, outcome) }()
+
 	log := log.FromContext(ctx)
 
 	log.Info("Reconciling MaskinportenClient")
@@ -101,6 +117,8 @@ func (r *MaskinportenClientReconciler) Reconcile(ctx context.Context, kreq ctrl.
 		} else {
 			log.Info("Reconciling MaskinportenClient skipped, was deleted (so we have removed finalizer)..")
 			// TODO: we end up here with NotFound after having cleaned up and removed finalizer.. why?
+			outcome = outcomeSuccess
+			r.metrics.forgetClient(kreq.NamespacedName)
 		}
 		return ctrl.Result{}, notFoundIgnored
 	}
@@ -111,26 +129,84 @@ func (r *MaskinportenClientReconciler) Reconcile(ctx context.Context, kreq ctrl.
 		attribute.Int64("generation", instance.GetGeneration()),
 	)
 
-	currentState, err := r.fetchCurrentState(ctx, req)
+	var currentState *maskinporten.ClientState
+	var executedCommands maskinporten.CommandList
+	defer func() { r.metrics.observeClient(instance, currentState, executedCommands) }()
+
+	currentState, err = r.fetchCurrentState(ctx, req)
 	if err != nil {
 		r.updateStatusWithError(ctx, err, "fetchCurrentState failed", instance, nil)
 		return ctrl.Result{}, err
 	}
 
-	executedCommands, err := r.reconcile(ctx, currentState)
+	dryRun := r.runtime.GetConfig().Controller.DryRun || instance.Annotations[resourcesv1alpha1.DryRunAnnotation] == "true"
+	planOnly := instance.Spec.Suspend || dryRun
+	commands, err := r.plan(ctx, currentState, planOnly)
+	if err != nil {
+		r.updateStatusWithError(ctx, err, "plan failed", instance, nil)
+		return ctrl.Result{}, err
+	}
+
+	if planOnly {
+		state := StateDryRun
+		reason := fmt.Sprintf("Dry run with %d planned actions", len(commands))
+		if instance.Spec.Suspend {
+			state = StateSuspended
+			reason = fmt.Sprintf("Reconciliation suspended with %d planned actions", len(commands))
+		}
+		plan := commands.Summaries()
+		log.Info("Planned actions not executed", "state", state, "plannedActions", plan)
+		if len(plan) > 0 && r.recorder != nil {
+			r.recorder.Event(instance, corev1.EventTypeNormal, EventReasonPlanned, strings.Join(plan, "; "))
+		}
+
+		// The finalizer is still removed, so that deleted resources aren't stuck in terminating.
+		// The planned cleanup in the API and secret is skipped like any other action
+		var finalizerReq *maskinportenClientRequest
+		if req.Kind == RequestDeleteKind {
+			finalizerReq = req
+		}
+		err = r.updateStatus(ctx, finalizerReq, instance, state, reason, nil, plan)
+		if err != nil {
+			span.SetStatus(codes.Error, "updateStatus failed")
+			span.RecordError(err)
+			return ctrl.Result{}, err
+		}
+		span.SetStatus(codes.Ok, "planned actions not executed")
+		outcome = outcomePlanned
+		if req.Kind == RequestDeleteKind {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{RequeueAfter: r.getRequeueAfter()}, nil
+	}
+
+	executedCommands, err = r.reconcile(ctx, currentState, commands)
 	if err != nil {
 		r.updateStatusWithError(ctx, err, "reconcile failed", instance, executedCommands)
 		return ctrl.Result{}, err
 	}
 
-	if len(executedCommands) == 0 {
+	// Deletion always updates the resource, since the finalizer is removed even if the
+	// deletion policy leaves nothing to clean up. Resuming from suspension or dry-run also updates the status
+	statusOutdated := req.Kind == RequestDeleteKind || len(instance.Status.PlannedActions) > 0 ||
+		instance.Status.State == StateSuspended || instance.Status.State == StateDryRun
+	if len(executedCommands) == 0 && !statusOutdated {
 		log.Info("No actions taken")
 		span.SetStatus(codes.Ok, "reconciled successfully")
-		return ctrl.Result{}, nil
+		outcome = outcomeSuccess
+		// Requeue so that JWKS are rotated, periodic resyncs are filtered out by the event filter
+		return ctrl.Result{RequeueAfter: r.getRequeueAfter()}, nil
 	}
 
 	reason := fmt.Sprintf("Reconciled %d resources", len(executedCommands))
-	err = r.updateStatus(ctx, req, instance, "reconciled", reason, executedCommands)
+	if req.Kind == RequestDeleteKind {
+		deletionPolicy := instance.Spec.DeletionPolicy
+		if deletionPolicy == "" {
+			deletionPolicy = resourcesv1alpha1.DeletionPolicyDelete
+		}
+		reason = fmt.Sprintf("Cleaned up %d resources with deletion policy %s", len(executedCommands), deletionPolicy)
+	}
+	err = r.updateStatus(ctx, req, instance, StateReconciled, reason, executedCommands, nil)
 	if err != nil {
 		span.SetStatus(codes.Error, "updateStatus failed")
 		span.RecordError(err)
@@ -141,6 +217,7 @@ func (r *MaskinportenClientReconciler) Reconcile(ctx context.Context, kreq ctrl.
 	log.Info("Reconciled MaskinportenClient")
 
 	span.SetStatus(codes.Ok, "reconciled successfully")
+	outcome = outcomeSuccess
 	return ctrl.Result{RequeueAfter: r.getRequeueAfter()}, nil
 }
 
@@ -161,6 +238,7 @@ func (r *MaskinportenClientReconciler) updateStatus(
 	state string,
 	reason string,
 	commands maskinporten.CommandList,
+	plannedActions []string,
 ) error {
 	ctx, span := r.runtime.Tracer().Start(ctx, "Reconcile.updateStatus")
 	defer span.End()
@@ -168,7 +246,7 @@ func (r *MaskinportenClientReconciler) updateStatus(
 	log := log.FromContext(ctx)
 
 	instance.Status.State = state
-	timestamp := metav1.Now()
+	timestamp := metav1.NewTime(r.runtime.GetClock().Now())
 	instance.Status.LastSynced = &timestamp
 	instance.Status.Reason = reason
 	if commands != nil {
@@ -176,27 +254,12 @@ func (r *MaskinportenClientReconciler) updateStatus(
 	} else {
 		instance.Status.LastActions = nil
 	}
+	instance.Status.PlannedActions = plannedActions
+	instance.Status.History = appendHistory(instance.Status.History, commands, timestamp, instance.GetGeneration())
 	instance.Status.ObservedGeneration = instance.GetGeneration()
 
 	for _, cmd := range commands {
-		// log.Info("Executed command", "command", cmd.String())
-		switch data := cmd.Data.(type) {
-		case *maskinporten.CreateClientInApiCommand:
-			instance.Status.ClientId = data.Api.ClientId
-		case *maskinporten.UpdateClientInApiCommand:
-			instance.Status.ClientId = data.Api.ClientId
-		case *maskinporten.DeleteClientInApiCommand:
-			instance.Status.ClientId = ""
-		case *maskinporten.UpdateSecretContentCommand:
-			instance.Status.Authority = data.SecretContent.Authority
-			instance.Status.KeyIds = make([]string, len(data.SecretContent.Jwks.Keys))
-			for i, key := range data.SecretContent.Jwks.Keys {
-				instance.Status.KeyIds[i] = key.KeyID()
-			}
-		case *maskinporten.DeleteSecretContentCommand:
-			instance.Status.Authority = ""
-			instance.Status.KeyIds = nil
-		}
+		cmd.ApplyStatus(&instance.Status)
 	}
 
 	updatedFinalizers := false
@@ -224,6 +287,26 @@ func (r *MaskinportenClientReconciler) updateStatus(
 	return err
 }
 
+// appendHistory records the executed commands, keeping at most `MaxActionHistory` of the most recent actions
+func appendHistory(
+	history []resourcesv1alpha1.ActionRecord,
+	commands maskinporten.CommandList,
+	timestamp metav1.Time,
+	generation int64,
+) []resourcesv1alpha1.ActionRecord {
+	for _, description := range commands.Describe() {
+		history = append(history, resourcesv1alpha1.ActionRecord{
+			ActionDescription: description,
+			Timestamp:         timestamp,
+			Generation:        generation,
+		})
+	}
+	if excess := len(history) - resourcesv1alpha1.MaxActionHistory; excess > 0 {
+		history = slices.Clone(history[excess:])
+	}
+	return history
+}
+
 func (r *MaskinportenClientReconciler) updateStatusWithError(
 	ctx context.Context,
 	origError error,
@@ -238,7 +321,7 @@ func (r *MaskinportenClientReconciler) updateStatusWithError(
 	origSpan.SetStatus(codes.Error, msg)
 	origSpan.RecordError(origError)
 
-	_ = r.updateStatus(ctx, nil, instance, "error", msg, commands)
+	_ = r.updateStatus(ctx, nil, instance, StateError, msg, commands, nil)
 }
 
 func (r *MaskinportenClientReconciler) loadInstance(
@@ -258,7 +341,7 @@ func (r *MaskinportenClientReconciler) loadInstance(
 	if instance.ObjectMeta.DeletionTimestamp.IsZero() {
 		if !controllerutil.ContainsFinalizer(instance, FinalizerName) {
 			req.Kind = RequestCreateKind
-			if err := r.updateStatus(ctx, req, instance, "recorded", "", nil); err != nil {
+			if err := r.updateStatus(ctx, req, instance, StateRecorded, "", nil, nil); err != nil {
 				return err
 			}
 		} else {
@@ -278,8 +361,6 @@ func (r *MaskinportenClientReconciler) fetchCurrentState(
 	ctx, span := r.runtime.Tracer().Start(ctx, "Reconcile.fetchCurrentState")
 	defer span.End()
 
-	apiClient := r.runtime.GetMaskinportenApiClient()
-
 	var secrets corev1.SecretList
 	err := r.List(ctx, &secrets, client.InNamespace(req.Namespace), client.MatchingLabels{"app": req.AppLabel})
 	if err != nil {
@@ -300,6 +381,7 @@ func (r *MaskinportenClientReconciler) fetchCurrentState(
 	var client *maskinporten.ClientResponse
 	var jwks *crypto.Jwks
 	var secretStateContent *maskinporten.SecretStateContent
+	adopting := false
 
 	if secret != nil {
 		secretStateContent, err = maskinporten.DeserializeSecretStateContent(secret)
@@ -308,6 +390,24 @@ func (r *MaskinportenClientReconciler) fetchCurrentState(
 		}
 	}
 
+	if secretStateContent != nil {
+		err = secretStateContent.LoadKeys(ctx, r.runtime.GetKeyStore())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The client is looked up in the profile it was registered in, which differs
+	// from the desired profile while the client is moving to another profile
+	apiProfile := req.Instance.Spec.Profile
+	if secretStateContent != nil && secretStateContent.ClientId != "" {
+		apiProfile = secretStateContent.Profile
+	}
+	apiClient, err := r.runtime.GetMaskinportenApiClientFor(apiProfile)
+	if err != nil {
+		return nil, err
+	}
+
 	if secretStateContent != nil {
 		if secretStateContent.ClientId != "" {
 			client, jwks, err = apiClient.GetClient(ctx, secretStateContent.ClientId)
@@ -335,96 +435,106 @@ func (r *MaskinportenClientReconciler) fetchCurrentState(
 				break
 			}
 		}
+
+		// Clients registered outside the operator can be taken over, see `AdoptClientIdAnnotation`
+		adoptClientId := req.Instance.Annotations[resourcesv1alpha1.AdoptClientIdAnnotation]
+		if client == nil && adoptClientId != "" {
+			client, err = apiClient.GetUnmanagedClient(ctx, adoptClientId)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get client to adopt: %w", err)
+			}
+			adopting = true
+		}
 	}
 
-	clientState, err := maskinporten.NewClientState(req.Instance, client, jwks, secret, secretStateContent)
+	clientState, err := maskinporten.NewClientState(req.Instance, client, jwks, apiProfile, secret, secretStateContent)
 	if err != nil {
 		return nil, err
 	}
+	clientState.Adopting = adopting
+
+	// While moving between profiles, an earlier attempt may have created the client
+	// in the desired profile without getting to update the secret
+	desiredProfile := config.NormalizeMaskinportenProfile(req.Instance.Spec.Profile)
+	if client != nil && config.NormalizeMaskinportenProfile(apiProfile) != desiredProfile {
+		clientState.MovingTo, err = r.findClientInProfile(ctx, req, desiredProfile)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return clientState, nil
 }
 
-func (r *MaskinportenClientReconciler) reconcile(
+// findClientInProfile returns the client named for the app in the API of the profile, or nil if there is none
+func (r *MaskinportenClientReconciler) findClientInProfile(
+	ctx context.Context,
+	req *maskinportenClientRequest,
+	profile string,
+) (*maskinporten.ApiState, error) {
+	apiClient, err := r.runtime.GetMaskinportenApiClientFor(profile)
+	if err != nil {
+		return nil, err
+	}
+	allClients, err := apiClient.GetAllClients(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clientName := maskinporten.GetClientName(r.runtime.GetOperatorContext(), req.AppId)
+	for _, c := range allClients {
+		if c.ClientName != nil && *c.ClientName == clientName {
+			return maskinporten.NewApiState(profile, &c, nil), nil
+		}
+	}
+	return nil, nil
+}
+
+// plan computes the commands that take the current state to the desired state, without side effects
+// plan computes the commands for the current state. When `planOnly` is set the commands won't be executed,
+// so new keys are placeholders rather than freshly generated
+func (r *MaskinportenClientReconciler) plan(
 	ctx context.Context,
 	currentState *maskinporten.ClientState,
+	planOnly bool,
 ) (maskinporten.CommandList, error) {
-	ctx, span := r.runtime.Tracer().Start(ctx, "Reconcile.reconcile")
+	_, span := r.runtime.Tracer().Start(ctx, "Reconcile.plan")
 	defer span.End()
 
 	context := r.runtime.GetOperatorContext()
 	config := r.runtime.GetConfig()
 	crypto := r.runtime.GetCrypto()
-	clock := r.runtime.GetClock()
-	commands, err := currentState.Reconcile(context, config, crypto, clock)
-	if err != nil {
-		return nil, err
+	if planOnly {
+		crypto = crypto.WithPlaceholderKeys()
 	}
+	clock := r.runtime.GetClock()
+	return currentState.Reconcile(context, config, crypto, clock)
+}
 
-	executedCommands := make(maskinporten.CommandList, 0, len(commands))
-
-	apiClient := r.runtime.GetMaskinportenApiClient()
-
-	for i := 0; i < len(commands); i++ {
-		cmd := &commands[i]
-
-		switch data := cmd.Data.(type) {
-		case *maskinporten.CreateClientInApiCommand:
-			resp, err := apiClient.CreateClient(ctx, data.Api.Req, data.Api.Jwks)
-			if err != nil {
-				return executedCommands, err
-			}
-			err = cmd.Callback(&maskinporten.CreateClientInApiCommandResponse{Resp: resp})
-			if err != nil {
-				return executedCommands, err
-			}
-		case *maskinporten.UpdateClientInApiCommand:
-			if data.Api.Req != nil {
-				updateReq := maskinporten.ConvertAddRequestToUpdateRequest(data.Api.Req)
-				_, err := apiClient.UpdateClient(ctx, data.Api.ClientId, updateReq)
-				if err != nil {
-					return executedCommands, err
-				}
-			}
-			if data.Api.Jwks != nil {
-				// TODO: verify assumed behavior of JWKS endpoints
-				err := apiClient.CreateClientJwks(ctx, data.Api.ClientId, data.Api.Jwks)
-				if err != nil {
-					return executedCommands, err
-				}
-			}
-		case *maskinporten.UpdateSecretContentCommand:
-			assert.AssertWith(
-				data.SecretContent.ClientId != "",
-				"UpdateSecretContentCommand should always have client ID",
-			)
-			updatedSecret := currentState.Secret.Manifest.DeepCopy()
-			err := data.SecretContent.SerializeTo(updatedSecret)
-			if err != nil {
-				return executedCommands, err
-			}
-
-			if err := r.Update(ctx, updatedSecret); err != nil {
-				return executedCommands, err
-			}
-		case *maskinporten.DeleteClientInApiCommand:
-			err := apiClient.DeleteClient(ctx, data.ClientId)
-			if err != nil {
-				return executedCommands, err
-			}
-		case *maskinporten.DeleteSecretContentCommand:
-			updatedSecret := currentState.Secret.Manifest.DeepCopy()
-			maskinporten.DeleteSecretStateContent(updatedSecret)
+func (r *MaskinportenClientReconciler) reconcile(
+	ctx context.Context,
+	currentState *maskinporten.ClientState,
+	commands maskinporten.CommandList,
+) (maskinporten.CommandList, error) {
+	ctx, span := r.runtime.Tracer().Start(ctx, "Reconcile.reconcile")
+	defer span.End()
 
-			// TODO: ownerreference?
-			if err := r.Update(ctx, updatedSecret); err != nil {
-				return executedCommands, err
-			}
-		default:
-			assert.AssertWith(false, "unhandled command: %s", reflect.TypeOf(cmd.Data).Name())
+	executor := maskinporten.NewClientExecutor(
+		r.runtime,
+		r.Client,
+		r.runtime.GetKeyStore(),
+		r.runtime.GetOperatorContext(),
+		currentState,
+	)
+	executedCommands := make(maskinporten.CommandList, 0, len(commands))
+	clock := r.runtime.GetClock()
+	for _, cmd := range commands {
+		start := clock.Now()
+		err := cmd.Execute(ctx, executor)
+		r.metrics.recordCommand(ctx, cmd, start, err)
+		if err != nil {
+			return executedCommands, err
 		}
-
-		executedCommands = append(executedCommands, *cmd)
+		executedCommands = append(executedCommands, cmd)
 	}
 
 	return executedCommands, nil
@@ -434,7 +544,8 @@ func (r *MaskinportenClientReconciler) reconcile(
 func (r *MaskinportenClientReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&resourcesv1alpha1.MaskinportenClient{}).
-		// Only reconcile on generation change (which does not change when status or metadata change)
-		WithEventFilter(predicate.GenerationChangedPredicate{}).
+		// Only reconcile on generation change (which does not change when status or metadata change),
+		// or annotation change so that e.g. dry-run can be toggled per resource
+		WithEventFilter(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{})).
 		Complete(r)
 }
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"fmt"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -14,9 +15,35 @@ import (
 
 	resourcesv1alpha1 "github.com/altinn/altinn-k8s-operator/api/v1alpha1"
 	"github.com/altinn/altinn-k8s-operator/internal"
+	"github.com/altinn/altinn-k8s-operator/internal/fakes"
 	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
+	rt "github.com/altinn/altinn-k8s-operator/internal/runtime"
 )
 
+// fakeApiRuntime serves the in-memory Maskinporten API client for every profile,
+// so that tests don't depend on the fake HTTP APIs and can inject failures
+type fakeApiRuntime struct {
+	rt.Runtime
+	apiClient *fakes.ApiClient
+}
+
+func newFakeApiRuntime() *fakeApiRuntime {
+	runtime, err := internal.NewRuntime(context.Background(), "", "", nil)
+	Expect(err).NotTo(HaveOccurred())
+	return &fakeApiRuntime{
+		Runtime:   runtime,
+		apiClient: fakes.NewApiClient(fakes.NewDb(runtime.GetClock()), runtime.GetOperatorContext()),
+	}
+}
+
+func (r *fakeApiRuntime) GetMaskinportenApiClient() maskinporten.ApiClient {
+	return r.apiClient
+}
+
+func (r *fakeApiRuntime) GetMaskinportenApiClientFor(profile string) (maskinporten.ApiClient, error) {
+	return r.apiClient, nil
+}
+
 var _ = Describe("MaskinportenClient Controller", func() {
 	Context("When reconciling a resource", func() {
 		const resourceName = "local-testapp"
@@ -90,14 +117,15 @@ var _ = Describe("MaskinportenClient Controller", func() {
 		})
 		It("should successfully reconcile the resource", func() {
 			By("Reconciling the created resource")
-			rt, err := internal.NewRuntime(context.Background(), "")
-			Expect(err).NotTo(HaveOccurred())
-			controllerReconciler := NewMaskinportenClientReconciler(
-				rt,
+			runtime := newFakeApiRuntime()
+			controllerReconciler, err := NewMaskinportenClientReconciler(
+				runtime,
 				k8sClient,
 				k8sClient.Scheme(),
 				nil,
+				nil,
 			)
+			Expect(err).NotTo(HaveOccurred())
 
 			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
 				NamespacedName: typeNamespacedName,
@@ -110,7 +138,12 @@ var _ = Describe("MaskinportenClient Controller", func() {
 			Expect(err).NotTo(HaveOccurred())
 			Expect(resource.Status.State).To(Equal("reconciled"))
 			Expect(resource.Status.ObservedGeneration).To(Equal(int64(1)))
-			Expect(resource.Status.Authority).To(Equal(rt.GetConfig().MaskinportenApi.AuthorityUrl))
+			Expect(resource.Status.Authority).To(Equal(runtime.GetConfig().MaskinportenApi.AuthorityUrl))
+			Expect(resource.Status.History).To(HaveLen(2))
+			Expect(resource.Status.History[0].Action).To(Equal("CreateClientInApiCommand"))
+			Expect(resource.Status.History[0].ScopesAdded).To(Equal(resource.Spec.Scopes))
+			Expect(resource.Status.History[1].Action).To(Equal("UpdateSecretContentCommand"))
+			Expect(resource.Status.History[1].Generation).To(Equal(int64(1)))
 
 			secret := &corev1.Secret{}
 			err = k8sClient.Get(ctx, typeNamespacedSecretName, secret)
@@ -120,13 +153,131 @@ var _ = Describe("MaskinportenClient Controller", func() {
 			secretState, err := maskinporten.DeserializeSecretStateContent(secret)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(secretState.ClientId).NotTo(BeEmpty())
-			Expect(secretState.Authority).To(Equal(rt.GetConfig().MaskinportenApi.AuthorityUrl))
+			Expect(secretState.Authority).To(Equal(runtime.GetConfig().MaskinportenApi.AuthorityUrl))
 			Expect(secretState.Jwk).NotTo(BeNil())
 			Expect(secretState.Jwks).NotTo(BeNil())
 			Expect(secretState.Jwks.Keys).NotTo(BeEmpty())
 			Expect(resource.Status.ClientId).To(Equal(secretState.ClientId))
 			Expect(secretState.Jwk.KeyID()).To(Equal(secretState.Jwks.Keys[0].KeyID()))
 			Expect(secretState.Jwk.KeyID()).To(Equal(resource.Status.KeyIds[0]))
+
+			// The client was registered in the API
+			client, jwks, err := runtime.apiClient.GetClient(ctx, secretState.ClientId)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(client.Scopes).To(Equal(resource.Spec.Scopes))
+			Expect(jwks.Keys[0].KeyID()).To(Equal(secretState.Jwk.KeyID()))
+		})
+		It("should report API failures in the status", func() {
+			By("Reconciling the created resource with a failing API")
+			runtime := newFakeApiRuntime()
+			injected := fmt.Errorf("injected failure")
+			runtime.apiClient.OnCall = func(method string) error {
+				if method == "CreateClient" {
+					return injected
+				}
+				return nil
+			}
+			controllerReconciler, err := NewMaskinportenClientReconciler(
+				runtime,
+				k8sClient,
+				k8sClient.Scheme(),
+				nil,
+				nil,
+			)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).To(MatchError(ContainSubstring("injected failure")))
+
+			resource := &resourcesv1alpha1.MaskinportenClient{}
+			err = k8sClient.Get(ctx, typeNamespacedName, resource)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resource.Status.State).To(Equal("error"))
+			Expect(resource.Status.ClientId).To(BeEmpty())
+			all, err := runtime.apiClient.GetAllClients(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(all).To(BeEmpty())
+		})
+		It("should only report planned actions while suspended", func() {
+			By("Suspending the created resource")
+			resource := &resourcesv1alpha1.MaskinportenClient{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.Suspend = true
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+
+			runtime := newFakeApiRuntime()
+			controllerReconciler, err := NewMaskinportenClientReconciler(
+				runtime,
+				k8sClient,
+				k8sClient.Scheme(),
+				nil,
+				nil,
+			)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.State).To(Equal(StateSuspended))
+			Expect(resource.Status.PlannedActions).To(HaveLen(2))
+			Expect(resource.Status.PlannedActions[0]).To(HavePrefix("create client"))
+			Expect(resource.Status.PlannedActions[1]).To(HavePrefix("update secret"))
+			Expect(resource.Status.ClientId).To(BeEmpty())
+			all, err := runtime.apiClient.GetAllClients(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(all).To(BeEmpty())
+
+			By("Resuming the resource")
+			resource.Spec.Suspend = false
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.State).To(Equal(StateReconciled))
+			Expect(resource.Status.PlannedActions).To(BeEmpty())
+			Expect(resource.Status.ClientId).NotTo(BeEmpty())
+		})
+		It("should not execute anything in dry-run", func() {
+			By("Annotating the created resource for dry-run")
+			resource := &resourcesv1alpha1.MaskinportenClient{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Annotations = map[string]string{resourcesv1alpha1.DryRunAnnotation: "true"}
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+
+			runtime := newFakeApiRuntime()
+			controllerReconciler, err := NewMaskinportenClientReconciler(
+				runtime,
+				k8sClient,
+				k8sClient.Scheme(),
+				nil,
+				nil,
+			)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.State).To(Equal(StateDryRun))
+			Expect(resource.Status.PlannedActions).To(HaveLen(2))
+			Expect(resource.Status.PlannedActions[0]).To(ContainSubstring("altinn:resourceregistry/resource.read"))
+			all, err := runtime.apiClient.GetAllClients(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(all).To(BeEmpty())
+
+			secret := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, typeNamespacedSecretName, secret)).To(Succeed())
+			Expect(secret.Data).NotTo(HaveKey(maskinporten.JsonFileName))
 		})
 	})
 })
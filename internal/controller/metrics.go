@@ -0,0 +1,196 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	resourcesv1alpha1 "github.com/altinn/altinn-k8s-operator/api/v1alpha1"
+	"github.com/altinn/altinn-k8s-operator/internal/crypto"
+	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
+	rt "github.com/altinn/altinn-k8s-operator/internal/runtime"
+)
+
+// Outcomes of reconciles and commands, recorded as the `outcome` attribute
+const (
+	outcomeSuccess = "success"
+	outcomeError   = "error"
+	// Suspended or dry-run, commands were planned but not executed
+	outcomePlanned = "planned"
+)
+
+// clientObservation is the state of a MaskinportenClient as of its last reconcile
+type clientObservation struct {
+	state    string
+	clientId string
+	// Expiry of the key apps sign with, zero if the secret has no key
+	keyNotAfter time.Time
+}
+
+// reconcilerMetrics records reconcile and command durations, and reports the last observed
+// state of every MaskinportenClient through observable gauges
+type reconcilerMetrics struct {
+	runtime           rt.Runtime
+	reconcileDuration metric.Float64Histogram
+	commandDuration   metric.Float64Histogram
+	rotations         metric.Int64Counter
+
+	lock    sync.Mutex
+	clients map[types.NamespacedName]clientObservation
+}
+
+func newReconcilerMetrics(runtime rt.Runtime) (*reconcilerMetrics, error) {
+	m := &reconcilerMetrics{
+		runtime: runtime,
+		clients: map[types.NamespacedName]clientObservation{},
+	}
+
+	meter := runtime.Meter()
+	var err error
+	m.reconcileDuration, err = meter.Float64Histogram(
+		"maskinporten.reconcile.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of MaskinportenClient reconciles, by outcome"),
+	)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "couldn't create reconcile duration histogram", 0)
+	}
+	m.commandDuration, err = meter.Float64Histogram(
+		"maskinporten.command.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of executed reconcile commands, by command and outcome"),
+	)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "couldn't create command duration histogram", 0)
+	}
+	m.rotations, err = meter.Int64Counter(
+		"maskinporten.jwks.rotations",
+		metric.WithDescription("Number of client keys rotated"),
+	)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "couldn't create rotation counter", 0)
+	}
+	_, err = meter.Int64ObservableGauge(
+		"maskinporten.clients",
+		metric.WithDescription("Number of MaskinportenClients managed by the operator, by state"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			m.lock.Lock()
+			defer m.lock.Unlock()
+			counts := map[string]int64{}
+			for _, client := range m.clients {
+				counts[client.state]++
+			}
+			for state, count := range counts {
+				o.Observe(count, metric.WithAttributes(attribute.String("state", state)))
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "couldn't create client gauge", 0)
+	}
+	_, err = meter.Float64ObservableGauge(
+		"maskinporten.jwks.expiry",
+		metric.WithUnit("d"),
+		metric.WithDescription("Days until the active key of each MaskinportenClient expires"),
+		metric.WithFloat64Callback(func(ctx context.Context, o metric.Float64Observer) error {
+			now := m.runtime.GetClock().Now()
+			m.lock.Lock()
+			defer m.lock.Unlock()
+			for name, client := range m.clients {
+				if client.keyNotAfter.IsZero() {
+					continue
+				}
+				o.Observe(client.keyNotAfter.Sub(now).Hours()/24, metric.WithAttributes(
+					attribute.String("namespace", name.Namespace),
+					attribute.String("name", name.Name),
+					attribute.String("client_id", client.clientId),
+				))
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "couldn't create key expiry gauge", 0)
+	}
+
+	return m, nil
+}
+
+func (m *reconcilerMetrics) recordReconcile(ctx context.Context, start time.Time, outcome string) {
+	duration := m.runtime.GetClock().Since(start).Seconds()
+	m.reconcileDuration.Record(ctx, duration, metric.WithAttributes(attribute.String("outcome", outcome)))
+}
+
+func (m *reconcilerMetrics) recordCommand(ctx context.Context, cmd maskinporten.Command, start time.Time, err error) {
+	outcome := outcomeSuccess
+	if err != nil {
+		outcome = outcomeError
+	}
+	duration := m.runtime.GetClock().Since(start).Seconds()
+	m.commandDuration.Record(ctx, duration, metric.WithAttributes(
+		attribute.String("command", cmd.Describe().Action),
+		attribute.String("outcome", outcome),
+	))
+
+	if update, ok := cmd.(*maskinporten.UpdateSecretContentCommand); ok && err == nil && update.IsRotation() {
+		m.rotations.Add(ctx, 1)
+	}
+}
+
+// observeClient records the state of `instance` after a reconcile. `currentState` is nil if it couldn't be fetched,
+// in which case the previously observed key is kept. Clients are forgotten once their finalizer is removed.
+func (m *reconcilerMetrics) observeClient(
+	instance *resourcesv1alpha1.MaskinportenClient,
+	currentState *maskinporten.ClientState,
+	executedCommands maskinporten.CommandList,
+) {
+	name := client.ObjectKeyFromObject(instance)
+	if !instance.DeletionTimestamp.IsZero() && !controllerutil.ContainsFinalizer(instance, FinalizerName) {
+		m.forgetClient(name)
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	observation := m.clients[name]
+	observation.state = instance.Status.State
+	observation.clientId = instance.Status.ClientId
+	if currentState != nil {
+		observation.keyNotAfter = time.Time{}
+		if certificates := activeKey(currentState, executedCommands).Certificates(); len(certificates) > 0 {
+			observation.keyNotAfter = certificates[0].NotAfter
+		}
+	}
+	m.clients[name] = observation
+}
+
+func (m *reconcilerMetrics) forgetClient(name types.NamespacedName) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.clients, name)
+}
+
+// activeKey returns the key apps sign with once `executedCommands` have run
+func activeKey(currentState *maskinporten.ClientState, executedCommands maskinporten.CommandList) *crypto.Jwk {
+	var key *crypto.Jwk
+	if currentState.Secret.Content != nil {
+		key = currentState.Secret.Content.Jwk
+	}
+	for _, cmd := range executedCommands {
+		switch cmd := cmd.(type) {
+		case *maskinporten.UpdateSecretContentCommand:
+			key = cmd.SecretContent.Jwk
+		case *maskinporten.DeleteSecretContentCommand:
+			key = nil
+		}
+	}
+	return key
+}
@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	resourcesv1alpha1 "github.com/altinn/altinn-k8s-operator/api/v1alpha1"
+	"github.com/altinn/altinn-k8s-operator/internal"
+	"github.com/altinn/altinn-k8s-operator/internal/config"
+	"github.com/altinn/altinn-k8s-operator/internal/crypto"
+	"github.com/altinn/altinn-k8s-operator/internal/fakes"
+	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
+	rt "github.com/altinn/altinn-k8s-operator/internal/runtime"
+)
+
+var errSecretWriteFailed = errors.New("injected secret write failure")
+
+// profileTestRuntime serves an in-memory Maskinporten API per profile
+type profileTestRuntime struct {
+	rt.Runtime
+	apiClients map[string]*fakes.ApiClient
+	config     *config.Config
+}
+
+func (r *profileTestRuntime) GetMaskinportenApiClientFor(profile string) (maskinporten.ApiClient, error) {
+	return r.apiClients[config.NormalizeMaskinportenProfile(profile)], nil
+}
+
+func (r *profileTestRuntime) GetConfig() *config.Config {
+	return r.config
+}
+
+func TestMovingProfileRetriesWithoutLeakingClients(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	runtime, err := internal.NewRuntime(ctx, "", "", nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	cfg := *runtime.GetConfig()
+	cfg.MaskinportenProfiles = map[string]config.MaskinportenApiConfig{"ver2": cfg.MaskinportenApi}
+	operatorContext := runtime.GetOperatorContext()
+	testRuntime := &profileTestRuntime{
+		Runtime: runtime,
+		apiClients: map[string]*fakes.ApiClient{
+			config.DefaultMaskinportenProfile: fakes.NewApiClient(fakes.NewDb(runtime.GetClock()), operatorContext),
+			"ver2":                            fakes.NewApiClient(fakes.NewDb(runtime.GetClock()), operatorContext),
+		},
+		config: &cfg,
+	}
+
+	scheme := k8sruntime.NewScheme()
+	g.Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	g.Expect(resourcesv1alpha1.AddToScheme(scheme)).To(Succeed())
+	labels := map[string]string{"app": "local-simapp-deployment"}
+	failSecretWrites := false
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithStatusSubresource(&resourcesv1alpha1.MaskinportenClient{}).
+		WithObjects(
+			&resourcesv1alpha1.MaskinportenClient{
+				ObjectMeta: metav1.ObjectMeta{Name: "local-simapp", Namespace: "default", Labels: labels},
+				Spec:       resourcesv1alpha1.MaskinportenClientSpec{Scopes: []string{"altinn:serviceowner"}},
+			},
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "local-simapp-deployment-secrets", Namespace: "default", Labels: labels},
+				Type:       corev1.SecretTypeOpaque,
+			},
+		).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+				if _, ok := obj.(*corev1.Secret); ok && failSecretWrites {
+					return errSecretWriteFailed
+				}
+				return c.Update(ctx, obj, opts...)
+			},
+		}).
+		Build()
+
+	reconciler, err := NewMaskinportenClientReconciler(testRuntime, k8sClient, scheme, nil, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	name := types.NamespacedName{Name: "local-simapp", Namespace: "default"}
+	reconcile := func() error {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: name})
+		return err
+	}
+	clientsIn := func(profile string) []fakes.ClientRecord {
+		return testRuntime.apiClients[profile].Db().Query(func(*fakes.ClientRecord) bool { return true })
+	}
+
+	g.Expect(reconcile()).To(Succeed())
+	g.Expect(clientsIn(config.DefaultMaskinportenProfile)).To(HaveLen(1))
+
+	resource := &resourcesv1alpha1.MaskinportenClient{}
+	g.Expect(k8sClient.Get(ctx, name, resource)).To(Succeed())
+	resource.Spec.Profile = "ver2"
+	g.Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+
+	creates := 0
+	testRuntime.apiClients["ver2"].OnCall = func(method string) error {
+		if method == "CreateClient" {
+			creates++
+		}
+		return nil
+	}
+
+	// Every attempt fails to switch the secret over after the client is created in the new profile
+	failSecretWrites = true
+	for range 3 {
+		g.Expect(reconcile()).To(MatchError(errSecretWriteFailed))
+		g.Expect(clientsIn("ver2")).To(HaveLen(1))
+		g.Expect(clientsIn(config.DefaultMaskinportenProfile)).To(HaveLen(1))
+	}
+
+	failSecretWrites = false
+	g.Expect(reconcile()).To(Succeed())
+	g.Expect(clientsIn(config.DefaultMaskinportenProfile)).To(BeEmpty())
+	moved := clientsIn("ver2")
+	g.Expect(moved).To(HaveLen(1))
+
+	secret := &corev1.Secret{}
+	secretName := types.NamespacedName{Name: "local-simapp-deployment-secrets", Namespace: "default"}
+	g.Expect(k8sClient.Get(ctx, secretName, secret)).To(Succeed())
+	content, err := maskinporten.DeserializeSecretStateContent(secret)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(content.ClientId).To(Equal(moved[0].Client.ClientId))
+	g.Expect(content.Profile).To(Equal("ver2"))
+	// The reused client got the keys of the secret
+	g.Expect(slices.ContainsFunc(moved[0].Jwks.Keys, func(key *crypto.Jwk) bool {
+		return key.KeyID() == content.Jwk.KeyID()
+	})).To(BeTrue())
+	g.Expect(creates).To(Equal(1))
+
+	g.Expect(reconcile()).To(Succeed())
+	g.Expect(clientsIn("ver2")).To(HaveLen(1))
+}
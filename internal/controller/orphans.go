@@ -0,0 +1,299 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	resourcesv1alpha1 "github.com/altinn/altinn-k8s-operator/api/v1alpha1"
+	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
+	"github.com/altinn/altinn-k8s-operator/internal/operatorcontext"
+	rt "github.com/altinn/altinn-k8s-operator/internal/runtime"
+)
+
+// Environment variable with the name of the operator pod, set through the downward API.
+// Orphan events are recorded on the pod, since orphans have no resource of their own.
+const EnvPodName = "POD_NAME"
+
+const (
+	EventReasonOrphanDetected = "OrphanedClientDetected"
+	EventReasonOrphanDeleted  = "OrphanedClientDeleted"
+)
+
+// Orphan is a Maskinporten client owned by this operator without a matching MaskinportenClient resource
+type Orphan struct {
+	Profile    string
+	ClientId   string
+	ClientName string
+	// When the client was first seen as orphaned by this replica
+	OrphanedSince time.Time
+}
+
+type SweepResult struct {
+	Orphans []Orphan
+	Deleted []Orphan
+}
+
+type orphanKey struct {
+	profile  string
+	clientId string
+}
+
+// OrphanCollector periodically lists the clients owned by this operator in every Maskinporten profile,
+// and cross-references them with the MaskinportenClient resources in the cluster.
+// Orphans are reported through metrics and events, and deleted after the grace period if deletion is enabled.
+// It implements `manager.Runnable` and only runs on the leader.
+type OrphanCollector struct {
+	runtime  rt.Runtime
+	reader   client.Reader
+	recorder record.EventRecorder
+	// Object events are recorded on, nil if events are disabled
+	eventTarget *corev1.ObjectReference
+
+	lock          sync.Mutex
+	orphanedSince map[orphanKey]time.Time
+	orphanCounts  map[string]int64
+	deleted       metric.Int64Counter
+}
+
+var _ manager.Runnable = (*OrphanCollector)(nil)
+var _ manager.LeaderElectionRunnable = (*OrphanCollector)(nil)
+
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+func NewOrphanCollector(runtime rt.Runtime, reader client.Reader, recorder record.EventRecorder) (*OrphanCollector, error) {
+	c := &OrphanCollector{
+		runtime:       runtime,
+		reader:        reader,
+		recorder:      recorder,
+		orphanedSince: make(map[orphanKey]time.Time),
+		orphanCounts:  make(map[string]int64),
+	}
+
+	podName := os.Getenv(EnvPodName)
+	podNamespace := os.Getenv(operatorcontext.EnvPodNamespace)
+	if recorder != nil && podName != "" && podNamespace != "" {
+		c.eventTarget = &corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Pod",
+			Name:       podName,
+			Namespace:  podNamespace,
+		}
+	}
+
+	meter := runtime.Meter()
+	var err error
+	c.deleted, err = meter.Int64Counter(
+		"maskinporten.orphaned_clients.deleted",
+		metric.WithDescription("Number of orphaned Maskinporten clients deleted by the orphan collector"),
+	)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "couldn't create orphan counter", 0)
+	}
+	_, err = meter.Int64ObservableGauge(
+		"maskinporten.orphaned_clients",
+		metric.WithDescription("Number of Maskinporten clients without a MaskinportenClient resource, from the last sweep"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			c.lock.Lock()
+			defer c.lock.Unlock()
+			for profile, count := range c.orphanCounts {
+				o.Observe(count, metric.WithAttributes(attribute.String("profile", profile)))
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "couldn't create orphan gauge", 0)
+	}
+
+	return c, nil
+}
+
+// NeedLeaderElection is true so that only one replica deletes clients
+func (c *OrphanCollector) NeedLeaderElection() bool {
+	return true
+}
+
+// Start sweeps on the configured interval until the context is cancelled.
+// The interval is read from the active config before every sweep, so reloads take effect.
+func (c *OrphanCollector) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("orphans")
+	clock := c.runtime.GetClock()
+
+	for {
+		cfg := &c.runtime.GetConfig().OrphanGC
+		if cfg.Enabled {
+			result, err := c.Sweep(ctx)
+			if err != nil {
+				logger.Error(err, "orphan sweep failed")
+			} else {
+				logger.Info("orphan sweep done", "orphans", len(result.Orphans), "deleted", len(result.Deleted))
+			}
+		}
+
+		interval := cfg.Interval
+		if interval <= 0 {
+			interval = time.Hour
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-clock.After(interval):
+		}
+	}
+}
+
+// Sweep finds orphaned clients, and deletes those orphaned for longer than the grace period if deletion is enabled
+// and the operator is not in dry-run.
+// Failing profiles are skipped, and reported in the returned error along with the result for the other profiles.
+func (c *OrphanCollector) Sweep(ctx context.Context) (*SweepResult, error) {
+	ctx, span := c.runtime.Tracer().Start(ctx, "OrphanCollector.Sweep")
+	defer span.End()
+	logger := log.FromContext(ctx).WithName("orphans")
+
+	cfg := c.runtime.GetConfig()
+	gcConfig := cfg.OrphanGC
+	now := c.runtime.GetClock().Now()
+
+	var resources resourcesv1alpha1.MaskinportenClientList
+	if err := c.reader.List(ctx, &resources); err != nil {
+		return nil, errors.WrapPrefix(err, "couldn't list MaskinportenClient resources", 0)
+	}
+	liveClientIds := make(map[string]struct{}, len(resources.Items))
+	liveClientNames := make(map[string]struct{}, len(resources.Items))
+	for _, resource := range resources.Items {
+		if resource.Status.ClientId != "" {
+			liveClientIds[resource.Status.ClientId] = struct{}{}
+		}
+		// The client is created before the status is updated, so also match on name
+		if appId, err := appIdFromName(resource.Name); err == nil {
+			liveClientNames[maskinporten.GetClientName(c.runtime.GetOperatorContext(), appId)] = struct{}{}
+		}
+	}
+
+	result := &SweepResult{}
+	var errs []error
+	seen := make(map[orphanKey]struct{})
+	counts := make(map[string]int64)
+	for _, profile := range cfg.MaskinportenProfileNames() {
+		apiClient, err := c.runtime.GetMaskinportenApiClientFor(profile)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		clients, err := apiClient.GetAllClients(ctx)
+		if err != nil {
+			errs = append(errs, errors.WrapPrefix(err, fmt.Sprintf("couldn't list clients in profile '%s'", profile), 0))
+			continue
+		}
+
+		counts[profile] = 0
+		for _, registered := range clients {
+			if _, ok := liveClientIds[registered.ClientId]; ok {
+				continue
+			}
+			// Deactivated and retained clients are kept on purpose, see `DeletionPolicyDeactivate` and `DeletionPolicyRetain`
+			if registered.Active != nil && !*registered.Active {
+				continue
+			}
+			if maskinporten.IsRetainedClient(registered.Description) {
+				continue
+			}
+			clientName := ""
+			if registered.ClientName != nil {
+				clientName = *registered.ClientName
+			}
+			if _, ok := liveClientNames[clientName]; ok {
+				continue
+			}
+
+			key := orphanKey{profile: profile, clientId: registered.ClientId}
+			seen[key] = struct{}{}
+			counts[profile]++
+			c.lock.Lock()
+			since, ok := c.orphanedSince[key]
+			if !ok {
+				since = now
+				c.orphanedSince[key] = now
+			}
+			c.lock.Unlock()
+
+			orphan := Orphan{Profile: profile, ClientId: registered.ClientId, ClientName: clientName, OrphanedSince: since}
+			result.Orphans = append(result.Orphans, orphan)
+			if !ok {
+				logger.Info("found orphaned client", "profile", profile, "clientId", orphan.ClientId, "clientName", clientName)
+				c.event(corev1.EventTypeWarning, EventReasonOrphanDetected,
+					"Maskinporten client %s (%s) in profile '%s' has no MaskinportenClient resource",
+					orphan.ClientId, clientName, profile)
+			}
+		}
+	}
+
+	// Clients can be deleted or adopted between sweeps, those start a new grace period if orphaned again
+	c.lock.Lock()
+	for key := range c.orphanedSince {
+		if _, ok := seen[key]; !ok {
+			delete(c.orphanedSince, key)
+		}
+	}
+	for profile, count := range counts {
+		c.orphanCounts[profile] = count
+	}
+	c.lock.Unlock()
+
+	if gcConfig.Delete && !cfg.Controller.DryRun {
+		for _, orphan := range result.Orphans {
+			if now.Sub(orphan.OrphanedSince) < gcConfig.GracePeriod {
+				continue
+			}
+			if err := c.deleteOrphan(ctx, orphan); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			logger.Info("deleted orphaned client", "profile", orphan.Profile, "clientId", orphan.ClientId)
+			result.Deleted = append(result.Deleted, orphan)
+		}
+	}
+
+	sort.Slice(result.Orphans, func(i, j int) bool { return result.Orphans[i].ClientId < result.Orphans[j].ClientId })
+	return result, errors.Join(errs...)
+}
+
+func (c *OrphanCollector) deleteOrphan(ctx context.Context, orphan Orphan) error {
+	apiClient, err := c.runtime.GetMaskinportenApiClientFor(orphan.Profile)
+	if err != nil {
+		return err
+	}
+	if err := apiClient.DeleteClient(ctx, orphan.ClientId); err != nil {
+		return errors.WrapPrefix(err, "couldn't delete orphaned client "+orphan.ClientId, 0)
+	}
+
+	c.lock.Lock()
+	delete(c.orphanedSince, orphanKey{profile: orphan.Profile, clientId: orphan.ClientId})
+	c.orphanCounts[orphan.Profile]--
+	c.lock.Unlock()
+
+	c.deleted.Add(ctx, 1, metric.WithAttributes(attribute.String("profile", orphan.Profile)))
+	c.event(corev1.EventTypeNormal, EventReasonOrphanDeleted,
+		"Deleted orphaned Maskinporten client %s (%s) in profile '%s'", orphan.ClientId, orphan.ClientName, orphan.Profile)
+	return nil
+}
+
+func (c *OrphanCollector) event(eventType string, reason string, messageFmt string, args ...any) {
+	if c.eventTarget == nil {
+		return
+	}
+	c.recorder.Eventf(c.eventTarget, eventType, reason, messageFmt, args...)
+}
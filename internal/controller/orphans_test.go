@@ -0,0 +1,249 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	resourcesv1alpha1 "github.com/altinn/altinn-k8s-operator/api/v1alpha1"
+	"github.com/altinn/altinn-k8s-operator/internal"
+	"github.com/altinn/altinn-k8s-operator/internal/config"
+	"github.com/altinn/altinn-k8s-operator/internal/crypto"
+	"github.com/altinn/altinn-k8s-operator/internal/fakes"
+	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
+	rt "github.com/altinn/altinn-k8s-operator/internal/runtime"
+)
+
+type orphanTestRuntime struct {
+	rt.Runtime
+	apiClient *fakes.ApiClient
+	clock     clockwork.FakeClock
+	config    *config.Config
+}
+
+func (r *orphanTestRuntime) GetMaskinportenApiClientFor(profile string) (maskinporten.ApiClient, error) {
+	return r.apiClient, nil
+}
+
+func (r *orphanTestRuntime) GetClock() clockwork.Clock {
+	return r.clock
+}
+
+func (r *orphanTestRuntime) GetConfig() *config.Config {
+	return r.config
+}
+
+func newOrphanTestRuntime(g *WithT) *orphanTestRuntime {
+	runtime, err := internal.NewRuntime(context.Background(), "", "", nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	cfg := *runtime.GetConfig()
+	cfg.OrphanGC = config.OrphanGCConfig{
+		Enabled:     true,
+		Interval:    time.Hour,
+		GracePeriod: 24 * time.Hour,
+	}
+	clock := clockwork.NewFakeClock()
+	return &orphanTestRuntime{
+		Runtime:   runtime,
+		apiClient: fakes.NewApiClient(fakes.NewDb(clock), runtime.GetOperatorContext()),
+		clock:     clock,
+		config:    &cfg,
+	}
+}
+
+func createTestClient(g *WithT, runtime *orphanTestRuntime, appId string) *maskinporten.ClientResponse {
+	return createTestClientWithDescription(g, runtime, appId, "Test client for "+appId)
+}
+
+func createTestClientWithDescription(
+	g *WithT,
+	runtime *orphanTestRuntime,
+	appId string,
+	description string,
+) *maskinporten.ClientResponse {
+	operatorContext := runtime.GetOperatorContext()
+	service := crypto.NewDefaultService(operatorContext, runtime.clock, rand.Reader)
+	jwks, err := service.CreateJwks(appId, runtime.clock.Now().Add(30*24*time.Hour))
+	g.Expect(err).NotTo(HaveOccurred())
+	publicJwks, err := jwks.ToPublic()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	clientName := maskinporten.GetClientName(operatorContext, appId)
+	integrationType := maskinporten.IntegrationTypeMaskinporten
+	appType := maskinporten.ApplicationTypeWeb
+	tokenEndpointMethod := maskinporten.TokenEndpointAuthMethodPrivateKeyJwt
+	client, err := runtime.apiClient.CreateClient(context.Background(), &maskinporten.AddClientRequest{
+		ClientName:              &clientName,
+		Description:             &description,
+		ClientOrgno:             &operatorContext.ServiceOwnerOrgNo,
+		GrantTypes:              []maskinporten.GrantType{maskinporten.GrantTypeJwtBearer},
+		IntegrationType:         &integrationType,
+		ApplicationType:         &appType,
+		TokenEndpointAuthMethod: &tokenEndpointMethod,
+	}, publicJwks)
+	g.Expect(err).NotTo(HaveOccurred())
+	return client
+}
+
+func newTestResource(name string, clientId string) *resourcesv1alpha1.MaskinportenClient {
+	return &resourcesv1alpha1.MaskinportenClient{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Status:     resourcesv1alpha1.MaskinportenClientStatus{ClientId: clientId},
+	}
+}
+
+func newTestOrphanCollector(g *WithT, runtime *orphanTestRuntime, resources ...*resourcesv1alpha1.MaskinportenClient) *OrphanCollector {
+	scheme := k8sruntime.NewScheme()
+	g.Expect(resourcesv1alpha1.AddToScheme(scheme)).To(Succeed())
+	builder := fake.NewClientBuilder().WithScheme(scheme)
+	for _, resource := range resources {
+		builder = builder.WithObjects(resource)
+	}
+	collector, err := NewOrphanCollector(runtime, builder.Build(), record.NewFakeRecorder(10))
+	g.Expect(err).NotTo(HaveOccurred())
+	return collector
+}
+
+func TestOrphanCollectorIgnoresLiveClients(t *testing.T) {
+	g := NewWithT(t)
+	runtime := newOrphanTestRuntime(g)
+	byStatus := createTestClient(g, runtime, "app1")
+	createTestClient(g, runtime, "app2")
+	orphan := createTestClient(g, runtime, "app3")
+
+	// app2 has no client ID in the status yet, so it is matched by name
+	collector := newTestOrphanCollector(g, runtime,
+		newTestResource("local-app1-deployment", byStatus.ClientId),
+		newTestResource("local-app2-deployment", ""),
+	)
+
+	result, err := collector.Sweep(context.Background())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.Orphans).To(HaveLen(1))
+	g.Expect(result.Orphans[0].ClientId).To(Equal(orphan.ClientId))
+	g.Expect(result.Orphans[0].Profile).To(Equal(config.DefaultMaskinportenProfile))
+	g.Expect(result.Deleted).To(BeEmpty())
+}
+
+func TestOrphanCollectorOnlyReportsByDefault(t *testing.T) {
+	g := NewWithT(t)
+	runtime := newOrphanTestRuntime(g)
+	orphan := createTestClient(g, runtime, "app1")
+	collector := newTestOrphanCollector(g, runtime)
+
+	_, err := collector.Sweep(context.Background())
+	g.Expect(err).NotTo(HaveOccurred())
+	runtime.clock.Advance(48 * time.Hour)
+	result, err := collector.Sweep(context.Background())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.Orphans).To(HaveLen(1))
+	g.Expect(result.Deleted).To(BeEmpty())
+
+	_, _, err = runtime.apiClient.GetClient(context.Background(), orphan.ClientId)
+	g.Expect(err).NotTo(HaveOccurred())
+}
+
+func TestOrphanCollectorDeletesAfterGracePeriod(t *testing.T) {
+	g := NewWithT(t)
+	runtime := newOrphanTestRuntime(g)
+	runtime.config.OrphanGC.Delete = true
+	orphan := createTestClient(g, runtime, "app1")
+	collector := newTestOrphanCollector(g, runtime)
+
+	result, err := collector.Sweep(context.Background())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.Orphans).To(HaveLen(1))
+	g.Expect(result.Deleted).To(BeEmpty())
+
+	runtime.clock.Advance(23 * time.Hour)
+	result, err = collector.Sweep(context.Background())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.Deleted).To(BeEmpty())
+
+	runtime.clock.Advance(time.Hour)
+	result, err = collector.Sweep(context.Background())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.Deleted).To(HaveLen(1))
+	g.Expect(result.Deleted[0].ClientId).To(Equal(orphan.ClientId))
+
+	_, _, err = runtime.apiClient.GetClient(context.Background(), orphan.ClientId)
+	g.Expect(err).To(HaveOccurred())
+
+	result, err = collector.Sweep(context.Background())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.Orphans).To(BeEmpty())
+}
+
+func TestOrphanCollectorDoesNotDeleteInOperatorDryRun(t *testing.T) {
+	g := NewWithT(t)
+	runtime := newOrphanTestRuntime(g)
+	runtime.config.OrphanGC.Delete = true
+	runtime.config.Controller.DryRun = true
+	orphan := createTestClient(g, runtime, "app1")
+	collector := newTestOrphanCollector(g, runtime)
+
+	_, err := collector.Sweep(context.Background())
+	g.Expect(err).NotTo(HaveOccurred())
+	runtime.clock.Advance(48 * time.Hour)
+	result, err := collector.Sweep(context.Background())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.Orphans).To(HaveLen(1))
+	g.Expect(result.Deleted).To(BeEmpty())
+
+	_, _, err = runtime.apiClient.GetClient(context.Background(), orphan.ClientId)
+	g.Expect(err).NotTo(HaveOccurred())
+}
+
+func TestOrphanCollectorStartDeletesOrphans(t *testing.T) {
+	g := NewWithT(t)
+	runtime := newOrphanTestRuntime(g)
+	runtime.config.OrphanGC.Delete = true
+	orphan := createTestClient(g, runtime, "app1")
+	collector := newTestOrphanCollector(g, runtime)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- collector.Start(ctx) }()
+
+	// Every sweep ends waiting for the next interval
+	for range 24 {
+		runtime.clock.BlockUntil(1)
+		_, _, err := runtime.apiClient.GetClient(ctx, orphan.ClientId)
+		g.Expect(err).NotTo(HaveOccurred())
+		runtime.clock.Advance(runtime.config.OrphanGC.Interval)
+	}
+	g.Eventually(func() error {
+		_, _, err := runtime.apiClient.GetClient(ctx, orphan.ClientId)
+		return err
+	}).Should(HaveOccurred())
+
+	cancel()
+	g.Eventually(done).Should(Receive(BeNil()))
+}
+
+func TestOrphanCollectorKeepsRetainedClients(t *testing.T) {
+	g := NewWithT(t)
+	runtime := newOrphanTestRuntime(g)
+	runtime.config.OrphanGC.Delete = true
+	retained := createTestClientWithDescription(g, runtime, "app1", maskinporten.RetainedClientMarker+"Test client for app1")
+	collector := newTestOrphanCollector(g, runtime)
+
+	for range 2 {
+		result, err := collector.Sweep(context.Background())
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(result.Orphans).To(BeEmpty())
+		g.Expect(result.Deleted).To(BeEmpty())
+		runtime.clock.Advance(48 * time.Hour)
+	}
+
+	_, _, err := runtime.apiClient.GetClient(context.Background(), retained.ClientId)
+	g.Expect(err).NotTo(HaveOccurred())
+}
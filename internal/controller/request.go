@@ -48,11 +48,10 @@ func (r *MaskinportenClientReconciler) mapRequest(
 	_, span := r.runtime.Tracer().Start(ctx, "Reconcile.mapRequest")
 	defer span.End()
 
-	nameSplit := strings.Split(req.Name, "-")
-	if len(nameSplit) < 2 {
-		return nil, fmt.Errorf("unexpected name format for MaskinportenClient resource: %s", req.Name)
+	appId, err := appIdFromName(req.Name)
+	if err != nil {
+		return nil, err
 	}
-	appId := nameSplit[1]
 
 	operatorContext := r.runtime.GetOperatorContext()
 
@@ -64,3 +63,12 @@ func (r *MaskinportenClientReconciler) mapRequest(
 		AppLabel:       fmt.Sprintf("%s-%s-deployment", operatorContext.ServiceOwnerName, appId),
 	}, nil
 }
+
+// appIdFromName extracts the app ID from MaskinportenClient resource names, formatted as '<service owner>-<app ID>'
+func appIdFromName(name string) (string, error) {
+	nameSplit := strings.Split(name, "-")
+	if len(nameSplit) < 2 {
+		return "", fmt.Errorf("unexpected name format for MaskinportenClient resource: %s", name)
+	}
+	return nameSplit[1], nil
+}
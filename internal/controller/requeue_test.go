@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	resourcesv1alpha1 "github.com/altinn/altinn-k8s-operator/api/v1alpha1"
+	"github.com/altinn/altinn-k8s-operator/internal"
+	"github.com/altinn/altinn-k8s-operator/internal/config"
+	"github.com/altinn/altinn-k8s-operator/internal/fakes"
+	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
+	"github.com/altinn/altinn-k8s-operator/internal/operatorcontext"
+)
+
+// Keys are only rotated when the resource is reconciled, and nothing but the requeue
+// triggers a reconcile once the client is up to date
+func TestQuietReconcileRequeuesForRotation(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	clock := clockwork.NewFakeClockAt(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	runtime, err := internal.NewRuntime(
+		ctx,
+		"",
+		"",
+		nil,
+		internal.WithClock(clock),
+		internal.WithMaskinportenApiClientFactory(func(
+			profile string,
+			cfg *config.MaskinportenApiConfig,
+			operatorContext *operatorcontext.Context,
+			clock clockwork.Clock,
+		) (maskinporten.ApiClient, error) {
+			return fakes.NewApiClient(fakes.NewDb(clock), operatorContext), nil
+		}),
+	)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	scheme := k8sruntime.NewScheme()
+	g.Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	g.Expect(resourcesv1alpha1.AddToScheme(scheme)).To(Succeed())
+	labels := map[string]string{"app": "local-simapp-deployment"}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithStatusSubresource(&resourcesv1alpha1.MaskinportenClient{}).
+		WithObjects(
+			&resourcesv1alpha1.MaskinportenClient{
+				ObjectMeta: metav1.ObjectMeta{Name: "local-simapp", Namespace: "default", Labels: labels},
+				Spec:       resourcesv1alpha1.MaskinportenClientSpec{Scopes: []string{"altinn:serviceowner"}},
+			},
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "local-simapp-deployment-secrets", Namespace: "default", Labels: labels},
+				Type:       corev1.SecretTypeOpaque,
+			},
+		).
+		Build()
+
+	reconciler, err := NewMaskinportenClientReconciler(runtime, k8sClient, scheme, nil, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	name := types.NamespacedName{Name: "local-simapp", Namespace: "default"}
+	secretName := types.NamespacedName{Name: "local-simapp-deployment-secrets", Namespace: "default"}
+	activeKeyId := func() string {
+		secret := &corev1.Secret{}
+		g.Expect(k8sClient.Get(ctx, secretName, secret)).To(Succeed())
+		content, err := maskinporten.DeserializeSecretStateContent(secret)
+		g.Expect(err).NotTo(HaveOccurred())
+		return content.Jwk.KeyID()
+	}
+
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: name})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(BeNumerically(">", 0))
+	initialKeyId := activeKeyId()
+
+	// Nothing to do, but the resource must still come back for rotation
+	result, err = reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: name})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(BeNumerically(">", 0))
+	g.Expect(activeKeyId()).To(Equal(initialKeyId))
+
+	// Following the requeues until the key is close to expiry rotates it
+	for activeKeyId() == initialKeyId && clock.Since(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) < 60*24*time.Hour {
+		clock.Advance(result.RequeueAfter)
+		result, err = reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: name})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(result.RequeueAfter).To(BeNumerically(">", 0))
+	}
+	g.Expect(activeKeyId()).NotTo(Equal(initialKeyId))
+}
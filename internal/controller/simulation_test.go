@@ -0,0 +1,284 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	resourcesv1alpha1 "github.com/altinn/altinn-k8s-operator/api/v1alpha1"
+	"github.com/altinn/altinn-k8s-operator/internal"
+	"github.com/altinn/altinn-k8s-operator/internal/config"
+	"github.com/altinn/altinn-k8s-operator/internal/crypto"
+	"github.com/altinn/altinn-k8s-operator/internal/fakes"
+	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
+	"github.com/altinn/altinn-k8s-operator/internal/operatorcontext"
+)
+
+// Bounds of the per-item exponential backoff of the controller-runtime workqueue, used after errors
+const simulationMinBackoff = 5 * time.Millisecond
+const simulationMaxBackoff = 1000 * time.Second
+
+// simulation runs the reconciler on a virtual clock against in-memory Maskinporten APIs,
+// replaying the requeues the controller asks for, so that months of operation run in seconds.
+// Resources live in the envtest API server, but the controller manager is not started:
+// the reconciler is called directly from a hand-written queue standing in for the controller-runtime
+// workqueue (requeue delays and error backoff only, no rate limiting or deduplication of in-flight requests).
+// Watch events are not simulated either, resources changed by tests must be enqueued.
+type simulation struct {
+	clock      clockwork.FakeClock
+	k8sClient  client.Client
+	reconciler *MaskinportenClientReconciler
+	// Keyed by Maskinporten profile
+	apiClients map[string]*fakes.ApiClient
+
+	// Time of the next reconcile of each resource
+	queue    map[types.NamespacedName]time.Time
+	failures map[types.NamespacedName]int
+
+	Reconciles int
+	Errors     []error
+}
+
+func newSimulation(k8sClient client.Client, overrides config.Overrides) (*simulation, error) {
+	clock := clockwork.NewFakeClockAt(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	apiClients := map[string]*fakes.ApiClient{}
+	runtime, err := internal.NewRuntime(
+		context.Background(),
+		"",
+		"",
+		overrides,
+		internal.WithClock(clock),
+		internal.WithMaskinportenApiClientFactory(func(
+			profile string,
+			cfg *config.MaskinportenApiConfig,
+			operatorContext *operatorcontext.Context,
+			clock clockwork.Clock,
+		) (maskinporten.ApiClient, error) {
+			apiClient := fakes.NewApiClient(fakes.NewDb(clock), operatorContext)
+			apiClients[profile] = apiClient
+			return apiClient, nil
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	random := rand.New(rand.NewPCG(1, 2))
+	reconciler, err := NewMaskinportenClientReconciler(runtime, k8sClient, k8sClient.Scheme(), nil, random)
+	if err != nil {
+		return nil, err
+	}
+	return &simulation{
+		clock:      clock,
+		k8sClient:  k8sClient,
+		reconciler: reconciler,
+		apiClients: apiClients,
+		queue:      map[types.NamespacedName]time.Time{},
+		failures:   map[types.NamespacedName]int{},
+	}, nil
+}
+
+// Enqueue schedules a reconcile of the resource at the current time
+func (s *simulation) Enqueue(name types.NamespacedName) {
+	s.queue[name] = s.clock.Now()
+}
+
+func (s *simulation) next() (types.NamespacedName, time.Time, bool) {
+	var next types.NamespacedName
+	var at time.Time
+	found := false
+	for name, t := range s.queue {
+		if !found || t.Before(at) || (t.Equal(at) && name.String() < next.String()) {
+			next, at, found = name, t, true
+		}
+	}
+	return next, at, found
+}
+
+// Run advances the clock through the scheduled reconciles until `duration` has passed.
+// `check` is called with the current time before and after every reconcile, and at the end.
+func (s *simulation) Run(ctx context.Context, duration time.Duration, check func(now time.Time) error) error {
+	end := s.clock.Now().Add(duration)
+	for {
+		name, at, ok := s.next()
+		if !ok || at.After(end) {
+			break
+		}
+		if at.After(s.clock.Now()) {
+			s.clock.Advance(at.Sub(s.clock.Now()))
+		}
+		if err := check(s.clock.Now()); err != nil {
+			return err
+		}
+
+		delete(s.queue, name)
+		s.Reconciles++
+		result, err := s.reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: name})
+		switch {
+		case err != nil:
+			s.Errors = append(s.Errors, err)
+			backoff := simulationMinBackoff << min(s.failures[name], 32)
+			s.queue[name] = s.clock.Now().Add(min(backoff, simulationMaxBackoff))
+			s.failures[name]++
+		case result.RequeueAfter > 0:
+			s.queue[name] = s.clock.Now().Add(result.RequeueAfter)
+			delete(s.failures, name)
+		case result.Requeue:
+			s.queue[name] = s.clock.Now()
+			delete(s.failures, name)
+		default:
+			delete(s.failures, name)
+		}
+
+		if err := check(s.clock.Now()); err != nil {
+			return err
+		}
+	}
+
+	s.clock.Advance(end.Sub(s.clock.Now()))
+	return check(s.clock.Now())
+}
+
+// activeKey returns the key apps sign with, after checking that it is valid at `now`
+// and registered for the client in Maskinporten. Returns an empty key ID if the secret has no content yet.
+func (s *simulation) activeKey(ctx context.Context, secretName types.NamespacedName, now time.Time) (string, error) {
+	secret := &corev1.Secret{}
+	if err := s.k8sClient.Get(ctx, secretName, secret); err != nil {
+		return "", err
+	}
+	content, err := maskinporten.DeserializeSecretStateContent(secret)
+	if err != nil || content == nil {
+		return "", err
+	}
+
+	keyId := content.Jwk.KeyID()
+	notAfter := content.Jwk.Certificates()[0].NotAfter
+	if !now.Before(notAfter) {
+		return "", fmt.Errorf("key %s of client %s expired at %s, now is %s", keyId, content.ClientId, notAfter, now)
+	}
+
+	apiClient, ok := s.apiClients[config.NormalizeMaskinportenProfile(content.Profile)]
+	if !ok {
+		return "", fmt.Errorf("no API client for profile '%s'", content.Profile)
+	}
+	// Read from the db, so that the check is unaffected by failures injected into the API
+	record := apiClient.Db().Get(content.ClientId)
+	if record == nil {
+		return "", fmt.Errorf("client %s does not exist", content.ClientId)
+	}
+	if !slices.ContainsFunc(record.Jwks.Keys, func(key *crypto.Jwk) bool { return key.KeyID() == keyId }) {
+		return "", fmt.Errorf("key %s is not registered for client %s", keyId, content.ClientId)
+	}
+	return keyId, nil
+}
+
+var errSimulatedOutage = errors.New("simulated Maskinporten outage")
+
+var _ = Describe("MaskinportenClient simulation", func() {
+	const resourceName = "local-simapp"
+	const secretName = "local-simapp-deployment-secrets"
+	const day = 24 * time.Hour
+
+	ctx := context.Background()
+	resourceNamespacedName := types.NamespacedName{Name: resourceName, Namespace: "default"}
+	secretNamespacedName := types.NamespacedName{Name: secretName, Namespace: "default"}
+
+	var sim *simulation
+	// Active keys in the order they were observed
+	var keyIds []string
+
+	checkKeys := func(now time.Time) error {
+		keyId, err := sim.activeKey(ctx, secretNamespacedName, now)
+		if err != nil {
+			return err
+		}
+		if keyId == "" && len(keyIds) > 0 {
+			return fmt.Errorf("secret content was removed")
+		}
+		if keyId != "" && (len(keyIds) == 0 || keyIds[len(keyIds)-1] != keyId) {
+			keyIds = append(keyIds, keyId)
+		}
+		return nil
+	}
+
+	BeforeEach(func() {
+		labels := map[string]string{"app": "local-simapp-deployment"}
+		Expect(k8sClient.Create(ctx, &resourcesv1alpha1.MaskinportenClient{
+			ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default", Labels: labels},
+			Spec: resourcesv1alpha1.MaskinportenClientSpec{
+				Scopes: []string{"altinn:resourceregistry/resource.read"},
+			},
+		})).To(Succeed())
+		Expect(k8sClient.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: "default", Labels: labels},
+			Type:       corev1.SecretTypeOpaque,
+		})).To(Succeed())
+
+		var err error
+		sim, err = newSimulation(k8sClient, nil)
+		Expect(err).NotTo(HaveOccurred())
+		keyIds = nil
+		sim.Enqueue(resourceNamespacedName)
+	})
+
+	AfterEach(func() {
+		resource := &resourcesv1alpha1.MaskinportenClient{}
+		Expect(k8sClient.Get(ctx, resourceNamespacedName, resource)).To(Succeed())
+		Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+		sim.Enqueue(resourceNamespacedName)
+		Expect(sim.Run(ctx, time.Minute, func(time.Time) error { return nil })).To(Succeed())
+		err := k8sClient.Get(ctx, resourceNamespacedName, resource)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+		secret := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, secretNamespacedName, secret)).To(Succeed())
+		Expect(k8sClient.Delete(ctx, secret)).To(Succeed())
+	})
+
+	It("keeps a valid key registered through months of operation", func() {
+		Expect(sim.Run(ctx, 180*day, checkKeys)).To(Succeed())
+		Expect(sim.Errors).To(BeEmpty())
+
+		// Keys are valid for 30 days, and rotated a week before they expire
+		Expect(len(keyIds)).To(BeNumerically(">=", 180/23))
+		// Resources are requeued daily, with jitter
+		Expect(sim.Reconciles).To(BeNumerically("~", 180, 20))
+
+		resource := &resourcesv1alpha1.MaskinportenClient{}
+		Expect(k8sClient.Get(ctx, resourceNamespacedName, resource)).To(Succeed())
+		Expect(resource.Status.State).To(Equal(StateReconciled))
+		Expect(resource.Status.KeyIds).To(HaveLen(2))
+		Expect(resource.Status.KeyIds[0]).To(Equal(keyIds[len(keyIds)-1]))
+		Expect(resource.Status.LastSynced.Time).To(BeTemporally("~", sim.clock.Now(), 2*day))
+	})
+
+	It("rotates keys in time through a Maskinporten outage", func() {
+		Expect(sim.Run(ctx, 20*day, checkKeys)).To(Succeed())
+		Expect(sim.Errors).To(BeEmpty())
+		keysBeforeOutage := len(keyIds)
+
+		// The first key is due for rotation during the outage
+		apiClient := sim.apiClients[config.DefaultMaskinportenProfile]
+		apiClient.OnCall = func(method string) error { return errSimulatedOutage }
+		Expect(sim.Run(ctx, 5*day, checkKeys)).To(Succeed())
+		Expect(sim.Errors).NotTo(BeEmpty())
+		Expect(keyIds).To(HaveLen(keysBeforeOutage))
+
+		apiClient.OnCall = nil
+		Expect(sim.Run(ctx, 60*day, checkKeys)).To(Succeed())
+		Expect(len(keyIds)).To(BeNumerically(">", keysBeforeOutage))
+	})
+})
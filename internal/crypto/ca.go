@@ -0,0 +1,211 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+// OID for the organizationIdentifier attribute (ETSI EN 319 412-1),
+// used in Norwegian enterprise certificates to carry the org number as 'NTRNO-<orgno>'
+var oidOrganizationIdentifier = asn1.ObjectIdentifier{2, 5, 4, 97}
+
+// CertificateAuthority is the operator CA used to sign per-app certificates.
+// The chain contains the CA certificate itself, followed by any intermediates up to the root
+type CertificateAuthority struct {
+	cert  *x509.Certificate
+	chain []*x509.Certificate
+	key   crypto.Signer
+}
+
+// LoadCertificateAuthority parses a PEM encoded certificate chain (CA certificate first)
+// and the PEM encoded private key of the CA certificate (PKCS#8, PKCS#1 or SEC 1), RSA and ECDSA keys are supported.
+// The CA must be valid for longer than `RotationThreshold` from `now`, since app keys are rotated within that window.
+func LoadCertificateAuthority(certPem []byte, keyPem []byte, now time.Time) (*CertificateAuthority, error) {
+	var chain []*x509.Certificate
+	rest := certPem
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "error parsing CA certificate", 0)
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no certificates found in CA certificate PEM")
+	}
+
+	cert := chain[0]
+	if !cert.IsCA || cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, errors.Errorf("certificate '%s' is not a CA certificate", cert.Subject)
+	}
+	if cert.NotAfter.Sub(now) < RotationThreshold {
+		return nil, errors.Errorf(
+			"CA certificate '%s' expires at %s, it must be valid for at least %s to rotate app keys",
+			cert.Subject, cert.NotAfter, RotationThreshold,
+		)
+	}
+
+	block, _ := pem.Decode(keyPem)
+	if block == nil {
+		return nil, errors.New("no private key found in CA key PEM")
+	}
+	var key crypto.Signer
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "error parsing CA private key", 0)
+		}
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, errors.Errorf("unsupported CA private key type: %T", parsed)
+		}
+		key = signer
+	case "RSA PRIVATE KEY":
+		parsed, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "error parsing CA private key", 0)
+		}
+		key = parsed
+	case "EC PRIVATE KEY":
+		parsed, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "error parsing CA private key", 0)
+		}
+		key = parsed
+	default:
+		return nil, errors.Errorf("unsupported CA private key PEM block: %s", block.Type)
+	}
+
+	switch publicKey := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if !publicKey.Equal(key.Public()) {
+			return nil, errors.New("CA private key does not match CA certificate")
+		}
+	case *ecdsa.PublicKey:
+		if !publicKey.Equal(key.Public()) {
+			return nil, errors.New("CA private key does not match CA certificate")
+		}
+	default:
+		return nil, errors.Errorf("unsupported CA public key type: %T", cert.PublicKey)
+	}
+
+	return &CertificateAuthority{
+		cert:  cert,
+		chain: chain,
+		key:   key,
+	}, nil
+}
+
+// signatureAlgorithm returns the algorithm app certificates are signed with, `rsaAlgo` for RSA keys
+func (ca *CertificateAuthority) signatureAlgorithm(rsaAlgo x509.SignatureAlgorithm) x509.SignatureAlgorithm {
+	if _, ok := ca.key.(*ecdsa.PrivateKey); ok {
+		return x509.ECDSAWithSHA512
+	}
+	return rsaAlgo
+}
+
+func (ca *CertificateAuthority) Certificate() *x509.Certificate {
+	return ca.cert
+}
+
+// Chain returns the CA certificate followed by its issuers, as included in 'x5c' of app JWKs
+func (ca *CertificateAuthority) Chain() []*x509.Certificate {
+	return ca.chain
+}
+
+// EncodePem encodes the certificate chain and the private key (PKCS#8) as PEM
+func (ca *CertificateAuthority) EncodePem() ([]byte, []byte, error) {
+	var certPem []byte
+	for _, cert := range ca.chain {
+		certPem = append(certPem, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(ca.key)
+	if err != nil {
+		return nil, nil, errors.WrapPrefix(err, "error encoding CA private key", 0)
+	}
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})
+
+	return certPem, keyPem, nil
+}
+
+// CreateCertificateAuthority creates a self-signed root CA for the service owner.
+// Used to bootstrap the operator CA, see `cmd/utils`
+func (s *CryptoService) CreateCertificateAuthority(
+	commonName string,
+	notAfter time.Time,
+) (*CertificateAuthority, error) {
+	rsaKey, err := rsa.GenerateKey(s.random, s.keySizeBits)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "error generating RSA key for CA", 0)
+	}
+
+	serial, err := s.generateCertSerialNumber()
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "error generating serial number for CA", 0)
+	}
+
+	now := s.clock.Now().UTC()
+	if now.Equal(notAfter) || now.After(notAfter) {
+		return nil, errors.Errorf("notAfter (%s) must be after current time (%s)", notAfter, now)
+	}
+
+	certTemplate := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               s.getSubject(commonName),
+		NotBefore:             now,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+		SignatureAlgorithm:    s.x509SignatureAlgo,
+	}
+
+	derBytes, err := x509.CreateCertificate(s.random, &certTemplate, &certTemplate, &rsaKey.PublicKey, rsaKey)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "error generating CA cert", 0)
+	}
+	cert, err := x509.ParseCertificate(derBytes)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "error parsing generated CA cert", 0)
+	}
+
+	return &CertificateAuthority{
+		cert:  cert,
+		chain: []*x509.Certificate{cert},
+		key:   rsaKey,
+	}, nil
+}
+
+func (s *CryptoService) getSubject(commonName string) pkix.Name {
+	name := pkix.Name{
+		Country:      []string{"NO"},
+		Organization: []string{s.ctx.ServiceOwnerName},
+		SerialNumber: s.ctx.ServiceOwnerOrgNo,
+		CommonName:   commonName,
+	}
+	if s.ctx.ServiceOwnerOrgNo != "" {
+		name.ExtraNames = []pkix.AttributeTypeAndValue{
+			{Type: oidOrganizationIdentifier, Value: "NTRNO-" + s.ctx.ServiceOwnerOrgNo},
+		}
+	}
+	return name
+}
@@ -0,0 +1,177 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestCreateJwksSignedByCA(t *testing.T) {
+	g := NewWithT(t)
+
+	service, clock := createService()
+	ca, err := service.CreateCertificateAuthority("Altinn Operator CA", clock.Now().Add(time.Hour*24*365))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(ca.Certificate().IsCA).To(BeTrue())
+	service = service.WithCertificateAuthority(ca)
+
+	jwks, err := service.CreateJwks(appId, getNotAfter(clock))
+	g.Expect(err).NotTo(HaveOccurred())
+	certs := jwks.Keys[0].Certificates()
+	g.Expect(certs).To(HaveLen(2))
+	g.Expect(certs[1]).To(Equal(ca.Certificate()))
+
+	leaf := certs[0]
+	g.Expect(leaf.Issuer.String()).To(Equal(ca.Certificate().Subject.String()))
+	g.Expect(leaf.Subject.CommonName).To(Equal(appId))
+	g.Expect(leaf.Subject.SerialNumber).To(Equal(service.ctx.ServiceOwnerOrgNo))
+	g.Expect(leaf.Subject.Organization).To(Equal([]string{service.ctx.ServiceOwnerName}))
+	g.Expect(leaf.Subject.Names).To(ContainElement(HaveField("Value", "NTRNO-"+service.ctx.ServiceOwnerOrgNo)))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Certificate())
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: clock.Now(),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// The chain must survive serialization through 'x5c'
+	payload, err := json.Marshal(jwks)
+	g.Expect(err).NotTo(HaveOccurred())
+	deserialized := &Jwks{}
+	g.Expect(json.Unmarshal(payload, deserialized)).To(Succeed())
+	g.Expect(deserialized.Keys[0].Certificates()).To(HaveLen(2))
+
+	// Rotation keeps signing with the CA
+	clock.Advance(time.Hour * 24 * 25)
+	rotated, err := service.RotateIfNeeded(appId, getNotAfter(clock), deserialized)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(rotated.Keys).To(HaveLen(2))
+	g.Expect(rotated.Keys[0].Certificates()).To(HaveLen(2))
+	g.Expect(rotated.Keys[0].Certificates()[0].CheckSignatureFrom(ca.Certificate())).To(Succeed())
+}
+
+func TestCreateJwksSelfSignedWithoutCA(t *testing.T) {
+	g := NewWithT(t)
+
+	jwks, _, _, err := createTestJwks()
+	g.Expect(err).NotTo(HaveOccurred())
+	certs := jwks.Keys[0].Certificates()
+	g.Expect(certs).To(HaveLen(1))
+	g.Expect(certs[0].Issuer.String()).To(Equal(certs[0].Subject.String()))
+	g.Expect(certs[0].CheckSignature(certs[0].SignatureAlgorithm, certs[0].RawTBSCertificate, certs[0].Signature)).To(Succeed())
+}
+
+func TestCreateJwksIsLimitedByCAExpiry(t *testing.T) {
+	g := NewWithT(t)
+
+	service, clock := createService()
+	caNotAfter := clock.Now().UTC().Add(time.Hour * 24 * 10).Truncate(time.Second)
+	ca, err := service.CreateCertificateAuthority("Altinn Operator CA", caNotAfter)
+	g.Expect(err).NotTo(HaveOccurred())
+	service = service.WithCertificateAuthority(ca)
+
+	jwks, err := service.CreateJwks(appId, getNotAfter(clock))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(jwks.Keys[0].Certificates()[0].NotAfter).To(Equal(caNotAfter))
+
+	// Rotating wouldn't give a longer lived key, so the active one is kept until it expires
+	clock.Advance(time.Hour * 24 * 5)
+	rotated, err := service.RotateIfNeeded(appId, getNotAfter(clock), jwks)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(rotated).To(BeNil())
+
+	clock.Advance(time.Hour * 24 * 6)
+	_, err = service.CreateJwks(appId, getNotAfter(clock))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestLoadCertificateAuthorityRoundtrip(t *testing.T) {
+	g := NewWithT(t)
+
+	service, clock := createService()
+	ca, err := service.CreateCertificateAuthority("Altinn Operator CA", clock.Now().Add(time.Hour*24*365))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	certPem, keyPem, err := ca.EncodePem()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	loaded, err := LoadCertificateAuthority(certPem, keyPem, clock.Now())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(loaded.Certificate().Equal(ca.Certificate())).To(BeTrue())
+	g.Expect(loaded.Chain()).To(HaveLen(1))
+
+	// Key must belong to the certificate
+	other, err := service.CreateCertificateAuthority("Other CA", clock.Now().Add(time.Hour*24*365))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, otherKeyPem, err := other.EncodePem()
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = LoadCertificateAuthority(certPem, otherKeyPem, clock.Now())
+	g.Expect(err).To(HaveOccurred())
+
+	// Leaf certificates can't be used as a CA
+	jwks, err := service.CreateJwks(appId, getNotAfter(clock))
+	g.Expect(err).NotTo(HaveOccurred())
+	leafCa := &CertificateAuthority{cert: jwks.Keys[0].Certificates()[0], chain: jwks.Keys[0].Certificates(), key: ca.key}
+	leafPem, _, err := leafCa.EncodePem()
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = LoadCertificateAuthority(leafPem, keyPem, clock.Now())
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestLoadCertificateAuthorityRequiresRemainingValidity(t *testing.T) {
+	g := NewWithT(t)
+
+	service, clock := createService()
+	ca, err := service.CreateCertificateAuthority("Altinn Operator CA", clock.Now().Add(RotationThreshold*2))
+	g.Expect(err).NotTo(HaveOccurred())
+	certPem, keyPem, err := ca.EncodePem()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = LoadCertificateAuthority(certPem, keyPem, clock.Now())
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = LoadCertificateAuthority(certPem, keyPem, clock.Now().Add(RotationThreshold+time.Hour))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestLoadCertificateAuthorityWithECKey(t *testing.T) {
+	g := NewWithT(t)
+
+	service, clock := createService()
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	g.Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               service.getSubject("Altinn Operator EC CA"),
+		NotBefore:             clock.Now(),
+		NotAfter:              clock.Now().Add(time.Hour * 24 * 365),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, template, &ecKey.PublicKey, ecKey)
+	g.Expect(err).NotTo(HaveOccurred())
+	keyBytes, err := x509.MarshalECPrivateKey(ecKey)
+	g.Expect(err).NotTo(HaveOccurred())
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+
+	ca, err := LoadCertificateAuthority(certPem, keyPem, clock.Now())
+	g.Expect(err).NotTo(HaveOccurred())
+
+	jwks, err := service.WithCertificateAuthority(ca).CreateJwks(appId, getNotAfter(clock))
+	g.Expect(err).NotTo(HaveOccurred())
+	leaf := jwks.Keys[0].Certificates()[0]
+	g.Expect(leaf.SignatureAlgorithm).To(Equal(x509.ECDSAWithSHA512))
+	g.Expect(leaf.CheckSignatureFrom(ca.Certificate())).To(Succeed())
+}
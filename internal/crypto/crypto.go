@@ -3,12 +3,12 @@ package crypto
 import (
 	"crypto/rsa"
 	"crypto/x509"
-	"crypto/x509/pkix"
 	"fmt"
 	"io"
 	"math/big"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/altinn/altinn-k8s-operator/internal/assert"
@@ -17,11 +17,15 @@ import (
 	"github.com/go-jose/go-jose/v4"
 	"github.com/google/uuid"
 	"github.com/jonboulle/clockwork"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 const DefaultX509SignatureAlgo x509.SignatureAlgorithm = x509.SHA512WithRSA
 const DefaultKeySizeBits int = 4096
 
+// RotationThreshold is how long before expiry app keys are rotated
+const RotationThreshold = time.Hour * 24 * 7
+
 type CryptoService struct {
 	ctx               *operatorcontext.Context
 	clock             clockwork.Clock
@@ -29,6 +33,18 @@ type CryptoService struct {
 	signatureAlgo     jose.SignatureAlgorithm
 	x509SignatureAlgo x509.SignatureAlgorithm
 	keySizeBits       int
+	// Optional, when set app certificates are signed by the CA instead of being self-signed
+	ca *CertificateAuthority
+	// Set for copies which only plan, see `WithPlaceholderKeys`
+	usePlaceholderKeys bool
+	placeholder        *placeholderKey
+}
+
+// placeholderKey is generated once and shared by all copies of a service
+type placeholderKey struct {
+	mu     sync.Mutex
+	certs  []*x509.Certificate
+	rsaKey *rsa.PrivateKey
 }
 
 func NewService(
@@ -51,6 +67,7 @@ func NewService(
 		signatureAlgo:     signatureAlgo,
 		x509SignatureAlgo: x509SignatureAlgo,
 		keySizeBits:       keySizeBits,
+		placeholder:       &placeholderKey{},
 	}
 }
 
@@ -62,20 +79,41 @@ func NewDefaultService(
 	return NewService(ctx, clock, random, DefaultX509SignatureAlgo, DefaultKeySizeBits)
 }
 
+// WithCertificateAuthority returns a copy of the service which signs app certificates using the given CA
+func (s *CryptoService) WithCertificateAuthority(ca *CertificateAuthority) *CryptoService {
+	clone := *s
+	clone.ca = ca
+	return &clone
+}
+
+// WithPlaceholderKeys returns a copy of the service which reuses a single key pair for every new JWKS.
+// Key IDs are still unique. Only for planning commands that are never executed, such as in dry-run,
+// since generating RSA keys is expensive.
+func (s *CryptoService) WithPlaceholderKeys() *CryptoService {
+	clone := *s
+	clone.usePlaceholderKeys = true
+	return &clone
+}
+
+func (s *CryptoService) CertificateAuthority() *CertificateAuthority {
+	return s.ca
+}
+
 // Creates a JWKS
 // Constructs the JWKS from the whole RSA private/public key pair
 // Uses SHA512 with RSA, 4096 bits for RSA
+// If an operator CA is configured, the cert is signed by the CA and the chain is included in 'x5c'
 func (s *CryptoService) CreateJwks(certCommonName string, notAfter time.Time) (*Jwks, error) {
-	cert, rsaKey, err := s.createCert(certCommonName, notAfter)
+	certs, rsaKey, err := s.createCert(certCommonName, notAfter)
 	if err != nil {
 		return nil, errors.WrapPrefix(err, "error creating JWKS cert", 0)
 	}
 
-	return s.createJWKS(cert, rsaKey, 0)
+	return s.createJWKS(certs, rsaKey, 0)
 }
 
 func (s *CryptoService) createJWKS(
-	cert *x509.Certificate,
+	certs []*x509.Certificate,
 	rsaKey *rsa.PrivateKey,
 	index int,
 ) (*Jwks, error) {
@@ -84,7 +122,7 @@ func (s *CryptoService) createJWKS(
 		return nil, err
 	}
 	keyId := fmt.Sprintf("%s.%d", id.String(), index)
-	return NewJwks(NewJwk([]*x509.Certificate{cert}, rsaKey, keyId, "sig", string(s.signatureAlgo))), nil
+	return NewJwks(NewJwk(certs, rsaKey, keyId, "sig", string(s.signatureAlgo))), nil
 }
 
 func (s *CryptoService) generateCertSerialNumber() (*big.Int, error) {
@@ -103,10 +141,31 @@ func (s *CryptoService) generateCertSerialNumber() (*big.Int, error) {
 	return serial, nil
 }
 
+// Returns the certificate chain, starting with the leaf certificate for the generated key
 func (s *CryptoService) createCert(
 	certCommonName string,
 	notAfter time.Time,
-) (*x509.Certificate, *rsa.PrivateKey, error) {
+) ([]*x509.Certificate, *rsa.PrivateKey, error) {
+	if !s.usePlaceholderKeys {
+		return s.generateCert(certCommonName, notAfter)
+	}
+
+	s.placeholder.mu.Lock()
+	defer s.placeholder.mu.Unlock()
+	if s.placeholder.rsaKey == nil {
+		certs, rsaKey, err := s.generateCert(certCommonName, notAfter)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.placeholder.certs, s.placeholder.rsaKey = certs, rsaKey
+	}
+	return s.placeholder.certs, s.placeholder.rsaKey, nil
+}
+
+func (s *CryptoService) generateCert(
+	certCommonName string,
+	notAfter time.Time,
+) ([]*x509.Certificate, *rsa.PrivateKey, error) {
 	rsaKey, err := rsa.GenerateKey(s.random, s.keySizeBits)
 	if err != nil {
 		return nil, nil, errors.WrapPrefix(err, "error generating RSA key for jwks", 0)
@@ -121,14 +180,21 @@ func (s *CryptoService) createCert(
 	if now.Equal(notAfter) || now.After(notAfter) {
 		return nil, nil, errors.Errorf("notAfter (%s) must be after current time (%s)", notAfter, now)
 	}
+	if s.ca != nil && notAfter.After(s.ca.cert.NotAfter) {
+		// Certificates can't outlive the CA, the CA must be renewed to get full lifetimes again
+		log.Log.WithName("crypto").Info(
+			"WARNING: app certificate lifetime is limited by the expiry of the operator CA",
+			"requestedNotAfter", notAfter, "caNotAfter", s.ca.cert.NotAfter,
+		)
+		notAfter = s.ca.cert.NotAfter
+		if !notAfter.After(now) {
+			return nil, nil, errors.Errorf("operator CA expired at %s", notAfter)
+		}
+	}
 
 	certTemplate := x509.Certificate{
-		SerialNumber: serial,
-		Subject: pkix.Name{
-			Organization: []string{s.ctx.ServiceOwnerName},
-			CommonName:   certCommonName,
-		},
-		Issuer:                s.getIssuer(),
+		SerialNumber:          serial,
+		Subject:               s.getSubject(certCommonName),
 		NotBefore:             now,
 		NotAfter:              notAfter,
 		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
@@ -137,7 +203,16 @@ func (s *CryptoService) createCert(
 		SignatureAlgorithm:    s.x509SignatureAlgo,
 	}
 
-	derBytes, err := x509.CreateCertificate(s.random, &certTemplate, &certTemplate, &rsaKey.PublicKey, rsaKey)
+	// Self-signed unless we have a CA, the issuer is taken from the parent
+	parent := &certTemplate
+	var signer any = rsaKey
+	if s.ca != nil {
+		parent = s.ca.cert
+		signer = s.ca.key
+		certTemplate.SignatureAlgorithm = s.ca.signatureAlgorithm(s.x509SignatureAlgo)
+	}
+
+	derBytes, err := x509.CreateCertificate(s.random, &certTemplate, parent, &rsaKey.PublicKey, signer)
 	if err != nil {
 		return nil, nil, errors.WrapPrefix(err, "error generating cert for jwks", 0)
 	}
@@ -146,7 +221,12 @@ func (s *CryptoService) createCert(
 		return nil, nil, errors.WrapPrefix(err, "error parsing generated cert for jwks", 0)
 	}
 
-	return cert, rsaKey, nil
+	certs := []*x509.Certificate{cert}
+	if s.ca != nil {
+		certs = append(certs, s.ca.chain...)
+	}
+
+	return certs, rsaKey, nil
 }
 
 func (s *CryptoService) RotateIfNeeded(
@@ -164,7 +244,7 @@ func (s *CryptoService) RotateIfNeeded(
 		if activeKey == nil {
 			activeKey = currentJwks.Keys[i]
 			certificateCount := len(activeKey.Certificates())
-			if certificateCount != 1 {
+			if certificateCount == 0 {
 				return nil, errors.Errorf(
 					"unexpected number of certificates for key '%s': '%d'",
 					activeKey.KeyID(),
@@ -177,7 +257,7 @@ func (s *CryptoService) RotateIfNeeded(
 
 			certificates := key.Certificates()
 			certificateCount := len(certificates)
-			if certificateCount != 1 {
+			if certificateCount == 0 {
 				return nil, errors.Errorf("unexpected number of certificates for key '%s': '%d'", key.KeyID(), certificateCount)
 			}
 
@@ -190,8 +270,12 @@ func (s *CryptoService) RotateIfNeeded(
 		}
 	}
 
-	rotationThreshold := s.clock.Now().UTC().Add(time.Hour * 24 * 7)
-	if activeKey.Certificates()[0].NotAfter.After(rotationThreshold) {
+	rotationThreshold := s.clock.Now().UTC().Add(RotationThreshold)
+	activeNotAfter := activeKey.Certificates()[0].NotAfter
+	if activeNotAfter.After(rotationThreshold) {
+		return nil, nil
+	} else if s.ca != nil && !activeNotAfter.Before(s.ca.cert.NotAfter) && activeNotAfter.After(s.clock.Now()) {
+		// A new certificate couldn't outlive the active one, since both are limited by the CA
 		return nil, nil
 	} else {
 		keyParts := strings.Split(activeKey.KeyID(), ".")
@@ -200,12 +284,12 @@ func (s *CryptoService) RotateIfNeeded(
 		if err != nil {
 			return nil, errors.Errorf("invalid key format: %s", activeKey.KeyID())
 		}
-		cert, rsaKey, err := s.createCert(certCommonName, notAfter)
+		certs, rsaKey, err := s.createCert(certCommonName, notAfter)
 		if err != nil {
 			return nil, errors.WrapPrefix(err, "error creating JWKS cert", 0)
 		}
 
-		newJwks, err := s.createJWKS(cert, rsaKey, currentIndex+1)
+		newJwks, err := s.createJWKS(certs, rsaKey, currentIndex+1)
 		if err != nil {
 			return nil, err
 		}
@@ -214,13 +298,6 @@ func (s *CryptoService) RotateIfNeeded(
 	}
 }
 
-func (s *CryptoService) getIssuer() pkix.Name {
-	return pkix.Name{
-		Organization: []string{"Digdir"},
-		CommonName:   "Altinn Operator",
-	}
-}
-
 func SignatureAlgorithmNameFromX509(algo x509.SignatureAlgorithm) (string, bool) {
 	name, ok := signatureAlgorithmFromX509(algo)
 	if !ok {
@@ -96,6 +96,29 @@ func TestGenerateCertSerialNumber(t *testing.T) {
 	snaps.MatchSnapshot(t, serial.String())
 }
 
+func TestPlaceholderKeysAreReused(t *testing.T) {
+	g := NewWithT(t)
+
+	service, clock := createService()
+	planning := service.WithPlaceholderKeys()
+	first, err := planning.CreateJwks(appId, getNotAfter(clock))
+	g.Expect(err).NotTo(HaveOccurred())
+	second, err := planning.CreateJwks(appId, getNotAfter(clock))
+	g.Expect(err).NotTo(HaveOccurred())
+	// Every copy shares the placeholder
+	third, err := service.WithPlaceholderKeys().CreateJwks(appId, getNotAfter(clock))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(second.Keys[0].Certificates()[0]).To(BeIdenticalTo(first.Keys[0].Certificates()[0]))
+	g.Expect(third.Keys[0].Certificates()[0]).To(BeIdenticalTo(first.Keys[0].Certificates()[0]))
+	g.Expect(second.Keys[0].KeyID()).NotTo(Equal(first.Keys[0].KeyID()))
+
+	// The service itself still generates new keys
+	generated, err := service.CreateJwks(appId, getNotAfter(clock))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(generated.Keys[0].Certificates()[0]).NotTo(BeIdenticalTo(first.Keys[0].Certificates()[0]))
+}
+
 func TestPublicJwksConversion(t *testing.T) {
 	g := NewWithT(t)
 
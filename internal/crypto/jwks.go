@@ -111,3 +111,34 @@ func (j *Jwks) ToPublic() (*Jwks, error) {
 
 	return result, nil
 }
+
+// Verify finds the key matching the 'kid' and 'alg' of the JWT header
+// and returns the claims if the signature is valid. Claims are not validated, see `ValidateClaims`.
+func (j *Jwks) Verify(jwt *Jwt) (*Claims, error) {
+	if j == nil {
+		return nil, errors.New("can't verify JWT, JWKS was null")
+	}
+	if jwt == nil {
+		return nil, errors.New("can't verify JWT, JWT was null")
+	}
+
+	keyId := jwt.KeyID()
+	if keyId == "" {
+		return nil, errors.New("can't verify JWT, missing kid")
+	}
+
+	for _, jwk := range j.Keys {
+		if jwk.KeyID() != keyId {
+			continue
+		}
+		if jwk.Algorithm() != "" && jwk.Algorithm() != jwt.Algorithm() {
+			return nil, errors.Errorf("algorithm mismatch for key '%s': '%s' != '%s'", keyId, jwt.Algorithm(), jwk.Algorithm())
+		}
+		if !jwk.IsPublic() {
+			jwk = jwk.Public()
+		}
+		return jwt.DecodeClaims(jwk)
+	}
+
+	return nil, errors.Errorf("no key found for kid: '%s'", keyId)
+}
@@ -2,6 +2,8 @@ package crypto
 
 import (
 	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/altinn/altinn-k8s-operator/internal/assert"
@@ -87,6 +89,11 @@ func (j *Jwt) KeyID() string {
 	return j.token.Headers[0].KeyID
 }
 
+func (j *Jwt) Algorithm() string {
+	assert.AssertWith(len(j.token.Headers) == 1, "unexpected number of headers in JWT")
+	return j.token.Headers[0].Algorithm
+}
+
 func NewJWT(
 	jwk *Jwk,
 	audience []string,
@@ -136,3 +143,110 @@ func NewJWT(
 
 	return signedToken, nil
 }
+
+var (
+	ErrJwtInvalidAudience = errors.New("JWT has invalid audience")
+	ErrJwtInvalidIssuer   = errors.New("JWT has invalid issuer")
+	ErrJwtExpired         = errors.New("JWT is expired")
+	ErrJwtNotYetValid     = errors.New("JWT is not yet valid")
+	ErrJwtIssuedInFuture  = errors.New("JWT is issued in the future")
+	ErrJwtLifetimeTooLong = errors.New("JWT lifetime exceeds maximum")
+	ErrJwtMissingClaim    = errors.New("JWT is missing required claim")
+	ErrJwtReplayed        = errors.New("JWT has already been used")
+)
+
+// ClaimsValidation describes the expectations for claims of a JWT, see `ValidateClaims`
+type ClaimsValidation struct {
+	// Expected to be contained in 'aud'
+	Audience string
+	// Expected 'iss', not checked if empty
+	Issuer string
+	// Tolerance applied to 'exp', 'nbf' and 'iat'
+	ClockSkew time.Duration
+	// Maximum allowed duration between 'iat' and 'exp', not checked if zero
+	MaxLifetime time.Duration
+	Clock       clockwork.Clock
+	// Optional, when set 'jti' is required and can only be used once
+	JtiCache *JtiCache
+}
+
+// ValidateClaims checks the registered claims of a JWT, after signature verification (see `DecodeClaims`).
+// This mirrors the checks done by Maskinporten for JWT grants, errors wrap the `ErrJwt*` sentinels.
+func ValidateClaims(claims *Claims, validation *ClaimsValidation) error {
+	assert.AssertWith(validation != nil && validation.Clock != nil, "claims validation requires a clock")
+	if claims == nil {
+		return errors.New("claims cannot be nil")
+	}
+
+	if validation.Audience == "" || !slices.Contains(claims.Audience, validation.Audience) {
+		return errors.WrapPrefix(ErrJwtInvalidAudience, strings.Join(claims.Audience, ","), 0)
+	}
+	if validation.Issuer != "" && claims.Issuer != validation.Issuer {
+		return errors.WrapPrefix(ErrJwtInvalidIssuer, claims.Issuer, 0)
+	}
+	if claims.Expiry.IsZero() {
+		return errors.WrapPrefix(ErrJwtMissingClaim, "exp", 0)
+	}
+	if claims.IssuedAt.IsZero() {
+		return errors.WrapPrefix(ErrJwtMissingClaim, "iat", 0)
+	}
+
+	now := validation.Clock.Now()
+	skew := validation.ClockSkew
+	if now.After(claims.Expiry.Add(skew)) {
+		return errors.WrapPrefix(ErrJwtExpired, claims.Expiry.String(), 0)
+	}
+	if !claims.NotBefore.IsZero() && now.Add(skew).Before(claims.NotBefore) {
+		return errors.WrapPrefix(ErrJwtNotYetValid, claims.NotBefore.String(), 0)
+	}
+	if now.Add(skew).Before(claims.IssuedAt) {
+		return errors.WrapPrefix(ErrJwtIssuedInFuture, claims.IssuedAt.String(), 0)
+	}
+	if validation.MaxLifetime > 0 && claims.Expiry.Sub(claims.IssuedAt) > validation.MaxLifetime {
+		return errors.WrapPrefix(ErrJwtLifetimeTooLong, claims.Expiry.Sub(claims.IssuedAt).String(), 0)
+	}
+
+	if validation.JtiCache != nil {
+		if claims.ID == "" {
+			return errors.WrapPrefix(ErrJwtMissingClaim, "jti", 0)
+		}
+		if !validation.JtiCache.Use(claims.ID, claims.Expiry.Add(skew)) {
+			return errors.WrapPrefix(ErrJwtReplayed, claims.ID, 0)
+		}
+	}
+
+	return nil
+}
+
+// JtiCache remembers 'jti' values of JWTs until they expire, to detect replays
+type JtiCache struct {
+	lock  sync.Mutex
+	clock clockwork.Clock
+	seen  map[string]time.Time
+}
+
+func NewJtiCache(clock clockwork.Clock) *JtiCache {
+	return &JtiCache{
+		clock: clock,
+		seen:  make(map[string]time.Time),
+	}
+}
+
+// Use records the jti as used until `until`, returns false if the jti has already been used
+func (c *JtiCache) Use(jti string, until time.Time) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.clock.Now()
+	for id, expiry := range c.seen {
+		if now.After(expiry) {
+			delete(c.seen, id)
+		}
+	}
+
+	if _, ok := c.seen[jti]; ok {
+		return false
+	}
+	c.seen[jti] = until
+	return true
+}
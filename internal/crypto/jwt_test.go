@@ -0,0 +1,154 @@
+package crypto
+
+import (
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/gomega"
+)
+
+const testAudience string = "http://localhost:8050"
+const testIssuer string = "client-id"
+
+func TestValidateClaimsOfSignedJwt(t *testing.T) {
+	g := NewWithT(t)
+
+	jwks, _, clock, err := createTestJwks()
+	g.Expect(err).NotTo(HaveOccurred())
+	jwk := jwks.Keys[0]
+
+	token, err := jwk.NewJWT([]string{testAudience}, testIssuer, "scope", clock.Now().Add(60*time.Second), clock)
+	g.Expect(err).NotTo(HaveOccurred())
+	jwt, err := ParseJWT(token)
+	g.Expect(err).NotTo(HaveOccurred())
+	claims, err := jwt.DecodeClaims(jwk.Public())
+	g.Expect(err).NotTo(HaveOccurred())
+
+	validation := newTestValidation(clock)
+	g.Expect(ValidateClaims(claims, validation)).To(Succeed())
+
+	// Same grant can't be used twice
+	err = ValidateClaims(claims, validation)
+	g.Expect(errors.Is(err, ErrJwtReplayed)).To(BeTrue())
+
+	// After expiry the jti is forgotten, but the grant is expired anyway
+	clock.Advance(2 * time.Minute)
+	err = ValidateClaims(claims, validation)
+	g.Expect(errors.Is(err, ErrJwtExpired)).To(BeTrue())
+}
+
+func TestValidateClaimsRejectsInvalidClaims(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	now := clock.Now()
+
+	validClaims := func() *Claims {
+		return &Claims{
+			Audience:  []string{testAudience},
+			Issuer:    testIssuer,
+			IssuedAt:  now,
+			NotBefore: now,
+			Expiry:    now.Add(60 * time.Second),
+			ID:        "jti",
+		}
+	}
+
+	tests := []struct {
+		name     string
+		modify   func(c *Claims)
+		expected error
+	}{
+		{"valid", func(c *Claims) {}, nil},
+		{"wrong audience", func(c *Claims) { c.Audience = []string{"http://other"} }, ErrJwtInvalidAudience},
+		{"missing audience", func(c *Claims) { c.Audience = nil }, ErrJwtInvalidAudience},
+		{"wrong issuer", func(c *Claims) { c.Issuer = "other" }, ErrJwtInvalidIssuer},
+		{"missing exp", func(c *Claims) { c.Expiry = time.Time{} }, ErrJwtMissingClaim},
+		{"missing iat", func(c *Claims) { c.IssuedAt = time.Time{} }, ErrJwtMissingClaim},
+		{"missing jti", func(c *Claims) { c.ID = "" }, ErrJwtMissingClaim},
+		{"expired", func(c *Claims) { c.Expiry = now.Add(-11 * time.Second) }, ErrJwtExpired},
+		{"expired within skew", func(c *Claims) { c.Expiry = now.Add(-9 * time.Second) }, nil},
+		{"not yet valid", func(c *Claims) { c.NotBefore = now.Add(11 * time.Second) }, ErrJwtNotYetValid},
+		{"not yet valid within skew", func(c *Claims) { c.NotBefore = now.Add(9 * time.Second) }, nil},
+		{"missing nbf", func(c *Claims) { c.NotBefore = time.Time{} }, nil},
+		{"issued in future", func(c *Claims) { c.IssuedAt = now.Add(11 * time.Second) }, ErrJwtIssuedInFuture},
+		{"lifetime too long", func(c *Claims) { c.Expiry = now.Add(121 * time.Second) }, ErrJwtLifetimeTooLong},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			claims := validClaims()
+			tt.modify(claims)
+			err := ValidateClaims(claims, newTestValidation(clock))
+			if tt.expected == nil {
+				g.Expect(err).NotTo(HaveOccurred())
+			} else {
+				g.Expect(errors.Is(err, tt.expected)).To(BeTrue(), "unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func newTestValidation(clock clockwork.Clock) *ClaimsValidation {
+	return &ClaimsValidation{
+		Audience:    testAudience,
+		Issuer:      testIssuer,
+		ClockSkew:   10 * time.Second,
+		MaxLifetime: 120 * time.Second,
+		Clock:       clock,
+		JtiCache:    NewJtiCache(clock),
+	}
+}
+
+func TestJwksVerifySelectsKeyByKid(t *testing.T) {
+	g := NewWithT(t)
+
+	jwks, service, clock, err := createTestJwks()
+	g.Expect(err).NotTo(HaveOccurred())
+	clock.Advance(time.Hour * 24 * 25)
+	rotatedJwks, err := service.RotateIfNeeded(appId, getNotAfter(clock), jwks)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(rotatedJwks.Keys).To(HaveLen(2))
+	publicJwks, err := rotatedJwks.ToPublic()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// Both the new and the previous key are live
+	for _, jwk := range rotatedJwks.Keys {
+		token, err := jwk.NewJWT([]string{testAudience}, testIssuer, "scope", clock.Now().Add(60*time.Second), clock)
+		g.Expect(err).NotTo(HaveOccurred())
+		jwt, err := ParseJWT(token)
+		g.Expect(err).NotTo(HaveOccurred())
+
+		claims, err := publicJwks.Verify(jwt)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(claims.Issuer).To(Equal(testIssuer))
+
+		// Private keysets work as well
+		_, err = rotatedJwks.Verify(jwt)
+		g.Expect(err).NotTo(HaveOccurred())
+	}
+
+	token, err := rotatedJwks.Keys[1].NewJWT([]string{testAudience}, testIssuer, "scope", clock.Now().Add(60*time.Second), clock)
+	g.Expect(err).NotTo(HaveOccurred())
+	jwt, err := ParseJWT(token)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// Unknown kid
+	_, err = NewJwks(publicJwks.Keys[0]).Verify(jwt)
+	g.Expect(err).To(HaveOccurred())
+
+	// Same key ID, but the key was registered with another algorithm
+	inner := rotatedJwks.Keys[1].inner
+	otherAlgJwk := NewJwk(inner.Certificates, inner.Key.(*rsa.PrivateKey), inner.KeyID, inner.Use, "RS256")
+	_, err = NewJwks(otherAlgJwk).Verify(jwt)
+	g.Expect(err).To(HaveOccurred())
+
+	// Same key ID, but a different key
+	other := publicJwks.Keys[0].inner
+	otherKeyJwk := &Jwk{inner: other}
+	otherKeyJwk.inner.KeyID = inner.KeyID
+	_, err = NewJwks(otherKeyJwk).Verify(jwt)
+	g.Expect(err).To(HaveOccurred())
+}
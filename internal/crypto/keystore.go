@@ -0,0 +1,247 @@
+package crypto
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/go-errors/errors"
+)
+
+type KeyStoreBackend string
+
+const (
+	// Private keys are kept in the app secret alongside the rest of the Maskinporten settings
+	KeyStoreBackendSecret KeyStoreBackend = "secret"
+	// Private keys are kept in Azure Key Vault, the app secret only contains references
+	KeyStoreBackendAzureKeyVault KeyStoreBackend = "azure_key_vault"
+)
+
+// ErrKeyRefsUnresolvable is returned from `KeyStore.Get` and `KeyStore.Delete` when the references were written
+// by another backend or vault, e.g. after switching from an external key store back to the secret.
+// The referenced keys should be treated as lost, and can only be cleaned up manually
+var ErrKeyRefsUnresolvable = errors.New("key references can't be resolved by the configured key store")
+
+// KeyRef references private key material held by a KeyStore
+type KeyRef struct {
+	KeyID string `json:"kid"`
+	Uri   string `json:"uri"`
+}
+
+// KeyStore decides where the private keys generated by `CryptoService` live.
+// The in-secret backend keeps the current behavior where the full JWKS is written to the app secret.
+// External backends persist key material themselves and hand back references to be written instead.
+type KeyStore interface {
+	Backend() KeyStoreBackend
+	// Put persists the private keys of the JWKS. Returns nil references if the
+	// key material should be kept in the app secret
+	Put(ctx context.Context, name string, jwks *Jwks) ([]KeyRef, error)
+	// Get resolves references returned from `Put` into the private JWKS, in the same order
+	Get(ctx context.Context, refs []KeyRef) (*Jwks, error)
+	// Delete removes the referenced keys from the backend. References from another backend are skipped,
+	// and reported with `ErrKeyRefsUnresolvable` once the rest are deleted
+	Delete(ctx context.Context, refs []KeyRef) error
+}
+
+type SecretKeyStore struct{}
+
+var _ KeyStore = (*SecretKeyStore)(nil)
+
+func NewSecretKeyStore() *SecretKeyStore {
+	return &SecretKeyStore{}
+}
+
+func (s *SecretKeyStore) Backend() KeyStoreBackend {
+	return KeyStoreBackendSecret
+}
+
+func (s *SecretKeyStore) Put(ctx context.Context, name string, jwks *Jwks) ([]KeyRef, error) {
+	return nil, nil
+}
+
+func (s *SecretKeyStore) Get(ctx context.Context, refs []KeyRef) (*Jwks, error) {
+	if len(refs) != 0 {
+		return nil, ErrKeyRefsUnresolvable
+	}
+	return nil, nil
+}
+
+func (s *SecretKeyStore) Delete(ctx context.Context, refs []KeyRef) error {
+	if len(refs) != 0 {
+		return unresolvableKeyRefsError(refs)
+	}
+	return nil
+}
+
+const azureKeyVaultJwkContentType string = "application/jwk+json"
+
+// AzureKeyVaultKeyStore keeps each private JWK as a Key Vault secret, named
+// after the owner of the key and the key ID. References point to the exact secret version.
+type AzureKeyVaultKeyStore struct {
+	client   *azsecrets.Client
+	vaultUrl string
+}
+
+var _ KeyStore = (*AzureKeyVaultKeyStore)(nil)
+
+func NewAzureKeyVaultKeyStore(
+	vaultUrl string,
+	cred azcore.TokenCredential,
+	options *azsecrets.ClientOptions,
+) (*AzureKeyVaultKeyStore, error) {
+	client, err := azsecrets.NewClient(vaultUrl, cred, options)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "error building client for Azure Key Vault key store", 0)
+	}
+
+	return &AzureKeyVaultKeyStore{
+		client:   client,
+		vaultUrl: strings.TrimSuffix(vaultUrl, "/"),
+	}, nil
+}
+
+func (s *AzureKeyVaultKeyStore) Backend() KeyStoreBackend {
+	return KeyStoreBackendAzureKeyVault
+}
+
+func (s *AzureKeyVaultKeyStore) Put(ctx context.Context, name string, jwks *Jwks) ([]KeyRef, error) {
+	if jwks == nil {
+		return nil, errors.New("can't store keys, JWKS was null")
+	}
+
+	refs := make([]KeyRef, 0, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.IsPublic() {
+			return nil, errors.Errorf("tried to store public key '%s' in key store", jwk.KeyID())
+		}
+
+		secretName := azureKeyVaultSecretName(name, jwk.KeyID())
+		existing, err := s.client.GetSecret(ctx, secretName, "", nil)
+		if err != nil && !isAzureNotFound(err) {
+			return nil, errors.WrapPrefix(err, fmt.Sprintf("error looking up key '%s' in Azure Key Vault", jwk.KeyID()), 0)
+		}
+		if err == nil && existing.ID != nil && existing.Tags["kid"] != nil && *existing.Tags["kid"] == jwk.KeyID() {
+			// Keys are immutable once created, so when rotating we just reference the existing version
+			refs = append(refs, KeyRef{KeyID: jwk.KeyID(), Uri: string(*existing.ID)})
+			continue
+		}
+
+		value, err := json.Marshal(jwk)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := s.client.SetSecret(ctx, secretName, azsecrets.SetSecretParameters{
+			Value:       to.Ptr(string(value)),
+			ContentType: to.Ptr(azureKeyVaultJwkContentType),
+			Tags:        map[string]*string{"kid": to.Ptr(jwk.KeyID())},
+		}, nil)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, fmt.Sprintf("error storing key '%s' in Azure Key Vault", jwk.KeyID()), 0)
+		}
+		if resp.ID == nil {
+			return nil, errors.Errorf("missing ID when storing key '%s' in Azure Key Vault", jwk.KeyID())
+		}
+
+		refs = append(refs, KeyRef{KeyID: jwk.KeyID(), Uri: string(*resp.ID)})
+	}
+
+	return refs, nil
+}
+
+func (s *AzureKeyVaultKeyStore) Get(ctx context.Context, refs []KeyRef) (*Jwks, error) {
+	jwks := &Jwks{Keys: make([]*Jwk, 0, len(refs))}
+	for _, ref := range refs {
+		id, err := s.parseRef(ref)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := s.client.GetSecret(ctx, id.Name(), id.Version(), nil)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, fmt.Sprintf("error getting key '%s' from Azure Key Vault", ref.KeyID), 0)
+		}
+		if resp.Value == nil {
+			return nil, errors.Errorf("empty value for key '%s' in Azure Key Vault", ref.KeyID)
+		}
+
+		jwk := &Jwk{}
+		if err := json.Unmarshal([]byte(*resp.Value), jwk); err != nil {
+			return nil, errors.WrapPrefix(err, fmt.Sprintf("error parsing key '%s' from Azure Key Vault", ref.KeyID), 0)
+		}
+		if jwk.KeyID() != ref.KeyID {
+			return nil, errors.Errorf("key ID mismatch for reference '%s': '%s'", ref.KeyID, jwk.KeyID())
+		}
+		if jwk.IsPublic() {
+			return nil, errors.Errorf("key '%s' in Azure Key Vault is not a private key", ref.KeyID)
+		}
+
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	return jwks, nil
+}
+
+func (s *AzureKeyVaultKeyStore) Delete(ctx context.Context, refs []KeyRef) error {
+	var unresolvable []KeyRef
+	for _, ref := range refs {
+		id, err := s.parseRef(ref)
+		if errors.Is(err, ErrKeyRefsUnresolvable) {
+			unresolvable = append(unresolvable, ref)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = s.client.DeleteSecret(ctx, id.Name(), nil)
+		if err != nil {
+			return errors.WrapPrefix(err, fmt.Sprintf("error deleting key '%s' from Azure Key Vault", ref.KeyID), 0)
+		}
+	}
+
+	if len(unresolvable) != 0 {
+		return unresolvableKeyRefsError(unresolvable)
+	}
+	return nil
+}
+
+func (s *AzureKeyVaultKeyStore) parseRef(ref KeyRef) (*azsecrets.ID, error) {
+	if !strings.HasPrefix(ref.Uri, s.vaultUrl+"/secrets/") {
+		return nil, errors.Errorf("%w: '%s' does not belong to vault '%s'", ErrKeyRefsUnresolvable, ref.Uri, s.vaultUrl)
+	}
+	id := azsecrets.ID(ref.Uri)
+	if id.Name() == "" || id.Version() == "" {
+		return nil, errors.Errorf("invalid key reference: '%s'", ref.Uri)
+	}
+	return &id, nil
+}
+
+func unresolvableKeyRefsError(refs []KeyRef) error {
+	uris := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		uris = append(uris, ref.Uri)
+	}
+	return errors.Errorf("%w: %s", ErrKeyRefsUnresolvable, strings.Join(uris, ", "))
+}
+
+func isAzureNotFound(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound
+}
+
+// Key Vault secret names can only contain alphanumeric characters and dashes
+func azureKeyVaultSecretName(name string, keyId string) string {
+	sanitize := func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '-'
+	}
+	return strings.Map(sanitize, name) + "--" + strings.Map(sanitize, keyId)
+}
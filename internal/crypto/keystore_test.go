@@ -0,0 +1,133 @@
+package crypto
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/altinn/altinn-k8s-operator/internal/fakes/keyvault"
+	. "github.com/onsi/gomega"
+)
+
+func TestSecretKeyStoreKeepsKeysInSecret(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	jwks, _, _, err := createTestJwks()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	store := NewSecretKeyStore()
+	refs, err := store.Put(ctx, appId, jwks)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(refs).To(BeNil())
+
+	_, err = store.Get(ctx, []KeyRef{{KeyID: "kid", Uri: "uri"}})
+	g.Expect(err).To(MatchError(ErrKeyRefsUnresolvable))
+	// Keys left in an external key store can't be deleted from here
+	err = store.Delete(ctx, []KeyRef{{KeyID: "kid", Uri: "uri"}})
+	g.Expect(err).To(MatchError(ErrKeyRefsUnresolvable))
+	g.Expect(store.Delete(ctx, nil)).To(Succeed())
+}
+
+func TestAzureKeyVaultKeyStore(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	server := keyvault.NewServer()
+	defer server.Close()
+
+	store, err := NewAzureKeyVaultKeyStore(server.URL(), server.Credential(), server.ClientOptions())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(store.Backend()).To(Equal(KeyStoreBackendAzureKeyVault))
+
+	jwks, service, clock, err := createTestJwks()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	refs, err := store.Put(ctx, "altinnoperator-local-local-app1", jwks)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(refs).To(HaveLen(1))
+	g.Expect(refs[0].KeyID).To(Equal(jwks.Keys[0].KeyID()))
+	g.Expect(refs[0].Uri).To(HavePrefix(server.URL() + "/secrets/altinnoperator-local-local-app1--"))
+	g.Expect(server.Names()).To(HaveLen(1))
+
+	loaded, err := store.Get(ctx, refs)
+	g.Expect(err).NotTo(HaveOccurred())
+	expectedJson, err := json.Marshal(jwks)
+	g.Expect(err).NotTo(HaveOccurred())
+	loadedJson, err := json.Marshal(loaded)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(loadedJson).To(MatchJSON(expectedJson))
+
+	// After rotation the previous key is still referenced, but not stored again
+	clock.Advance(time.Hour * 24 * 25)
+	rotatedJwks, err := service.RotateIfNeeded(appId, getNotAfter(clock), loaded)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(rotatedJwks.Keys).To(HaveLen(2))
+	rotatedRefs, err := store.Put(ctx, "altinnoperator-local-local-app1", rotatedJwks)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(rotatedRefs).To(HaveLen(2))
+	g.Expect(rotatedRefs[1]).To(Equal(refs[0]))
+	g.Expect(server.Names()).To(HaveLen(2))
+
+	err = store.Delete(ctx, refs)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(server.Names()).To(HaveLen(1))
+	_, err = store.Get(ctx, refs)
+	g.Expect(err).To(HaveOccurred())
+
+	// References to another vault are reported as unresolvable, and skipped when deleting
+	foreignRef := KeyRef{KeyID: refs[0].KeyID, Uri: "https://other.vault.azure.net/secrets/a/b"}
+	_, err = store.Get(ctx, []KeyRef{foreignRef})
+	g.Expect(err).To(MatchError(ErrKeyRefsUnresolvable))
+	err = store.Delete(ctx, []KeyRef{foreignRef, rotatedRefs[0]})
+	g.Expect(err).To(MatchError(ErrKeyRefsUnresolvable))
+	g.Expect(err.Error()).To(ContainSubstring(foreignRef.Uri))
+	g.Expect(server.Names()).To(BeEmpty())
+}
+
+func TestAzureKeyVaultKeyStoreRejectsPublicKeys(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	server := keyvault.NewServer()
+	defer server.Close()
+
+	store, err := NewAzureKeyVaultKeyStore(server.URL(), server.Credential(), server.ClientOptions())
+	g.Expect(err).NotTo(HaveOccurred())
+
+	jwks, _, _, err := createTestJwks()
+	g.Expect(err).NotTo(HaveOccurred())
+	publicJwks, err := jwks.ToPublic()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = store.Put(ctx, appId, publicJwks)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(server.Names()).To(BeEmpty())
+}
+
+func TestAzureKeyVaultKeyStoreOnlyStoresMissingKeys(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	server := keyvault.NewServer()
+	defer server.Close()
+
+	store, err := NewAzureKeyVaultKeyStore(server.URL(), server.Credential(), server.ClientOptions())
+	g.Expect(err).NotTo(HaveOccurred())
+
+	jwks, _, _, err := createTestJwks()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// Failing lookups must not be mistaken for missing keys
+	server.FailRequests(http.MethodGet, http.StatusForbidden)
+	_, err = store.Put(ctx, appId, jwks)
+	g.Expect(err).To(HaveOccurred())
+	server.FailRequests(http.MethodGet, 0)
+	g.Expect(server.Names()).To(BeEmpty())
+
+	refs, err := store.Put(ctx, appId, jwks)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(refs).To(HaveLen(1))
+}
@@ -0,0 +1,226 @@
+package fakes
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/altinn/altinn-k8s-operator/internal/crypto"
+	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
+	"github.com/altinn/altinn-k8s-operator/internal/operatorcontext"
+	"github.com/go-errors/errors"
+)
+
+var ErrClientNotFound = errors.Errorf("client not found")
+
+// FakeToken is the content of access tokens issued by the fakes, base64 encoded JSON
+type FakeToken struct {
+	Scopes   []string `json:"scopes"`
+	ClientId string   `json:"client_id"`
+}
+
+// ApiClient is an in-memory `maskinporten.ApiClient` backed by a `Db`,
+// for tests that shouldn't depend on the fake HTTP APIs.
+// Failures can be injected through `OnCall`.
+type ApiClient struct {
+	db               *Db
+	lock             sync.Mutex
+	clientNamePrefix string
+
+	// OnCall is invoked with the method name (e.g. "CreateClient") before every call,
+	// a returned error fails the call without touching the db
+	OnCall func(method string) error
+}
+
+var _ maskinporten.ApiClient = (*ApiClient)(nil)
+
+func NewApiClient(db *Db, operatorContext *operatorcontext.Context) *ApiClient {
+	return &ApiClient{
+		db:               db,
+		clientNamePrefix: maskinporten.GetClientName(operatorContext, ""),
+	}
+}
+
+func (c *ApiClient) Db() *Db {
+	return c.db
+}
+
+func (c *ApiClient) call(method string) error {
+	if c.OnCall == nil {
+		return nil
+	}
+	return c.OnCall(method)
+}
+
+func (c *ApiClient) isManaged(client *maskinporten.ClientResponse) bool {
+	return client.ClientName != nil && strings.HasPrefix(*client.ClientName, c.clientNamePrefix)
+}
+
+func (c *ApiClient) GetAllClients(ctx context.Context) ([]maskinporten.ClientResponse, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.call("GetAllClients"); err != nil {
+		return nil, err
+	}
+
+	records := c.db.Query(func(record *ClientRecord) bool { return c.isManaged(record.Client) })
+	result := make([]maskinporten.ClientResponse, len(records))
+	for i, record := range records {
+		result[i] = *record.Client
+	}
+	return result, nil
+}
+
+func (c *ApiClient) GetClient(ctx context.Context, clientId string) (*maskinporten.ClientResponse, *crypto.Jwks, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.call("GetClient"); err != nil {
+		return nil, nil, err
+	}
+
+	record := c.db.Get(clientId)
+	if record == nil {
+		return nil, nil, errors.WrapPrefix(ErrClientNotFound, clientId, 0)
+	}
+	if !c.isManaged(record.Client) {
+		return nil, nil, errors.Errorf("unexpected client name: %v", record.Client.ClientName)
+	}
+
+	client := *record.Client
+	return &client, record.Jwks, nil
+}
+
+func (c *ApiClient) GetUnmanagedClient(ctx context.Context, clientId string) (*maskinporten.ClientResponse, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.call("GetUnmanagedClient"); err != nil {
+		return nil, err
+	}
+
+	record := c.db.Get(clientId)
+	if record == nil {
+		return nil, errors.WrapPrefix(ErrClientNotFound, clientId, 0)
+	}
+
+	client := *record.Client
+	return &client, nil
+}
+
+func (c *ApiClient) CreateClient(
+	ctx context.Context,
+	client *maskinporten.AddClientRequest,
+	jwks *crypto.Jwks,
+) (*maskinporten.ClientResponse, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.call("CreateClient"); err != nil {
+		return nil, err
+	}
+
+	if err := ValidateAddClientRequest(client); err != nil {
+		return nil, err
+	}
+	if err := ValidateJwks(jwks); err != nil {
+		return nil, err
+	}
+
+	record, err := c.db.Insert(client, jwks, "")
+	if err != nil {
+		return nil, err
+	}
+	result := *record.Client
+	return &result, nil
+}
+
+func (c *ApiClient) UpdateClient(
+	ctx context.Context,
+	clientId string,
+	client *maskinporten.UpdateClientRequest,
+) (*maskinporten.ClientResponse, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.call("UpdateClient"); err != nil {
+		return nil, err
+	}
+
+	if err := ValidateUpdateClientRequest(client); err != nil {
+		return nil, err
+	}
+
+	record, err := c.db.Update(clientId, client)
+	if err != nil {
+		return nil, err
+	}
+	result := *record.Client
+	return &result, nil
+}
+
+func (c *ApiClient) CreateClientJwks(ctx context.Context, clientId string, jwks *crypto.Jwks) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.call("CreateClientJwks"); err != nil {
+		return err
+	}
+
+	if err := ValidateJwks(jwks); err != nil {
+		return err
+	}
+	return c.db.UpdateJwks(clientId, jwks)
+}
+
+func (c *ApiClient) DeleteClient(ctx context.Context, clientId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.call("DeleteClient"); err != nil {
+		return err
+	}
+
+	if !c.db.Delete(clientId) {
+		return errors.WrapPrefix(ErrClientNotFound, clientId, 0)
+	}
+	return nil
+}
+
+// GetAccessTokenFor issues a `FakeToken` if the key is registered for the client and the scopes are granted
+func (c *ApiClient) GetAccessTokenFor(
+	ctx context.Context,
+	clientId string,
+	jwk *crypto.Jwk,
+	scopes []string,
+) (*maskinporten.TokenResponse, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.call("GetAccessTokenFor"); err != nil {
+		return nil, err
+	}
+
+	if jwk == nil {
+		return nil, errors.New("can't mint access token without JWK")
+	}
+	record := c.db.Get(clientId)
+	if record == nil {
+		return nil, errors.WrapPrefix(ErrClientNotFound, clientId, 0)
+	}
+	if record.Jwks == nil || !slices.ContainsFunc(record.Jwks.Keys, func(k *crypto.Jwk) bool { return k.KeyID() == jwk.KeyID() }) {
+		return nil, errors.Errorf("key '%s' is not registered for client '%s'", jwk.KeyID(), clientId)
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(record.Client.Scopes, scope) {
+			return nil, errors.Errorf("client doesn't have access to scope: %s", scope)
+		}
+	}
+
+	token, err := json.Marshal(FakeToken{Scopes: scopes, ClientId: clientId})
+	if err != nil {
+		return nil, err
+	}
+	return &maskinporten.TokenResponse{
+		AccessToken: base64.StdEncoding.EncodeToString(token),
+		TokenType:   "Bearer",
+		Scope:       strings.Join(scopes, " "),
+		ExpiresIn:   120,
+	}, nil
+}
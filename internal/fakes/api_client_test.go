@@ -0,0 +1,130 @@
+package fakes
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/altinn/altinn-k8s-operator/internal/crypto"
+	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
+	"github.com/altinn/altinn-k8s-operator/internal/operatorcontext"
+	"github.com/go-errors/errors"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/gomega"
+)
+
+func newTestApiClient(g *WithT) (*ApiClient, *operatorcontext.Context, *crypto.Jwks) {
+	operatorContext := operatorcontext.DiscoverOrDie(context.Background())
+	clock := clockwork.NewFakeClock()
+	service := crypto.NewDefaultService(operatorContext, clock, rand.Reader)
+	jwks, err := service.CreateJwks("app1", clock.Now().Add(30*24*time.Hour))
+	g.Expect(err).NotTo(HaveOccurred())
+	return NewApiClient(NewDb(clock), operatorContext), operatorContext, jwks
+}
+
+func newTestAddClientRequest(operatorContext *operatorcontext.Context, appId string, scopes ...string) *maskinporten.AddClientRequest {
+	clientName := maskinporten.GetClientName(operatorContext, appId)
+	description := "Test client for " + appId
+	integrationType := maskinporten.IntegrationTypeMaskinporten
+	appType := maskinporten.ApplicationTypeWeb
+	tokenEndpointMethod := maskinporten.TokenEndpointAuthMethodPrivateKeyJwt
+	return &maskinporten.AddClientRequest{
+		ClientName:              &clientName,
+		Description:             &description,
+		ClientOrgno:             &operatorContext.ServiceOwnerOrgNo,
+		GrantTypes:              []maskinporten.GrantType{maskinporten.GrantTypeJwtBearer},
+		Scopes:                  scopes,
+		IntegrationType:         &integrationType,
+		ApplicationType:         &appType,
+		TokenEndpointAuthMethod: &tokenEndpointMethod,
+	}
+}
+
+func toUpdateClientRequest(req *maskinporten.AddClientRequest) *maskinporten.UpdateClientRequest {
+	return &maskinporten.UpdateClientRequest{
+		ClientName:              req.ClientName,
+		Description:             req.Description,
+		ClientOrgno:             req.ClientOrgno,
+		GrantTypes:              req.GrantTypes,
+		Scopes:                  req.Scopes,
+		IntegrationType:         req.IntegrationType,
+		ApplicationType:         req.ApplicationType,
+		TokenEndpointAuthMethod: req.TokenEndpointAuthMethod,
+	}
+}
+
+func TestApiClientLifecycle(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	client, operatorContext, jwks := newTestApiClient(g)
+	publicJwks, err := jwks.ToPublic()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	req := newTestAddClientRequest(operatorContext, "app1", "altinn:scope")
+	_, err = client.CreateClient(ctx, req, jwks)
+	g.Expect(err).To(HaveOccurred())
+	created, err := client.CreateClient(ctx, req, publicJwks)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// Clients not owned by this operator are not listed
+	otherName := "other-app"
+	other, err := client.Db().Insert(&maskinporten.AddClientRequest{ClientName: &otherName}, publicJwks, "")
+	g.Expect(err).NotTo(HaveOccurred())
+	all, err := client.GetAllClients(ctx)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(all).To(HaveLen(1))
+	g.Expect(all[0].ClientId).To(Equal(created.ClientId))
+	_, _, err = client.GetClient(ctx, other.ClientId)
+	g.Expect(err).To(HaveOccurred())
+	unmanaged, err := client.GetUnmanagedClient(ctx, other.ClientId)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(*unmanaged.ClientName).To(Equal(otherName))
+
+	updateReq := toUpdateClientRequest(req)
+	updateReq.Scopes = []string{"altinn:scope", "altinn:other"}
+	updated, err := client.UpdateClient(ctx, created.ClientId, updateReq)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(updated.Scopes).To(ConsistOf("altinn:scope", "altinn:other"))
+
+	fetched, fetchedJwks, err := client.GetClient(ctx, created.ClientId)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(fetched.Scopes).To(Equal(updated.Scopes))
+	g.Expect(fetchedJwks).To(Equal(publicJwks))
+
+	token, err := client.GetAccessTokenFor(ctx, created.ClientId, jwks.Keys[0], []string{"altinn:other"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(token.Scope).To(Equal("altinn:other"))
+	_, err = client.GetAccessTokenFor(ctx, created.ClientId, jwks.Keys[0], []string{"altinn:missing"})
+	g.Expect(err).To(HaveOccurred())
+
+	g.Expect(client.DeleteClient(ctx, created.ClientId)).To(Succeed())
+	_, _, err = client.GetClient(ctx, created.ClientId)
+	g.Expect(errors.Is(err, ErrClientNotFound)).To(BeTrue())
+	g.Expect(client.Db().Query(func(*ClientRecord) bool { return true })).To(HaveLen(1))
+}
+
+func TestApiClientInjectsFailures(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	client, operatorContext, jwks := newTestApiClient(g)
+	publicJwks, err := jwks.ToPublic()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	injected := errors.Errorf("injected")
+	var calls []string
+	client.OnCall = func(method string) error {
+		calls = append(calls, method)
+		if method == "CreateClient" {
+			return injected
+		}
+		return nil
+	}
+
+	_, err = client.CreateClient(ctx, newTestAddClientRequest(operatorContext, "app1"), publicJwks)
+	g.Expect(err).To(Equal(injected))
+	all, err := client.GetAllClients(ctx)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(all).To(BeEmpty())
+	g.Expect(calls).To(Equal([]string{"CreateClient", "GetAllClients"}))
+}
@@ -1,12 +1,11 @@
 package fakes
 
 import (
-	"time"
-
 	"github.com/altinn/altinn-k8s-operator/internal/crypto"
 	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
 	"github.com/go-errors/errors"
 	"github.com/google/uuid"
+	"github.com/jonboulle/clockwork"
 )
 
 var InvalidClientName = errors.Errorf("invalid client ID")
@@ -17,6 +16,8 @@ const SupplierOrgNo string = "11111111"
 type Db struct {
 	Clients       []ClientRecord
 	ClientIdIndex map[string]int
+	// Timestamps clients as created and updated
+	clock clockwork.Clock
 }
 
 type ClientRecord struct {
@@ -25,10 +26,11 @@ type ClientRecord struct {
 	Jwks     *crypto.Jwks
 }
 
-func NewDb() *Db {
+func NewDb(clock clockwork.Clock) *Db {
 	return &Db{
 		Clients:       make([]ClientRecord, 0, 64),
 		ClientIdIndex: make(map[string]int, 64),
+		clock:         clock,
 	}
 }
 
@@ -52,7 +54,7 @@ func (d *Db) Insert(
 	}
 
 	supplierOrg := SupplierOrgNo
-	now := time.Now()
+	now := d.clock.Now()
 	active := true
 	jwksUri := ""
 	client := &maskinporten.ClientResponse{
@@ -96,6 +98,23 @@ func (d *Db) Insert(
 	return &record, nil
 }
 
+// Restore inserts a record as is, e.g. from a snapshot or fixture
+func (d *Db) Restore(record ClientRecord) error {
+	if record.Client == nil || record.ClientId == "" {
+		return errors.New(InvalidClientName)
+	}
+	if record.Client.ClientId != record.ClientId {
+		return errors.Errorf("inconsistent client ID in record: %s", record.ClientId)
+	}
+	if _, ok := d.ClientIdIndex[record.ClientId]; ok {
+		return errors.New(ClientAlreadyExists)
+	}
+
+	d.Clients = append(d.Clients, record)
+	d.ClientIdIndex[record.ClientId] = len(d.Clients) - 1
+	return nil
+}
+
 func (d *Db) UpdateJwks(clientId string, jwks *crypto.Jwks) error {
 	i, ok := d.ClientIdIndex[clientId]
 	if !ok {
@@ -114,11 +133,56 @@ func (d *Db) Delete(clientId string) bool {
 
 	delete(d.ClientIdIndex, clientId)
 
-	d.Clients[i] = d.Clients[len(d.Clients)-1]
-	d.Clients = d.Clients[:len(d.Clients)-1]
+	last := len(d.Clients) - 1
+	if i != last {
+		d.Clients[i] = d.Clients[last]
+		d.ClientIdIndex[d.Clients[i].ClientId] = i
+	}
+	d.Clients = d.Clients[:last]
 	return true
 }
 
+// Update replaces the client definition, keeping the JWKS and creation timestamp
+func (d *Db) Update(clientId string, req *maskinporten.UpdateClientRequest) (*ClientRecord, error) {
+	i, ok := d.ClientIdIndex[clientId]
+	if !ok {
+		return nil, errors.New("client not found")
+	}
+	if req.ClientName == nil || *req.ClientName == "" {
+		return nil, errors.New(InvalidClientName)
+	}
+
+	now := d.clock.Now()
+	client := *d.Clients[i].Client
+	client.ClientName = req.ClientName
+	client.ClientOrgno = req.ClientOrgno
+	client.Description = req.Description
+	client.ApplicationType = req.ApplicationType
+	client.IntegrationType = req.IntegrationType
+	client.Scopes = req.Scopes
+	client.GrantTypes = req.GrantTypes
+	client.TokenEndpointAuthMethod = req.TokenEndpointAuthMethod
+	client.RefreshTokenLifetime = req.RefreshTokenLifetime
+	client.RefreshTokenUsage = req.RefreshTokenUsage
+	client.AccessTokenLifetime = req.AccessTokenLifetime
+	client.AuthorizationLifetime = req.AuthorizationLifetime
+	client.LogoUri = req.LogoUri
+	client.RedirectUris = req.RedirectUris
+	client.PostLogoutRedirectUris = req.PostLogoutRedirectUris
+	client.FrontchannelLogoutSessionRequired = req.FrontchannelLogoutSessionRequired
+	client.FrontchannelLogoutUri = req.FrontchannelLogoutUri
+	client.SsoDisabled = req.SsoDisabled
+	client.CodeChallengeMethod = req.CodeChallengeMethod
+	if req.Active != nil {
+		client.Active = req.Active
+	}
+	client.LastUpdated = &now
+
+	d.Clients[i].Client = &client
+	record := d.Clients[i]
+	return &record, nil
+}
+
 func (d *Db) Get(clientId string) *ClientRecord {
 	i, ok := d.ClientIdIndex[clientId]
 	if !ok {
@@ -0,0 +1,60 @@
+package fakes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/altinn/altinn-k8s-operator/internal/operatorcontext"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/gomega"
+)
+
+func TestDbDeleteKeepsIndexConsistent(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext := operatorcontext.DiscoverOrDie(context.Background())
+	db := NewDb(clockwork.NewFakeClock())
+	ids := make([]string, 0, 4)
+	for _, appId := range []string{"app1", "app2", "app3", "app4"} {
+		record, err := db.Insert(newTestAddClientRequest(operatorContext, appId), nil, "")
+		g.Expect(err).NotTo(HaveOccurred())
+		ids = append(ids, record.ClientId)
+	}
+
+	// Deleting from the middle moves the last record into the gap
+	g.Expect(db.Delete(ids[1])).To(BeTrue())
+	g.Expect(db.Delete(ids[1])).To(BeFalse())
+	g.Expect(db.Get(ids[1])).To(BeNil())
+	for _, id := range []string{ids[0], ids[2], ids[3]} {
+		g.Expect(db.Get(id).ClientId).To(Equal(id))
+	}
+
+	// Deleting the last record leaves the others in place
+	g.Expect(db.Delete(ids[2])).To(BeTrue())
+	g.Expect(db.Delete(ids[0])).To(BeTrue())
+	g.Expect(db.Get(ids[3]).ClientId).To(Equal(ids[3]))
+	g.Expect(db.Clients).To(HaveLen(1))
+	g.Expect(db.ClientIdIndex).To(Equal(map[string]int{ids[3]: 0}))
+}
+
+func TestDbTimestampsFollowClock(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext := operatorcontext.DiscoverOrDie(context.Background())
+	clock := clockwork.NewFakeClockAt(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	db := NewDb(clock)
+	req := newTestAddClientRequest(operatorContext, "app1")
+
+	record, err := db.Insert(req, nil, "")
+	g.Expect(err).NotTo(HaveOccurred())
+	created := clock.Now()
+	g.Expect(*record.Client.Created).To(Equal(created))
+	g.Expect(*record.Client.LastUpdated).To(Equal(created))
+
+	clock.Advance(time.Hour)
+	updated, err := db.Update(record.ClientId, toUpdateClientRequest(req))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(*updated.Client.Created).To(Equal(created))
+	g.Expect(*updated.Client.LastUpdated).To(Equal(clock.Now()))
+}
@@ -0,0 +1,299 @@
+package fakes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+// Path of the fault injection control endpoint on the fakes servers
+const FaultsPath = "/faults"
+
+const RunIdHeader = "X-Altinn-Operator-RunId"
+
+// Fault describes a failure to inject for requests matching `Method` and `Route`.
+// `Route` uses the same syntax as `http.ServeMux` patterns, e.g. '/api/v1/altinn/admin/clients/{clientId}'.
+// Empty `Method` matches any method, and empty `RunId` matches requests from any operator run.
+type Fault struct {
+	RunId  string `json:"runId,omitempty"`
+	Method string `json:"method,omitempty"`
+	Route  string `json:"route"`
+
+	// StatusCode is written instead of calling the handler, 0 means the handler is called
+	StatusCode int `json:"statusCode,omitempty"`
+	// Latency delays the request before the fault is applied
+	Latency time.Duration `json:"latency,omitempty"`
+	// DropConnection closes the connection without writing a response
+	DropConnection bool `json:"dropConnection,omitempty"`
+
+	// Nth only applies the fault on the Nth matching call (1-based), 0 means every call
+	Nth int `json:"nth,omitempty"`
+	// Times limits how many times the fault is applied, 0 means no limit
+	Times int `json:"times,omitempty"`
+}
+
+type faultEntry struct {
+	Fault
+	calls   int
+	applied int
+}
+
+// Faults holds the faults injected through the control endpoint and applies them in `Middleware`
+type Faults struct {
+	entries []*faultEntry
+	lock    sync.Mutex
+}
+
+func NewFaults() *Faults {
+	return &Faults{}
+}
+
+func (f *Faults) Add(fault Fault) error {
+	if !strings.HasPrefix(fault.Route, "/") {
+		return errors.Errorf("invalid fault route: '%s'", fault.Route)
+	}
+	if fault.StatusCode == 0 && fault.Latency == 0 && !fault.DropConnection {
+		return errors.New("fault must have a status code, latency or dropped connection")
+	}
+	if fault.StatusCode != 0 && (fault.StatusCode < 100 || fault.StatusCode > 599) {
+		return errors.Errorf("invalid fault status code: %d", fault.StatusCode)
+	}
+	if fault.Nth < 0 || fault.Times < 0 {
+		return errors.New("fault nth and times can't be negative")
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.entries = append(f.entries, &faultEntry{Fault: fault})
+	return nil
+}
+
+// Clear removes the faults for the run, or every fault if `runId` is empty
+func (f *Faults) Clear(runId string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if runId == "" {
+		f.entries = nil
+		return
+	}
+	entries := f.entries[:0]
+	for _, entry := range f.entries {
+		if entry.RunId != runId {
+			entries = append(entries, entry)
+		}
+	}
+	f.entries = entries
+}
+
+func (f *Faults) List(runId string) []Fault {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	result := make([]Fault, 0, len(f.entries))
+	for _, entry := range f.entries {
+		if runId == "" || entry.RunId == runId {
+			result = append(result, entry.Fault)
+		}
+	}
+	return result
+}
+
+// match counts the call for every matching fault, and returns the first fault that should be applied
+func (f *Faults) match(runId string, method string, path string) *Fault {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var result *Fault
+	for _, entry := range f.entries {
+		if entry.RunId != "" && entry.RunId != runId {
+			continue
+		}
+		if entry.Method != "" && entry.Method != method {
+			continue
+		}
+		if !matchRoute(entry.Route, path) {
+			continue
+		}
+		entry.calls++
+		if result != nil {
+			continue
+		}
+		if entry.Nth != 0 && entry.calls != entry.Nth {
+			continue
+		}
+		if entry.Times != 0 && entry.applied >= entry.Times {
+			continue
+		}
+		entry.applied++
+		fault := entry.Fault
+		result = &fault
+	}
+	return result
+}
+
+func matchRoute(route string, path string) bool {
+	routeSegments := strings.Split(strings.Trim(route, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(routeSegments) != len(pathSegments) {
+		return false
+	}
+	for i, segment := range routeSegments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			continue
+		}
+		if segment != pathSegments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware applies injected faults before calling `next`.
+// The control endpoint and health checks are never faulted.
+func (f *Faults) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == FaultsPath || r.URL.Path == "/health" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		fault := f.match(r.Header.Get(RunIdHeader), r.Method, r.URL.Path)
+		if fault == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		log.Printf("injecting fault: method=%s path=%s fault=%+v\n", r.Method, r.URL.Path, *fault)
+		if fault.Latency > 0 {
+			select {
+			case <-time.After(fault.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if fault.DropConnection {
+			hijacker, ok := w.(http.Hijacker)
+			if !ok {
+				w.WriteHeader(500)
+				log.Printf("couldn't drop connection: response writer doesn't support hijacking\n")
+				return
+			}
+			conn, _, err := hijacker.Hijack()
+			if err != nil {
+				log.Printf("couldn't drop connection: %v\n", errors.Wrap(err, 0))
+				return
+			}
+			_ = conn.Close()
+			return
+		}
+		if fault.StatusCode != 0 {
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(fault.StatusCode)
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"error":             "injected_fault",
+				"error_description": fmt.Sprintf("fault injected for %s %s", r.Method, fault.Route),
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ServeHTTP handles the control endpoint:
+// * POST adds a fault, using the run ID header if the fault doesn't specify one
+// * GET lists faults, filtered by the run ID header if present
+// * DELETE clears faults for the run ID header, or all faults if absent
+func (f *Faults) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	runId := r.Header.Get(RunIdHeader)
+	switch r.Method {
+	case http.MethodPost:
+		var fault Fault
+		if err := json.NewDecoder(r.Body).Decode(&fault); err != nil {
+			w.WriteHeader(400)
+			log.Printf("couldn't read request: %v\n", errors.Wrap(err, 0))
+			return
+		}
+		if fault.RunId == "" {
+			fault.RunId = runId
+		}
+		if err := f.Add(fault); err != nil {
+			w.WriteHeader(400)
+			log.Printf("couldn't add fault: %v\n", err)
+			return
+		}
+		w.WriteHeader(201)
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(f.List(runId)); err != nil {
+			w.WriteHeader(500)
+			log.Printf("couldn't write response: %v\n", errors.Wrap(err, 0))
+		}
+	case http.MethodDelete:
+		f.Clear(runId)
+		w.WriteHeader(204)
+	default:
+		w.WriteHeader(404)
+	}
+}
+
+// FaultClient configures faults on a running fakes server from tests
+type FaultClient struct {
+	BaseUrl    string
+	RunId      string
+	HttpClient *http.Client
+}
+
+func NewFaultClient(baseUrl string, runId string) *FaultClient {
+	return &FaultClient{
+		BaseUrl:    strings.TrimSuffix(baseUrl, "/"),
+		RunId:      runId,
+		HttpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Inject adds a fault scoped to the client's run ID
+func (c *FaultClient) Inject(ctx context.Context, fault Fault) error {
+	body, err := json.Marshal(fault)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	return c.do(ctx, http.MethodPost, bytes.NewReader(body), 201)
+}
+
+// FailNth makes the Nth matching call fail with the given status code
+func (c *FaultClient) FailNth(ctx context.Context, method string, route string, nth int, statusCode int) error {
+	return c.Inject(ctx, Fault{Method: method, Route: route, Nth: nth, StatusCode: statusCode})
+}
+
+// Clear removes every fault of the client's run ID
+func (c *FaultClient) Clear(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, nil, 204)
+}
+
+func (c *FaultClient) do(ctx context.Context, method string, body io.Reader, expectedStatus int) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseUrl+FaultsPath, body)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	if c.RunId != "" {
+		req.Header.Add(RunIdHeader, c.RunId)
+	}
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	resp, err := c.HttpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != expectedStatus {
+		return errors.Errorf("unexpected status from fakes control endpoint: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package fakes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func newFaultsTestServer() (*httptest.Server, *Faults) {
+	faults := NewFaults()
+	mux := http.NewServeMux()
+	mux.Handle(FaultsPath, faults)
+	mux.HandleFunc("/clients/{clientId}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	})
+	return httptest.NewServer(faults.Middleware(mux)), faults
+}
+
+func getStatus(g *WithT, url string, runId string) int {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	req.Header.Add(RunIdHeader, runId)
+	resp, err := http.DefaultClient.Do(req)
+	g.Expect(err).NotTo(HaveOccurred())
+	defer resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestFaultsFailNthCallForRun(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	server, faults := newFaultsTestServer()
+	defer server.Close()
+
+	client := NewFaultClient(server.URL, "run1")
+	g.Expect(client.FailNth(ctx, http.MethodGet, "/clients/{clientId}", 2, 503)).To(Succeed())
+	g.Expect(faults.List("run1")).To(HaveLen(1))
+	g.Expect(faults.List("run1")[0].RunId).To(Equal("run1"))
+
+	url := server.URL + "/clients/abc"
+	g.Expect(getStatus(g, url, "run2")).To(Equal(200))
+	g.Expect(getStatus(g, url, "run1")).To(Equal(200))
+	g.Expect(getStatus(g, url, "run1")).To(Equal(503))
+	g.Expect(getStatus(g, url, "run1")).To(Equal(200))
+	g.Expect(getStatus(g, server.URL+"/clients/abc/jwks", "run1")).To(Equal(404))
+
+	g.Expect(client.Clear(ctx)).To(Succeed())
+	g.Expect(faults.List("")).To(BeEmpty())
+}
+
+func TestFaultsLimitTimesAndLatency(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	server, _ := newFaultsTestServer()
+	defer server.Close()
+
+	client := NewFaultClient(server.URL, "run1")
+	g.Expect(client.Inject(ctx, Fault{Route: "/clients/{clientId}", StatusCode: 500, Times: 2})).To(Succeed())
+	url := server.URL + "/clients/abc"
+	g.Expect(getStatus(g, url, "run1")).To(Equal(500))
+	g.Expect(getStatus(g, url, "run1")).To(Equal(500))
+	g.Expect(getStatus(g, url, "run1")).To(Equal(200))
+	g.Expect(client.Clear(ctx)).To(Succeed())
+
+	g.Expect(client.Inject(ctx, Fault{Route: "/clients/{clientId}", Latency: 50 * time.Millisecond})).To(Succeed())
+	start := time.Now()
+	g.Expect(getStatus(g, url, "run1")).To(Equal(200))
+	g.Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+}
+
+func TestFaultsDropConnection(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	server, _ := newFaultsTestServer()
+	defer server.Close()
+
+	client := NewFaultClient(server.URL, "")
+	g.Expect(client.Inject(ctx, Fault{Route: "/clients/{clientId}", DropConnection: true})).To(Succeed())
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/clients/abc", nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = http.DefaultClient.Do(req)
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestFaultsRejectInvalid(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	server, _ := newFaultsTestServer()
+	defer server.Close()
+
+	client := NewFaultClient(server.URL, "run1")
+	g.Expect(client.Inject(ctx, Fault{Route: "clients"})).NotTo(Succeed())
+	g.Expect(client.Inject(ctx, Fault{Route: "/clients"})).NotTo(Succeed())
+	g.Expect(client.Inject(ctx, Fault{Route: "/clients", StatusCode: 42})).NotTo(Succeed())
+}
@@ -0,0 +1,244 @@
+// Package keyvault contains a fake of the Azure Key Vault secrets API, for use in tests.
+// It implements just enough of the API for `azsecrets.Client` to work against it
+// (challenge based authentication, set/get/delete secrets with versions).
+package keyvault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/google/uuid"
+)
+
+const fakeToken string = "fake-keyvault-token"
+
+type secretVersion struct {
+	version     string
+	value       string
+	contentType *string
+	tags        map[string]*string
+}
+
+type Server struct {
+	server  *httptest.Server
+	lock    sync.Mutex
+	secrets map[string][]secretVersion
+	// Status codes returned for secrets requests by HTTP method, see `FailRequests`
+	failures map[string]int
+}
+
+// NewServer starts a TLS server faking the Key Vault secrets API.
+// The Azure SDK refuses to send bearer tokens over plain HTTP, so TLS is required.
+func NewServer() *Server {
+	s := &Server{
+		secrets:  make(map[string][]secretVersion),
+		failures: make(map[string]int),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/secrets/{name}", s.handleSecret)
+	// The SDK requests the latest version as `/secrets/{name}/` (empty version)
+	mux.HandleFunc("/secrets/{name}/{version...}", s.handleSecret)
+	s.server = httptest.NewTLSServer(s.authenticate(mux))
+	return s
+}
+
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// ClientOptions returns options for `azsecrets.NewClient` that trust the servers TLS certificate
+func (s *Server) ClientOptions() *azsecrets.ClientOptions {
+	return &azsecrets.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Transport: s.server.Client(),
+		},
+		DisableChallengeResourceVerification: true,
+	}
+}
+
+// Credential returns a credential producing tokens accepted by the server
+func (s *Server) Credential() azcore.TokenCredential {
+	return credential{}
+}
+
+// NewClient builds an `azsecrets.Client` configured to talk to this server
+func (s *Server) NewClient() (*azsecrets.Client, error) {
+	return azsecrets.NewClient(s.URL(), s.Credential(), s.ClientOptions())
+}
+
+// Set stores a new version of a secret, returning the version
+func (s *Server) Set(name string, value string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.set(name, value, nil, nil).version
+}
+
+// Get returns the latest version of a secret
+func (s *Server) Get(name string) (string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	secret := s.get(name, "")
+	if secret == nil {
+		return "", false
+	}
+	return secret.value, true
+}
+
+// FailRequests makes following secrets requests with the HTTP `method` fail with `status`,
+// 0 restores normal operation
+func (s *Server) FailRequests(method string, status int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.failures[method] = status
+}
+
+// Names returns the names of all secrets currently stored, sorted
+func (s *Server) Names() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	names := make([]string, 0, len(s.secrets))
+	for name := range s.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Server) set(name string, value string, contentType *string, tags map[string]*string) *secretVersion {
+	version := strings.ReplaceAll(uuid.NewString(), "-", "")
+	secret := secretVersion{
+		version:     version,
+		value:       value,
+		contentType: contentType,
+		tags:        tags,
+	}
+	s.secrets[name] = append(s.secrets[name], secret)
+	return &s.secrets[name][len(s.secrets[name])-1]
+}
+
+func (s *Server) get(name string, version string) *secretVersion {
+	versions, ok := s.secrets[name]
+	if !ok || len(versions) == 0 {
+		return nil
+	}
+	if version == "" {
+		return &versions[len(versions)-1]
+	}
+	for i := range versions {
+		if versions[i].version == version {
+			return &versions[i]
+		}
+	}
+	return nil
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if auth == "" {
+			// Challenge, the client will fetch a token for the given resource and retry
+			w.Header().Set(
+				"WWW-Authenticate",
+				`Bearer authorization="https://login.microsoftonline.com/00000000-0000-0000-0000-000000000000", resource="https://vault.azure.net"`,
+			)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if auth != "Bearer "+fakeToken {
+			writeError(w, http.StatusUnauthorized, "Unauthorized", "invalid token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleSecret(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	version := r.PathValue("version")
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if status := s.failures[r.Method]; status != 0 {
+		writeError(w, status, "InjectedFailure", fmt.Sprintf("injected failure for %s", name))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var params azsecrets.SetSecretParameters
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil || params.Value == nil {
+			writeError(w, http.StatusBadRequest, "BadParameter", "invalid secret parameters")
+			return
+		}
+		secret := s.set(name, *params.Value, params.ContentType, params.Tags)
+		s.writeSecret(w, name, secret)
+	case http.MethodGet:
+		secret := s.get(name, version)
+		if secret == nil {
+			writeError(w, http.StatusNotFound, "SecretNotFound", fmt.Sprintf("secret not found: %s", name))
+			return
+		}
+		s.writeSecret(w, name, secret)
+	case http.MethodDelete:
+		secret := s.get(name, "")
+		if secret == nil {
+			writeError(w, http.StatusNotFound, "SecretNotFound", fmt.Sprintf("secret not found: %s", name))
+			return
+		}
+		delete(s.secrets, name)
+		id := azsecrets.ID(fmt.Sprintf("%s/secrets/%s/%s", s.server.URL, name, secret.version))
+		writeJson(w, http.StatusOK, azsecrets.DeletedSecret{ID: &id})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+func (s *Server) writeSecret(w http.ResponseWriter, name string, secret *secretVersion) {
+	id := azsecrets.ID(fmt.Sprintf("%s/secrets/%s/%s", s.server.URL, name, secret.version))
+	value := secret.value
+	enabled := true
+	writeJson(w, http.StatusOK, azsecrets.Secret{
+		ID:          &id,
+		Value:       &value,
+		ContentType: secret.contentType,
+		Tags:        secret.tags,
+		Attributes:  &azsecrets.SecretAttributes{Enabled: &enabled},
+	})
+}
+
+func writeJson(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	writeJson(w, status, map[string]any{
+		"error": map[string]string{
+			"code":    code,
+			"message": message,
+		},
+	})
+}
+
+type credential struct{}
+
+func (credential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: fakeToken, ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
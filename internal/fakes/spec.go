@@ -0,0 +1,287 @@
+package fakes
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/altinn/altinn-k8s-operator/internal/config"
+	"github.com/go-errors/errors"
+)
+
+// Spec is the OpenAPI spec of the Maskinporten self-service API in 'schemas/spec.json'.
+// It supports the subset of JSON schema used by the spec, so that tests can check
+// the hand-written API types and the fakes against it.
+type Spec struct {
+	Components struct {
+		Schemas map[string]*Schema `json:"schemas"`
+	} `json:"components"`
+}
+
+type Schema struct {
+	Ref         string             `json:"$ref,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	UniqueItems bool               `json:"uniqueItems,omitempty"`
+}
+
+func DefaultSpecPath() string {
+	return filepath.Join(config.TryFindProjectRoot(), "schemas", "spec.json")
+}
+
+func LoadSpec(path string) (*Spec, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+	var spec Spec
+	if err := json.Unmarshal(content, &spec); err != nil {
+		return nil, errors.WrapPrefix(err, "couldn't parse spec "+path, 0)
+	}
+	return &spec, nil
+}
+
+func (s *Spec) Schema(name string) (*Schema, error) {
+	schema, ok := s.Components.Schemas[name]
+	if !ok {
+		return nil, errors.Errorf("schema not found in spec: %s", name)
+	}
+	return schema, nil
+}
+
+func (s *Spec) resolve(schema *Schema) (*Schema, error) {
+	if schema.Ref == "" {
+		return schema, nil
+	}
+	return s.Schema(strings.TrimPrefix(schema.Ref, "#/components/schemas/"))
+}
+
+// Validate checks the JSON encoding of `value` against the named schema
+func (s *Spec) Validate(schemaName string, value any) error {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	return s.ValidateJSON(schemaName, content)
+}
+
+// ValidateJSON checks that JSON content only contains properties of the named schema,
+// with the types, formats and enum values declared in the spec
+func (s *Spec) ValidateJSON(schemaName string, content []byte) error {
+	schema, err := s.Schema(schemaName)
+	if err != nil {
+		return err
+	}
+	var value any
+	if err := json.Unmarshal(content, &value); err != nil {
+		return errors.Wrap(err, 0)
+	}
+	var violations []string
+	s.validate(schema, value, schemaName, &violations)
+	if len(violations) > 0 {
+		return errors.Errorf("'%s' doesn't match spec: %s", schemaName, strings.Join(violations, ", "))
+	}
+	return nil
+}
+
+func (s *Spec) validate(schema *Schema, value any, path string, violations *[]string) {
+	schema, err := s.resolve(schema)
+	if err != nil {
+		*violations = append(*violations, fmt.Sprintf("%s: %v", path, err))
+		return
+	}
+	if value == nil {
+		return
+	}
+
+	invalid := func(format string, args ...any) {
+		*violations = append(*violations, path+": "+fmt.Sprintf(format, args...))
+	}
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			invalid("expected object, got %T", value)
+			return
+		}
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			property, ok := schema.Properties[key]
+			if !ok {
+				invalid("unknown property '%s'", key)
+				continue
+			}
+			s.validate(property, object[key], path+"."+key, violations)
+		}
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			invalid("expected array, got %T", value)
+			return
+		}
+		for i, item := range array {
+			if schema.UniqueItems && slices.ContainsFunc(array[:i], func(other any) bool {
+				return reflect.DeepEqual(other, item)
+			}) {
+				invalid("duplicate item at index %d", i)
+			}
+			if schema.Items != nil {
+				s.validate(schema.Items, item, fmt.Sprintf("%s[%d]", path, i), violations)
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			invalid("expected string, got %T", value)
+			return
+		}
+		if len(schema.Enum) > 0 && !slices.Contains(schema.Enum, str) {
+			invalid("'%s' is not one of %v", str, schema.Enum)
+		}
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				invalid("invalid date-time '%s'", str)
+			}
+		}
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != float64(int64(number)) {
+			invalid("expected integer, got %v", value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			invalid("expected boolean, got %T", value)
+		}
+	}
+}
+
+// CheckType compares the JSON fields of a Go struct with the properties of the named schema,
+// returning every mismatch in field names, types and enum values.
+// `enums` lists the known values of the Go string types used as enums.
+func (s *Spec) CheckType(schemaName string, t reflect.Type, enums map[reflect.Type][]string) []string {
+	schema, err := s.Schema(schemaName)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var mismatches []string
+	s.checkType(schema, t, schemaName, enums, &mismatches)
+	return mismatches
+}
+
+func (s *Spec) checkType(
+	schema *Schema,
+	t reflect.Type,
+	path string,
+	enums map[reflect.Type][]string,
+	mismatches *[]string,
+) {
+	schema, err := s.resolve(schema)
+	if err != nil {
+		*mismatches = append(*mismatches, fmt.Sprintf("%s: %v", path, err))
+		return
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	mismatch := func(format string, args ...any) {
+		*mismatches = append(*mismatches, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	// Types with custom encoding can't be checked structurally, their values are covered by `Validate`
+	if reflect.PointerTo(t).Implements(reflect.TypeOf((*json.Marshaler)(nil)).Elem()) {
+		return
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		if schema.Type != "string" || schema.Format != "date-time" {
+			mismatch("expected date-time in spec, got %s %s", schema.Type, schema.Format)
+		}
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if schema.Type != "object" {
+			mismatch("expected object in spec, got %s", schema.Type)
+			return
+		}
+		fields := make(map[string]struct{})
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			fields[name] = struct{}{}
+			property, ok := schema.Properties[name]
+			if !ok {
+				mismatch("field '%s' is not in spec", name)
+				continue
+			}
+			s.checkType(property, field.Type, path+"."+name, enums, mismatches)
+		}
+		names := make([]string, 0, len(schema.Properties))
+		for name := range schema.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if _, ok := fields[name]; !ok {
+				mismatch("property '%s' is missing in Go type %s", name, t.Name())
+			}
+		}
+	case reflect.Slice:
+		if schema.Type != "array" {
+			mismatch("expected array in spec, got %s", schema.Type)
+			return
+		}
+		if schema.Items != nil {
+			s.checkType(schema.Items, t.Elem(), path+"[]", enums, mismatches)
+		}
+	case reflect.String:
+		if schema.Type != "string" {
+			mismatch("expected string in spec, got %s", schema.Type)
+			return
+		}
+		values, isEnum := enums[t]
+		if len(schema.Enum) > 0 && !isEnum {
+			mismatch("spec has enum %v, but Go type %s has no known values", schema.Enum, t.Name())
+		} else if isEnum && !sameElements(values, schema.Enum) {
+			mismatch("Go type %s has values %v, spec has %v", t.Name(), values, schema.Enum)
+		}
+	case reflect.Bool:
+		if schema.Type != "boolean" {
+			mismatch("expected boolean in spec, got %s", schema.Type)
+		}
+	case reflect.Int32, reflect.Int64:
+		expectedFormat := "int64"
+		if t.Kind() == reflect.Int32 {
+			expectedFormat = "int32"
+		}
+		if schema.Type != "integer" || schema.Format != expectedFormat {
+			mismatch("expected integer %s in spec, got %s %s", expectedFormat, schema.Type, schema.Format)
+		}
+	default:
+		mismatch("unsupported Go type %s", t)
+	}
+}
+
+func sameElements(a []string, b []string) bool {
+	a = slices.Clone(a)
+	b = slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
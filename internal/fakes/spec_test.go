@@ -0,0 +1,128 @@
+package fakes
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
+	. "github.com/onsi/gomega"
+)
+
+func loadTestSpec(g *WithT) *Spec {
+	spec, err := LoadSpec(DefaultSpecPath())
+	g.Expect(err).NotTo(HaveOccurred())
+	return spec
+}
+
+var specEnums = map[reflect.Type][]string{
+	reflect.TypeOf(maskinporten.ApplicationTypeWeb): {
+		string(maskinporten.ApplicationTypeWeb),
+		string(maskinporten.ApplicationTypeBrowser),
+		string(maskinporten.ApplicationTypeNative),
+	},
+	reflect.TypeOf(maskinporten.IntegrationTypeMaskinporten): {
+		string(maskinporten.IntegrationTypeAnsattporten),
+		string(maskinporten.IntegrationTypeApiKlient),
+		string(maskinporten.IntegrationTypeEformidling),
+		string(maskinporten.IntegrationTypeIdporten),
+		string(maskinporten.IntegrationTypeIdportenSaml2),
+		string(maskinporten.IntegrationTypeKrr),
+		string(maskinporten.IntegrationTypeMaskinporten),
+	},
+	reflect.TypeOf(maskinporten.GrantTypeJwtBearer): {
+		string(maskinporten.GrantTypeAuthorizationCode),
+		string(maskinporten.GrantTypeImplicit),
+		string(maskinporten.GrantTypeRefreshToken),
+		string(maskinporten.GrantTypeJwtBearer),
+	},
+	reflect.TypeOf(maskinporten.TokenEndpointAuthMethodPrivateKeyJwt): {
+		string(maskinporten.TokenEndpointAuthMethodClientSecretPost),
+		string(maskinporten.TokenEndpointAuthMethodClientSecretBasic),
+		string(maskinporten.TokenEndpointAuthMethodPrivateKeyJwt),
+		string(maskinporten.TokenEndpointAuthMethodNone),
+	},
+	reflect.TypeOf(maskinporten.RefreshTokenUsageReuse): {
+		string(maskinporten.RefreshTokenUsageReuse),
+		string(maskinporten.RefreshTokenUsageOnetime),
+	},
+	reflect.TypeOf(maskinporten.CodeChallengeMethodS256): {
+		string(maskinporten.CodeChallengeMethodNone),
+		string(maskinporten.CodeChallengeMethodS256),
+	},
+}
+
+func TestApiTypesMatchSpec(t *testing.T) {
+	g := NewWithT(t)
+	spec := loadTestSpec(g)
+
+	types := map[string]any{
+		"AddClientRequest":    maskinporten.AddClientRequest{},
+		"UpdateClientRequest": maskinporten.UpdateClientRequest{},
+		"ClientResponse":      maskinporten.ClientResponse{},
+		"ClientOnBehalfOf":    maskinporten.ClientOnBehalfOf{},
+		"ApiErrorResponse":    maskinporten.ApiErrorResponse{},
+		"ApiError":            maskinporten.ApiError{},
+	}
+	for schemaName, value := range types {
+		g.Expect(spec.CheckType(schemaName, reflect.TypeOf(value), specEnums)).To(BeEmpty(), schemaName)
+	}
+}
+
+func TestSpecDetectsDrift(t *testing.T) {
+	g := NewWithT(t)
+	spec := loadTestSpec(g)
+
+	type driftedApiError struct {
+		ErrorMessage *int    `json:"errorMessage,omitempty"`
+		Unknown      *string `json:"unknown,omitempty"`
+	}
+	g.Expect(spec.CheckType("ApiError", reflect.TypeOf(driftedApiError{}), nil)).To(ConsistOf(
+		"ApiError.errorMessage: unsupported Go type int",
+		"ApiError: field 'unknown' is not in spec",
+		"ApiError: property 'fieldIdentifier' is missing in Go type driftedApiError",
+		"ApiError: property 'isFieldError' is missing in Go type driftedApiError",
+		"ApiError: property 'objectName' is missing in Go type driftedApiError",
+	))
+
+	unknownType := maskinporten.IntegrationType("unknown")
+	err := spec.Validate("AddClientRequest", &maskinporten.AddClientRequest{
+		IntegrationType: &unknownType,
+		Scopes:          []string{"a:b", "a:b"},
+	})
+	g.Expect(err).To(MatchError(ContainSubstring("AddClientRequest.integration_type: 'unknown' is not one of")))
+	g.Expect(err).To(MatchError(ContainSubstring("AddClientRequest.scopes: duplicate item at index 1")))
+	g.Expect(spec.ValidateJSON("ApiError", []byte(`{"isFieldError":"yes"}`))).NotTo(Succeed())
+}
+
+func TestFakesResponsesMatchSpec(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	spec := loadTestSpec(g)
+	client, operatorContext, jwks := newTestApiClient(g)
+	publicJwks, err := jwks.ToPublic()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	req := newTestAddClientRequest(operatorContext, "app1", "altinn:scope")
+	g.Expect(spec.Validate("AddClientRequest", req)).To(Succeed())
+	g.Expect(spec.Validate("UpdateClientRequest", toUpdateClientRequest(req))).To(Succeed())
+	g.Expect(spec.Validate("OidcJwksRequestResponse", publicJwks)).To(Succeed())
+
+	created, err := client.CreateClient(ctx, req, publicJwks)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(spec.Validate("ClientResponse", created)).To(Succeed())
+
+	updated, err := client.UpdateClient(ctx, created.ClientId, toUpdateClientRequest(req))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(spec.Validate("ClientResponse", updated)).To(Succeed())
+
+	all, err := client.GetAllClients(ctx)
+	g.Expect(err).NotTo(HaveOccurred())
+	for _, c := range all {
+		g.Expect(spec.Validate("ClientResponse", c)).To(Succeed())
+	}
+
+	_, err = client.CreateClient(ctx, newTestAddClientRequest(operatorContext, "app2", "idporten:dcr.altinn"), publicJwks)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(spec.Validate("ApiErrorResponse", NewApiErrorResponse(400, "invalid client", err))).To(Succeed())
+}
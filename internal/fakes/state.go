@@ -4,34 +4,152 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 	"sync"
 
 	"github.com/altinn/altinn-k8s-operator/internal/config"
 	"github.com/altinn/altinn-k8s-operator/internal/crypto"
 	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
+	"github.com/go-errors/errors"
+	"github.com/jonboulle/clockwork"
 )
 
 type State struct {
-	Db   map[string]*Db
-	Cfg  *config.Config
-	lock sync.Mutex
+	Db     map[string]*Db
+	Cfg    *config.Config
+	Faults *Faults
+	// SnapshotPath is the JSON file the state is persisted to, persistence is disabled if empty
+	SnapshotPath string
+	clock        clockwork.Clock
+	lock         sync.Mutex
+}
+
+// DumpFilter narrows down `Dump`, empty fields match everything
+type DumpFilter struct {
+	RunId            string
+	ClientNamePrefix string
 }
 
 func (s *State) GetAll() map[string][]ClientRecord {
+	return s.Dump(DumpFilter{})
+}
+
+func (s *State) Dump(filter DumpFilter) map[string][]ClientRecord {
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	return s.dump(filter)
+}
+
+func (s *State) dump(filter DumpFilter) map[string][]ClientRecord {
 	res := make(map[string][]ClientRecord)
 	for runId, db := range s.Db {
+		if filter.RunId != "" && filter.RunId != runId {
+			continue
+		}
 		records := db.Query(func(ocr *ClientRecord) bool {
-			return true
+			if filter.ClientNamePrefix == "" {
+				return true
+			}
+			return ocr.Client.ClientName != nil && strings.HasPrefix(*ocr.Client.ClientName, filter.ClientNamePrefix)
 		})
 		res[runId] = records
 	}
 	return res
 }
 
+// Reset replaces the DB of the run with a fresh one, only containing the supplier client
+func (s *State) Reset(runId string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Db[runId] = s.initDb()
+}
+
+// Seed resets the DB of the run, and restores the given records on top of the supplier client
+func (s *State) Seed(runId string, records []ClientRecord) error {
+	db := s.initDb()
+	for _, record := range records {
+		if err := db.Restore(record); err != nil {
+			return errors.WrapPrefix(err, "couldn't seed client "+record.ClientId, 0)
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Db[runId] = db
+	return nil
+}
+
+// LoadFixture reads client records from a JSON file, in the format of a single run in `Dump`
+func LoadFixture(path string) ([]ClientRecord, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+	var records []ClientRecord
+	if err := json.Unmarshal(content, &records); err != nil {
+		return nil, errors.WrapPrefix(err, "couldn't parse fixture "+path, 0)
+	}
+	return records, nil
+}
+
+// LoadSnapshot restores the state from `SnapshotPath`, a missing file is not an error
+func (s *State) LoadSnapshot() error {
+	if s.SnapshotPath == "" {
+		return nil
+	}
+	content, err := os.ReadFile(s.SnapshotPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	var snapshot map[string][]ClientRecord
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return errors.WrapPrefix(err, "couldn't parse snapshot "+s.SnapshotPath, 0)
+	}
+	dbs := make(map[string]*Db, len(snapshot))
+	for runId, records := range snapshot {
+		db := NewDb(s.clock)
+		for _, record := range records {
+			if err := db.Restore(record); err != nil {
+				return errors.WrapPrefix(err, "couldn't restore client "+record.ClientId, 0)
+			}
+		}
+		dbs[runId] = db
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Db = dbs
+	return nil
+}
+
+// SaveSnapshot writes the state to `SnapshotPath`, replacing the previous snapshot atomically
+func (s *State) SaveSnapshot() error {
+	if s.SnapshotPath == "" {
+		return nil
+	}
+	s.lock.Lock()
+	content, err := json.Marshal(s.dump(DumpFilter{}))
+	s.lock.Unlock()
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	tmp := s.SnapshotPath + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o600); err != nil {
+		return errors.Wrap(err, 0)
+	}
+	if err := os.Rename(tmp, s.SnapshotPath); err != nil {
+		return errors.Wrap(err, 0)
+	}
+	return nil
+}
+
 func (s *State) GetDb(req *http.Request) *Db {
-	runId := req.Header.Get("X-Altinn-Operator-RunId")
+	runId := req.Header.Get(RunIdHeader)
 	if runId == "" {
 		log.Fatalf("Missing X-Altinn-Operator-RunId header in request: %v", req)
 	}
@@ -50,7 +168,7 @@ func (s *State) GetDb(req *http.Request) *Db {
 }
 
 func (s *State) initDb() *Db {
-	db := NewDb()
+	db := NewDb(s.clock)
 	jwk := crypto.Jwk{}
 	if err := json.Unmarshal([]byte(s.Cfg.MaskinportenApi.Jwk), &jwk); err != nil {
 		log.Fatalf("couldn't unmarshal JWK: %v", err)
@@ -82,8 +200,10 @@ func (s *State) initDb() *Db {
 
 func NewState(cfg *config.Config) *State {
 	return &State{
-		Db:   make(map[string]*Db),
-		Cfg:  cfg,
-		lock: sync.Mutex{},
+		Db:     make(map[string]*Db),
+		Cfg:    cfg,
+		Faults: NewFaults(),
+		clock:  clockwork.NewRealClock(),
+		lock:   sync.Mutex{},
 	}
 }
@@ -0,0 +1,85 @@
+package fakes
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/altinn/altinn-k8s-operator/internal/config"
+	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
+	"github.com/altinn/altinn-k8s-operator/internal/operatorcontext"
+	. "github.com/onsi/gomega"
+)
+
+func newTestState(g *WithT) *State {
+	operatorContext := operatorcontext.DiscoverOrDie(context.Background())
+	cfg, err := config.GetConfig(operatorContext, config.ConfigSourceKoanf, "")
+	g.Expect(err).NotTo(HaveOccurred())
+	return NewState(cfg)
+}
+
+func insertTestClient(g *WithT, db *Db, name string) *ClientRecord {
+	record, err := db.Insert(&maskinporten.AddClientRequest{ClientName: &name, Scopes: []string{"scope"}}, nil, "")
+	g.Expect(err).NotTo(HaveOccurred())
+	return record
+}
+
+func TestStateSnapshotRoundtrip(t *testing.T) {
+	g := NewWithT(t)
+	snapshotPath := path.Join(t.TempDir(), "state.json")
+
+	state := newTestState(g)
+	state.SnapshotPath = snapshotPath
+	state.Reset("run1")
+	record := insertTestClient(g, state.Db["run1"], "local-app1")
+	state.Reset("run2")
+	g.Expect(state.SaveSnapshot()).To(Succeed())
+
+	restored := newTestState(g)
+	restored.SnapshotPath = snapshotPath
+	g.Expect(restored.LoadSnapshot()).To(Succeed())
+	g.Expect(restored.Db).To(HaveLen(2))
+	g.Expect(restored.Db["run1"].Get(record.ClientId).Client.ClientName).To(Equal(record.Client.ClientName))
+	g.Expect(restored.Db["run2"].Clients).To(HaveLen(1))
+
+	// Missing snapshot starts from an empty state
+	empty := newTestState(g)
+	empty.SnapshotPath = path.Join(t.TempDir(), "missing.json")
+	g.Expect(empty.LoadSnapshot()).To(Succeed())
+	g.Expect(empty.Db).To(BeEmpty())
+}
+
+func TestStateSeedFromFixtureAndFilteredDump(t *testing.T) {
+	g := NewWithT(t)
+
+	state := newTestState(g)
+	state.Reset("run1")
+	insertTestClient(g, state.Db["run1"], "local-app1")
+	insertTestClient(g, state.Db["run1"], "other-app2")
+	fixture, err := json.Marshal(state.Dump(DumpFilter{RunId: "run1", ClientNamePrefix: "local-"})["run1"])
+	g.Expect(err).NotTo(HaveOccurred())
+	fixturePath := path.Join(t.TempDir(), "fixture.json")
+	g.Expect(os.WriteFile(fixturePath, fixture, 0o600)).To(Succeed())
+
+	records, err := LoadFixture(fixturePath)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(records).To(HaveLen(1))
+	g.Expect(state.Seed("run2", records)).To(Succeed())
+
+	// Seeded on top of the supplier client
+	g.Expect(state.Db["run2"].Clients).To(HaveLen(2))
+	dump := state.Dump(DumpFilter{ClientNamePrefix: "local-"})
+	g.Expect(dump).To(HaveKey("run1"))
+	g.Expect(dump["run1"]).To(HaveLen(1))
+	g.Expect(dump["run2"]).To(HaveLen(1))
+	g.Expect(state.Dump(DumpFilter{RunId: "run2"})).To(HaveLen(1))
+
+	// Duplicates are rejected, leaving the run untouched
+	g.Expect(state.Seed("run2", append(records, records...))).NotTo(Succeed())
+	g.Expect(state.Db["run2"].Clients).To(HaveLen(2))
+
+	state.Reset("run2")
+	g.Expect(state.Db["run2"].Clients).To(HaveLen(1))
+}
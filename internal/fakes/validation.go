@@ -0,0 +1,260 @@
+package fakes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/altinn/altinn-k8s-operator/internal/crypto"
+	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
+	"github.com/altinn/altinn-k8s-operator/internal/operatorcontext"
+	"github.com/google/uuid"
+)
+
+// Max number of keys in a client JWKS accepted by the self-service API
+const MaxJwksKeys = 5
+
+// Scopes owned by the supplier for administering clients, which clients can't be granted
+const SupplierScopePrefix = "idporten:dcr"
+
+var scopePattern = regexp.MustCompile(`^[a-z0-9_.\-]+:[a-zA-Z0-9_.\-/]+$`)
+
+// Grant types allowed per integration type, server-to-server integrations only allow JWT grants
+var allowedGrantTypes = map[maskinporten.IntegrationType][]maskinporten.GrantType{
+	maskinporten.IntegrationTypeMaskinporten: {maskinporten.GrantTypeJwtBearer},
+	maskinporten.IntegrationTypeKrr:          {maskinporten.GrantTypeJwtBearer},
+	maskinporten.IntegrationTypeEformidling:  {maskinporten.GrantTypeJwtBearer},
+	maskinporten.IntegrationTypeApiKlient:    {maskinporten.GrantTypeJwtBearer},
+	maskinporten.IntegrationTypeIdporten: {
+		maskinporten.GrantTypeAuthorizationCode,
+		maskinporten.GrantTypeRefreshToken,
+	},
+	maskinporten.IntegrationTypeAnsattporten: {
+		maskinporten.GrantTypeAuthorizationCode,
+		maskinporten.GrantTypeRefreshToken,
+	},
+	maskinporten.IntegrationTypeIdportenSaml2: {maskinporten.GrantTypeAuthorizationCode},
+}
+
+// ValidationError is returned for requests the self-service API would reject
+type ValidationError struct {
+	Errors []maskinporten.ApiError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		if err.FieldIdentifier != nil {
+			messages[i] = fmt.Sprintf("%s: %s", *err.FieldIdentifier, *err.ErrorMessage)
+		} else {
+			messages[i] = *err.ErrorMessage
+		}
+	}
+	return "invalid request: " + strings.Join(messages, ", ")
+}
+
+type validator struct {
+	objectName string
+	errors     []maskinporten.ApiError
+}
+
+func (v *validator) fieldError(field string, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	isFieldError := true
+	v.errors = append(v.errors, maskinporten.ApiError{
+		ErrorMessage:    &message,
+		IsFieldError:    &isFieldError,
+		ObjectName:      &v.objectName,
+		FieldIdentifier: &field,
+	})
+}
+
+func (v *validator) result() error {
+	if len(v.errors) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: v.errors}
+}
+
+// ValidateAddClientRequest checks the rules the self-service API enforces when registering clients
+func ValidateAddClientRequest(req *maskinporten.AddClientRequest) error {
+	v := &validator{objectName: "clientRequest"}
+	validateClient(v, &clientFields{
+		ClientName:              req.ClientName,
+		Description:             req.Description,
+		ClientOrgno:             req.ClientOrgno,
+		IntegrationType:         req.IntegrationType,
+		ApplicationType:         req.ApplicationType,
+		TokenEndpointAuthMethod: req.TokenEndpointAuthMethod,
+		GrantTypes:              req.GrantTypes,
+		Scopes:                  req.Scopes,
+		RedirectUris:            req.RedirectUris,
+	})
+	return v.result()
+}
+
+// ValidateUpdateClientRequest checks the same rules as `ValidateAddClientRequest`,
+// since updates replace the whole client definition
+func ValidateUpdateClientRequest(req *maskinporten.UpdateClientRequest) error {
+	v := &validator{objectName: "clientRequest"}
+	validateClient(v, &clientFields{
+		ClientName:              req.ClientName,
+		Description:             req.Description,
+		ClientOrgno:             req.ClientOrgno,
+		IntegrationType:         req.IntegrationType,
+		ApplicationType:         req.ApplicationType,
+		TokenEndpointAuthMethod: req.TokenEndpointAuthMethod,
+		GrantTypes:              req.GrantTypes,
+		Scopes:                  req.Scopes,
+		RedirectUris:            req.RedirectUris,
+	})
+	return v.result()
+}
+
+type clientFields struct {
+	ClientName              *string
+	Description             *string
+	ClientOrgno             *string
+	IntegrationType         *maskinporten.IntegrationType
+	ApplicationType         *maskinporten.ApplicationType
+	TokenEndpointAuthMethod *maskinporten.TokenEndpointAuthMethod
+	GrantTypes              []maskinporten.GrantType
+	Scopes                  []string
+	RedirectUris            []string
+}
+
+func validateClient(v *validator, c *clientFields) {
+	if c.ClientName == nil || strings.TrimSpace(*c.ClientName) == "" {
+		v.fieldError("client_name", "must not be empty")
+	}
+	if c.Description == nil || strings.TrimSpace(*c.Description) == "" {
+		v.fieldError("description", "must not be empty")
+	}
+	if c.ClientOrgno != nil && !operatorcontext.IsValidOrgNo(*c.ClientOrgno) {
+		v.fieldError("client_orgno", "invalid organization number: %s", *c.ClientOrgno)
+	}
+	if c.ApplicationType == nil {
+		v.fieldError("application_type", "must not be null")
+	} else if !slices.Contains([]maskinporten.ApplicationType{
+		maskinporten.ApplicationTypeWeb,
+		maskinporten.ApplicationTypeBrowser,
+		maskinporten.ApplicationTypeNative,
+	}, *c.ApplicationType) {
+		v.fieldError("application_type", "unknown application type: %s", *c.ApplicationType)
+	}
+
+	if c.IntegrationType == nil {
+		v.fieldError("integration_type", "must not be null")
+		return
+	}
+	allowed, ok := allowedGrantTypes[*c.IntegrationType]
+	if !ok {
+		v.fieldError("integration_type", "unknown integration type: %s", *c.IntegrationType)
+		return
+	}
+
+	if len(c.GrantTypes) == 0 {
+		v.fieldError("grant_types", "must not be empty")
+	}
+	for _, grantType := range c.GrantTypes {
+		if !slices.Contains(allowed, grantType) {
+			v.fieldError("grant_types", "grant type %s is not allowed for %s", grantType, *c.IntegrationType)
+		}
+	}
+
+	if slices.Contains(allowed, maskinporten.GrantTypeJwtBearer) {
+		privateKeyJwt := maskinporten.TokenEndpointAuthMethodPrivateKeyJwt
+		if c.TokenEndpointAuthMethod == nil || *c.TokenEndpointAuthMethod != privateKeyJwt {
+			v.fieldError("token_endpoint_auth_method", "must be %s for %s", privateKeyJwt, *c.IntegrationType)
+		}
+		if len(c.RedirectUris) > 0 {
+			v.fieldError("redirect_uris", "must be empty for %s", *c.IntegrationType)
+		}
+	} else if len(c.RedirectUris) == 0 {
+		v.fieldError("redirect_uris", "must not be empty for %s", *c.IntegrationType)
+	}
+
+	scopes := make(map[string]struct{}, len(c.Scopes))
+	for _, scope := range c.Scopes {
+		if strings.HasPrefix(scope, SupplierScopePrefix) {
+			v.fieldError("scopes", "clients cannot request %s scopes: %s", SupplierScopePrefix, scope)
+		} else if !scopePattern.MatchString(scope) {
+			v.fieldError("scopes", "invalid scope: %s", scope)
+		} else if _, ok := scopes[scope]; ok {
+			v.fieldError("scopes", "duplicate scope: %s", scope)
+		}
+		scopes[scope] = struct{}{}
+	}
+}
+
+// ValidateJwks checks the rules for JWKS uploaded to the self-service API:
+// public keys only, at most `MaxJwksKeys` keys, unique key IDs and no 'x5c' certificate chain
+func ValidateJwks(jwks *crypto.Jwks) error {
+	v := &validator{objectName: "jwks"}
+	if jwks == nil || len(jwks.Keys) == 0 {
+		v.fieldError("keys", "must not be empty")
+		return v.result()
+	}
+	if len(jwks.Keys) > MaxJwksKeys {
+		v.fieldError("keys", "must not contain more than %d keys", MaxJwksKeys)
+	}
+	kids := make(map[string]struct{}, len(jwks.Keys))
+	for i, jwk := range jwks.Keys {
+		field := fmt.Sprintf("keys[%d]", i)
+		if jwk == nil {
+			v.fieldError(field, "must not be null")
+			continue
+		}
+		if !jwk.IsPublic() {
+			v.fieldError(field, "must not contain private key material")
+		}
+		if len(jwk.Certificates()) > 0 {
+			v.fieldError(field+".x5c", "must not be present")
+		}
+		kid := jwk.KeyID()
+		if kid == "" {
+			v.fieldError(field+".kid", "must not be empty")
+		} else if _, ok := kids[kid]; ok {
+			v.fieldError(field+".kid", "duplicate key ID: %s", kid)
+		}
+		kids[kid] = struct{}{}
+	}
+	return v.result()
+}
+
+// NewApiErrorResponse builds an error body like the self-service API returns, with a new correlation ID
+func NewApiErrorResponse(status int, description string, err error) *maskinporten.ApiErrorResponse {
+	status32 := int32(status)
+	now := time.Now()
+	correlationId := uuid.New().String()
+	errorCode := strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+	response := &maskinporten.ApiErrorResponse{
+		Status:           &status32,
+		Timestamp:        &now,
+		CorrelationId:    &correlationId,
+		Error:            &errorCode,
+		ErrorDescription: &description,
+	}
+	if validationErr, ok := err.(*ValidationError); ok {
+		response.Errors = validationErr.Errors
+	}
+	return response
+}
+
+// WriteApiError writes an `ApiErrorResponse` and logs it with the correlation ID
+func WriteApiError(w http.ResponseWriter, status int, description string, err error) {
+	response := NewApiErrorResponse(status, description, err)
+	log.Printf("%s: status=%d correlation_id=%s err=%v\n", description, status, *response.CorrelationId, err)
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Add("X-Correlation-Id", *response.CorrelationId)
+	w.WriteHeader(status)
+	if encodeErr := json.NewEncoder(w).Encode(response); encodeErr != nil {
+		log.Printf("couldn't write response: %v\n", encodeErr)
+	}
+}
@@ -0,0 +1,83 @@
+package fakes
+
+import (
+	"context"
+	"testing"
+
+	"github.com/altinn/altinn-k8s-operator/internal/crypto"
+	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
+	"github.com/altinn/altinn-k8s-operator/internal/operatorcontext"
+	. "github.com/onsi/gomega"
+)
+
+func validationErrorFields(g *WithT, err error) []string {
+	g.Expect(err).To(BeAssignableToTypeOf(&ValidationError{}))
+	var fields []string
+	for _, apiErr := range err.(*ValidationError).Errors {
+		fields = append(fields, *apiErr.FieldIdentifier)
+	}
+	return fields
+}
+
+func TestValidateAddClientRequest(t *testing.T) {
+	g := NewWithT(t)
+	operatorContext := operatorcontext.DiscoverOrDie(context.Background())
+
+	req := newTestAddClientRequest(operatorContext, "app1", "altinn:resourceregistry/resource.read")
+	g.Expect(ValidateAddClientRequest(req)).To(Succeed())
+	g.Expect(ValidateUpdateClientRequest(toUpdateClientRequest(req))).To(Succeed())
+
+	req.Description = nil
+	req.GrantTypes = []maskinporten.GrantType{maskinporten.GrantTypeAuthorizationCode}
+	req.RedirectUris = []string{"https://example.com"}
+	req.Scopes = []string{"idporten:dcr.altinn", "altinn:scope", "altinn:scope", "invalid"}
+	g.Expect(validationErrorFields(g, ValidateAddClientRequest(req))).To(Equal([]string{
+		"description", "grant_types", "redirect_uris", "scopes", "scopes", "scopes",
+	}))
+
+	integrationType := maskinporten.IntegrationTypeIdporten
+	req = newTestAddClientRequest(operatorContext, "app1")
+	req.IntegrationType = &integrationType
+	g.Expect(validationErrorFields(g, ValidateAddClientRequest(req))).To(Equal([]string{
+		"grant_types", "redirect_uris",
+	}))
+
+	req = newTestAddClientRequest(operatorContext, "app1")
+	req.IntegrationType = nil
+	req.ApplicationType = nil
+	g.Expect(validationErrorFields(g, ValidateAddClientRequest(req))).To(Equal([]string{
+		"application_type", "integration_type",
+	}))
+}
+
+func TestValidateJwks(t *testing.T) {
+	g := NewWithT(t)
+	_, _, jwks := newTestApiClient(g)
+	publicJwks, err := jwks.ToPublic()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(ValidateJwks(publicJwks)).To(Succeed())
+	g.Expect(validationErrorFields(g, ValidateJwks(nil))).To(Equal([]string{"keys"}))
+	g.Expect(validationErrorFields(g, ValidateJwks(jwks))).To(Equal([]string{"keys[0]", "keys[0].x5c"}))
+
+	key := publicJwks.Keys[0]
+	g.Expect(validationErrorFields(g, ValidateJwks(crypto.NewJwks(key, key)))).To(Equal([]string{"keys[1].kid"}))
+	tooMany := crypto.NewJwks(key, key, key, key, key, key)
+	g.Expect(validationErrorFields(g, ValidateJwks(tooMany))).To(ContainElement("keys"))
+}
+
+func TestApiErrorResponse(t *testing.T) {
+	g := NewWithT(t)
+
+	err := ValidateJwks(nil)
+	response := NewApiErrorResponse(400, "invalid JWKS", err)
+	g.Expect(*response.Status).To(Equal(int32(400)))
+	g.Expect(*response.Error).To(Equal("bad_request"))
+	g.Expect(*response.CorrelationId).NotTo(BeEmpty())
+	g.Expect(response.Errors).To(HaveLen(1))
+	g.Expect(response.String()).To(ContainSubstring("keys must not be empty"))
+
+	other := NewApiErrorResponse(404, "client not found", nil)
+	g.Expect(*other.Error).To(Equal("not_found"))
+	g.Expect(*other.CorrelationId).NotTo(Equal(*response.CorrelationId))
+}
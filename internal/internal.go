@@ -3,7 +3,12 @@ package internal
 import (
 	"context"
 	crand "crypto/rand"
+	"fmt"
+	"reflect"
+	"sync/atomic"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/altinn/altinn-k8s-operator/internal/config"
 	"github.com/altinn/altinn-k8s-operator/internal/crypto"
 	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
@@ -12,23 +17,90 @@ import (
 	"github.com/altinn/altinn-k8s-operator/internal/telemetry"
 	"github.com/jonboulle/clockwork"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 type runtime struct {
-	config                config.Config
-	operatorContext       operatorcontext.Context
-	crypto                crypto.CryptoService
-	maskinportenApiClient *maskinporten.HttpApiClient
-	tracer                trace.Tracer
-	meter                 metric.Meter
-	clock                 clockwork.Clock
+	state           atomic.Pointer[configState]
+	configWatcher   *config.Watcher
+	operatorContext operatorcontext.Context
+	crypto          crypto.CryptoService
+	keyStore        crypto.KeyStore
+	tracer          trace.Tracer
+	meter           metric.Meter
+	clock           clockwork.Clock
+	apiClientFor    MaskinportenApiClientFactory
+}
+
+// MaskinportenApiClientFactory builds the API client of a Maskinporten profile
+type MaskinportenApiClientFactory func(
+	profile string,
+	cfg *config.MaskinportenApiConfig,
+	operatorContext *operatorcontext.Context,
+	clock clockwork.Clock,
+) (maskinporten.ApiClient, error)
+
+// RuntimeOption customizes the runtime, e.g. to run the operator against a virtual clock in tests
+type RuntimeOption func(*runtimeOptions)
+
+type runtimeOptions struct {
+	clock        clockwork.Clock
+	apiClientFor MaskinportenApiClientFactory
+}
+
+// WithClock replaces the real clock, used for JWKS rotation, API tokens and config polling
+func WithClock(clock clockwork.Clock) RuntimeOption {
+	return func(o *runtimeOptions) {
+		o.clock = clock
+	}
+}
+
+// WithMaskinportenApiClientFactory replaces the HTTP API clients, e.g. with the in-memory clients in `internal/fakes`
+func WithMaskinportenApiClientFactory(factory MaskinportenApiClientFactory) RuntimeOption {
+	return func(o *runtimeOptions) {
+		o.apiClientFor = factory
+	}
+}
+
+func newHttpApiClient(
+	profile string,
+	cfg *config.MaskinportenApiConfig,
+	operatorContext *operatorcontext.Context,
+	clock clockwork.Clock,
+) (maskinporten.ApiClient, error) {
+	return maskinporten.NewHttpApiClient(cfg, operatorContext, clock)
+}
+
+// configState is swapped as a whole on config reload. Callers keep using the
+// config and API client they got, so in-flight requests finish on the previous client.
+type configState struct {
+	config *config.Config
+	// Keyed by Maskinporten profile name
+	maskinportenApiClients map[string]maskinporten.ApiClient
+	version                int64
+	hash                   string
 }
 
 var _ rt.Runtime = (*runtime)(nil)
 
-func NewRuntime(ctx context.Context, env string) (rt.Runtime, error) {
+func NewRuntime(
+	ctx context.Context,
+	env string,
+	configFilePath string,
+	overrides config.Overrides,
+	opts ...RuntimeOption,
+) (rt.Runtime, error) {
+	options := runtimeOptions{
+		clock:        clockwork.NewRealClock(),
+		apiClientFor: newHttpApiClient,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	tracer := otel.Tracer(telemetry.ServiceName)
 	ctx, span := tracer.Start(ctx, "NewRuntime")
 	defer span.End()
@@ -41,41 +113,203 @@ func NewRuntime(ctx context.Context, env string) (rt.Runtime, error) {
 		operatorContext.OverrideEnvironment(env)
 	}
 
-	cfg, err := config.GetConfig(operatorContext, config.ConfigSourceDefault, "")
+	cfg, err := config.GetConfigWithOverrides(operatorContext, config.ConfigSourceDefault, configFilePath, overrides)
 	if err != nil {
 		return nil, err
 	}
 
-	clock := clockwork.NewRealClock()
+	clock := options.clock
 
 	cryptoRand := crand.Reader
 
+	var ca *crypto.CertificateAuthority
+	if cfg.CA.Enabled() {
+		ca, err = crypto.LoadCertificateAuthority([]byte(cfg.CA.Cert), []byte(cfg.CA.Key), clock.Now())
+		if err != nil {
+			return nil, fmt.Errorf("error loading operator CA: %w", err)
+		}
+	}
+
 	crypto := crypto.NewDefaultService(
 		operatorContext,
 		clock,
 		cryptoRand,
 	)
+	if ca != nil {
+		crypto = crypto.WithCertificateAuthority(ca)
+	}
+
+	keyStore, err := newKeyStore(operatorContext, &cfg.KeyStore)
+	if err != nil {
+		return nil, err
+	}
 
-	maskinportenApiClient, err := maskinporten.NewHttpApiClient(&cfg.MaskinportenApi, operatorContext, clock)
+	maskinportenApiClients, err := newMaskinportenApiClients(cfg, operatorContext, clock, options.apiClientFor, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	rt := &runtime{
-		config:                *cfg,
-		operatorContext:       *operatorContext,
-		crypto:                *crypto,
-		maskinportenApiClient: maskinportenApiClient,
-		tracer:                tracer,
-		meter:                 otel.Meter(telemetry.ServiceName),
-		clock:                 clock,
+		operatorContext: *operatorContext,
+		crypto:          *crypto,
+		keyStore:        keyStore,
+		tracer:          tracer,
+		meter:           otel.Meter(telemetry.ServiceName),
+		clock:           clock,
+		apiClientFor:    options.apiClientFor,
+	}
+	rt.state.Store(&configState{
+		config:                 cfg,
+		maskinportenApiClients: maskinportenApiClients,
+		version:                1,
+		hash:                   cfg.Hash(),
+	})
+
+	// Key store, CA and token service settings are only read at startup, see `reloadConfig`
+	rt.configWatcher, err = config.NewWatcher(
+		&rt.operatorContext,
+		config.ConfigSourceDefault,
+		configFilePath,
+		overrides,
+		cfg,
+		clock,
+		rt.reloadConfig,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = rt.meter.Int64ObservableGauge(
+		"config.version",
+		metric.WithDescription("Version of the active operator config, incremented on every reload"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			state := rt.state.Load()
+			o.Observe(state.version, metric.WithAttributes(attribute.String("config.hash", state.hash)))
+			return nil
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if ca != nil {
+		_, err = rt.meter.Float64ObservableGauge(
+			"crypto.ca.expiry",
+			metric.WithUnit("d"),
+			metric.WithDescription("Days until the operator CA expires, app certificates can't outlive it"),
+			metric.WithFloat64Callback(func(ctx context.Context, o metric.Float64Observer) error {
+				o.Observe(ca.Certificate().NotAfter.Sub(rt.clock.Now()).Hours() / 24)
+				return nil
+			}),
+		)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return rt, nil
 }
 
+func (r *runtime) reloadConfig(cfg *config.Config) error {
+	current := r.state.Load()
+
+	// Changes to settings only read at startup are kept out of the active config,
+	// so that it keeps reflecting what is in effect until the operator is restarted
+	effective := *cfg
+	var restartRequired []string
+	if !reflect.DeepEqual(cfg.CA, current.config.CA) {
+		restartRequired = append(restartRequired, "ca")
+		effective.CA = current.config.CA
+	}
+	if !reflect.DeepEqual(cfg.KeyStore, current.config.KeyStore) {
+		restartRequired = append(restartRequired, "key_store")
+		effective.KeyStore = current.config.KeyStore
+	}
+	if !reflect.DeepEqual(cfg.TokenService, current.config.TokenService) {
+		restartRequired = append(restartRequired, "token_service")
+		effective.TokenService = current.config.TokenService
+	}
+	if len(restartRequired) > 0 {
+		log.Log.WithName("config").Info(
+			"WARNING: reloaded config changes settings that only take effect after a restart",
+			"sections", restartRequired,
+		)
+	}
+	cfg = &effective
+
+	maskinportenApiClients, err := newMaskinportenApiClients(cfg, &r.operatorContext, r.clock, r.apiClientFor, current)
+	if err != nil {
+		return fmt.Errorf("error building Maskinporten API clients for reloaded config: %w", err)
+	}
+
+	r.state.Store(&configState{
+		config:                 cfg,
+		maskinportenApiClients: maskinportenApiClients,
+		version:                current.version + 1,
+		hash:                   cfg.Hash(),
+	})
+	return nil
+}
+
+// newMaskinportenApiClients builds an API client per Maskinporten profile.
+// Clients of profiles with unchanged config since the previous state are reused.
+func newMaskinportenApiClients(
+	cfg *config.Config,
+	operatorContext *operatorcontext.Context,
+	clock clockwork.Clock,
+	apiClientFor MaskinportenApiClientFactory,
+	previous *configState,
+) (map[string]maskinporten.ApiClient, error) {
+	clients := make(map[string]maskinporten.ApiClient)
+	for _, profile := range cfg.MaskinportenProfileNames() {
+		profileConfig, err := cfg.MaskinportenProfile(profile)
+		if err != nil {
+			return nil, err
+		}
+		if previous != nil {
+			previousConfig, err := previous.config.MaskinportenProfile(profile)
+			if err == nil && *previousConfig == *profileConfig {
+				clients[profile] = previous.maskinportenApiClients[profile]
+				continue
+			}
+		}
+
+		client, err := apiClientFor(profile, profileConfig, operatorContext, clock)
+		if err != nil {
+			return nil, fmt.Errorf("error building Maskinporten API client for profile '%s': %w", profile, err)
+		}
+		clients[profile] = client
+	}
+	return clients, nil
+}
+
+func newKeyStore(operatorContext *operatorcontext.Context, cfg *config.KeyStoreConfig) (crypto.KeyStore, error) {
+	switch crypto.KeyStoreBackend(cfg.Backend) {
+	case "", crypto.KeyStoreBackendSecret:
+		return crypto.NewSecretKeyStore(), nil
+	case crypto.KeyStoreBackendAzureKeyVault:
+		var cred azcore.TokenCredential
+		var err error
+		if operatorContext.IsLocal() {
+			cred, err = azidentity.NewDefaultAzureCredential(nil)
+		} else {
+			cred, err = azidentity.NewWorkloadIdentityCredential(nil)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error getting credentials for key store: %w", err)
+		}
+		return crypto.NewAzureKeyVaultKeyStore(cfg.AzureKeyVaultUrl, cred, nil)
+	default:
+		return nil, fmt.Errorf("invalid key store backend: %s", cfg.Backend)
+	}
+}
+
 func (r *runtime) GetConfig() *config.Config {
-	return &r.config
+	return r.state.Load().config
+}
+
+func (r *runtime) GetConfigWatcher() *config.Watcher {
+	return r.configWatcher
 }
 
 func (r *runtime) GetOperatorContext() *operatorcontext.Context {
@@ -86,12 +320,25 @@ func (r *runtime) GetCrypto() *crypto.CryptoService {
 	return &r.crypto
 }
 
+func (r *runtime) GetKeyStore() crypto.KeyStore {
+	return r.keyStore
+}
+
 func (r *runtime) GetClock() clockwork.Clock {
 	return r.clock
 }
 
-func (r *runtime) GetMaskinportenApiClient() *maskinporten.HttpApiClient {
-	return r.maskinportenApiClient
+func (r *runtime) GetMaskinportenApiClient() maskinporten.ApiClient {
+	return r.state.Load().maskinportenApiClients[config.DefaultMaskinportenProfile]
+}
+
+func (r *runtime) GetMaskinportenApiClientFor(profile string) (maskinporten.ApiClient, error) {
+	profile = config.NormalizeMaskinportenProfile(profile)
+	client, ok := r.state.Load().maskinportenApiClients[profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown Maskinporten profile: '%s'", profile)
+	}
+	return client, nil
 }
 
 func (r *runtime) Tracer() trace.Tracer {
@@ -0,0 +1,31 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestReloadKeepsStartupOnlySettings(t *testing.T) {
+	g := NewWithT(t)
+
+	loaded, err := NewRuntime(context.Background(), "", "", nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	r := loaded.(*runtime)
+	initial := *r.GetConfig()
+
+	reloaded := initial
+	reloaded.Controller.RequeueAfter = time.Hour
+	reloaded.KeyStore.Backend = "azure_key_vault"
+	reloaded.TokenService.CacheDuration = time.Hour
+	g.Expect(r.reloadConfig(&reloaded)).To(Succeed())
+
+	active := r.GetConfig()
+	g.Expect(active.Controller.RequeueAfter).To(Equal(time.Hour))
+	g.Expect(active.KeyStore).To(Equal(initial.KeyStore))
+	g.Expect(active.TokenService).To(Equal(initial.TokenService))
+	g.Expect(r.state.Load().version).To(Equal(int64(2)))
+	g.Expect(r.state.Load().hash).To(Equal(active.Hash()))
+}
@@ -0,0 +1,26 @@
+package maskinporten
+
+import (
+	"context"
+
+	"github.com/altinn/altinn-k8s-operator/internal/crypto"
+)
+
+// ApiClient manages the clients of the service owner in a Maskinporten environment.
+// `HttpApiClient` talks to the real APIs, an in-memory implementation is found in `internal/fakes`.
+type ApiClient interface {
+	// GetAllClients returns the clients managed by the operator, others are filtered out
+	GetAllClients(ctx context.Context) ([]ClientResponse, error)
+	GetClient(ctx context.Context, clientId string) (*ClientResponse, *crypto.Jwks, error)
+	// GetUnmanagedClient returns a client regardless of its name, used to adopt clients registered outside the operator
+	GetUnmanagedClient(ctx context.Context, clientId string) (*ClientResponse, error)
+	// CreateClient creates the client and uploads the public JWKS
+	CreateClient(ctx context.Context, client *AddClientRequest, jwks *crypto.Jwks) (*ClientResponse, error)
+	UpdateClient(ctx context.Context, clientId string, client *UpdateClientRequest) (*ClientResponse, error)
+	CreateClientJwks(ctx context.Context, clientId string, jwks *crypto.Jwks) error
+	DeleteClient(ctx context.Context, clientId string) error
+	// GetAccessTokenFor mints an access token on behalf of a client, see `HttpApiClient.GetAccessTokenFor`
+	GetAccessTokenFor(ctx context.Context, clientId string, jwk *crypto.Jwk, scopes []string) (*TokenResponse, error)
+}
+
+var _ ApiClient = (*HttpApiClient)(nil)
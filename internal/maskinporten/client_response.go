@@ -1,6 +1,7 @@
 package maskinporten
 
 import (
+	"strings"
 	"time"
 
 	"github.com/altinn/altinn-k8s-operator/internal/crypto"
@@ -130,3 +131,31 @@ type ApiErrorResponse struct {
 	Error            *string    `json:"error,omitempty"`
 	ErrorDescription *string    `json:"error_description,omitempty"`
 }
+
+// String formats the error with its field errors and correlation ID, for logging and error messages
+func (r *ApiErrorResponse) String() string {
+	var sb strings.Builder
+	if r.Error != nil {
+		sb.WriteString(*r.Error)
+	}
+	if r.ErrorDescription != nil {
+		sb.WriteString(": ")
+		sb.WriteString(*r.ErrorDescription)
+	}
+	for _, err := range r.Errors {
+		sb.WriteString("; ")
+		if err.FieldIdentifier != nil {
+			sb.WriteString(*err.FieldIdentifier)
+			sb.WriteString(" ")
+		}
+		if err.ErrorMessage != nil {
+			sb.WriteString(*err.ErrorMessage)
+		}
+	}
+	if r.CorrelationId != nil {
+		sb.WriteString(" (correlation_id=")
+		sb.WriteString(*r.CorrelationId)
+		sb.WriteString(")")
+	}
+	return sb.String()
+}
@@ -1,9 +1,11 @@
 package maskinporten
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"slices"
 	"strings"
 	"time"
 
@@ -18,6 +20,9 @@ import (
 
 const JsonFileName = "maskinporten-settings.json"
 
+// RetainedClientMarker prefixes the description of clients left in Maskinporten by `DeletionPolicyRetain`
+const RetainedClientMarker = "[retained] "
+
 // ClientState reprsents a snapshot of the state of a Maskinporten client
 // across sources such as
 //   - MaskinportenClient k8s CRD
@@ -41,9 +46,17 @@ type ClientState struct {
 	Api *ApiState
 	// The "output" of this operatator, serialized to field
 	Secret SecretState
+	// Adopting is set when `Api` is a client registered outside the operator,
+	// found through `resourcesv1alpha1.AdoptClientIdAnnotation`
+	Adopting bool
+	// MovingTo is the client of this app already registered in the desired profile while `Api` is in another,
+	// left behind by an earlier attempt to move the client that failed before the secret was updated
+	MovingTo *ApiState
 }
 
 type ApiState struct {
+	// Maskinporten profile the client is registered in, see `config.MaskinportenProfile`
+	Profile  string
 	ClientId string
 	Req      *AddClientRequest
 	Jwks     *crypto.Jwks
@@ -55,10 +68,73 @@ type SecretState struct {
 }
 
 type SecretStateContent struct {
-	ClientId  string       `json:"clientId"`
-	Authority string       `json:"authority"`
-	Jwks      *crypto.Jwks `json:"jwks"`
-	Jwk       *crypto.Jwk  `json:"jwk"`
+	ClientId  string `json:"clientId"`
+	Authority string `json:"authority"`
+	// Maskinporten profile of the client, empty for secrets written before profiles were introduced
+	Profile string       `json:"profile,omitempty"`
+	Jwks    *crypto.Jwks `json:"jwks,omitempty"`
+	Jwk     *crypto.Jwk  `json:"jwk,omitempty"`
+	// Set instead of Jwks/Jwk when private keys are kept in an external key store
+	KeyRefs []crypto.KeyRef `json:"keyRefs,omitempty"`
+}
+
+// StoreKeys hands the private keys over to the key store. If the store keeps keys externally,
+// the returned content contains key references instead of key material.
+func (c *SecretStateContent) StoreKeys(
+	ctx context.Context,
+	keyStore crypto.KeyStore,
+	name string,
+) (*SecretStateContent, error) {
+	refs, err := keyStore.Put(ctx, name, c.Jwks)
+	if err != nil {
+		return nil, err
+	}
+	if refs == nil {
+		return c, nil
+	}
+
+	return &SecretStateContent{
+		ClientId:  c.ClientId,
+		Authority: c.Authority,
+		Profile:   c.Profile,
+		KeyRefs:   refs,
+	}, nil
+}
+
+// LoadKeys resolves key references from the key store into Jwks/Jwk,
+// so that the rest of the reconciliation sees the same content regardless of key store.
+// References the configured key store can't resolve leave Jwks/Jwk unset, and the keys are replaced on reconcile.
+func (c *SecretStateContent) LoadKeys(ctx context.Context, keyStore crypto.KeyStore) error {
+	if len(c.KeyRefs) == 0 {
+		return nil
+	}
+
+	jwks, err := keyStore.Get(ctx, c.KeyRefs)
+	if errors.Is(err, crypto.ErrKeyRefsUnresolvable) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if jwks == nil || len(jwks.Keys) == 0 {
+		return errors.Errorf("key store returned no keys for %d references", len(c.KeyRefs))
+	}
+
+	c.Jwks = jwks
+	c.Jwk = jwks.Keys[0]
+	return nil
+}
+
+// StaleKeyRefs returns the key references that are no longer referenced by the next content
+func (c *SecretStateContent) StaleKeyRefs(next *SecretStateContent) []crypto.KeyRef {
+	result := make([]crypto.KeyRef, 0, len(c.KeyRefs))
+	for _, ref := range c.KeyRefs {
+		if next != nil && slices.Contains(next.KeyRefs, ref) {
+			continue
+		}
+		result = append(result, ref)
+	}
+	return result
 }
 
 func (c *SecretStateContent) SerializeTo(secret *corev1.Secret) error {
@@ -106,6 +182,7 @@ func NewClientState(
 	crd *resourcesv1alpha1.MaskinportenClient,
 	api *ClientResponse,
 	apiJwks *crypto.Jwks,
+	apiProfile string,
 	secret *corev1.Secret,
 	secretStateContent *SecretStateContent,
 ) (*ClientState, error) {
@@ -140,16 +217,22 @@ func NewClientState(
 		if api.ClientId == "" {
 			return nil, errors.Errorf("received empty ClientId when building client state")
 		}
-		state.Api = &ApiState{
-			ClientId: api.ClientId,
-			Req:      mapClientResponseToAddRequest(api),
-			Jwks:     apiJwks,
-		}
+		state.Api = NewApiState(apiProfile, api, apiJwks)
 	}
 
 	return state, nil
 }
 
+// NewApiState maps a client in the API of the given profile to its state
+func NewApiState(profile string, api *ClientResponse, jwks *crypto.Jwks) *ApiState {
+	return &ApiState{
+		Profile:  normalizeProfile(profile),
+		ClientId: api.ClientId,
+		Req:      mapClientResponseToAddRequest(api),
+		Jwks:     jwks,
+	}
+}
+
 func (s *ClientState) getNotAfter(clock clockwork.Clock) time.Time {
 	return clock.Now().UTC().Add(time.Hour * 24 * 30)
 }
@@ -180,81 +263,116 @@ func (s *ClientState) Reconcile(
 	//   n.1. Update client in Maskinporten API
 	// n. Authority changes - should be rare or even unlikely
 	//   n.1. Update secret contents
+	// n. Profile changes - the client moves to another Maskinporten environment
+	//   n.1. Create client in the new profile
+	//   n.2. Update secret contents
+	//   n.3. Delete client in the previous profile
 	// n. Cert used in JWKS expires - will happen regularly
 	//   n.1. Generate next cert and JWK
 	//   n.2. Update secret contents
+	// n. An existing client is adopted through the adopt annotation
+	//   n.1. Rename the client and upload a new JWKS in Maskinporten API
+	//   n.2. Update secret contents
 	// n. Deletion timestamp is set on CRD (it's deleted)
 	//   n.1. Delete secret contents
-	//   n.2. Delete client in API
+	//   n.2. Delete or deactivate client in API, unless the deletion policy retains it
 
 	// Other events not currently being considered
 	// n. Someone deletes/modifies secret/contents by accident
 	// n. Someone deletes/modifies Maskinporten API client definition by accident (it is not locked)
 	// n. ???
 
+	profile := normalizeProfile(s.Crd.Spec.Profile)
+	authority := ""
+	if s.Crd.DeletionTimestamp == nil {
+		apiConfig, err := config.MaskinportenProfile(profile)
+		if err != nil {
+			return nil, err
+		}
+		authority = apiConfig.AuthorityUrl
+	}
+
 	commands := make([]Command, 0, 4)
 	if s.Crd.DeletionTimestamp != nil {
 		// The CRD is being deleted, which means we need to cleanup all associated resources
 		if s.Secret.Content != nil {
-			commands = append(commands, Command{
-				Data:     &DeleteSecretContentCommand{},
-				Callback: nil,
-			})
+			commands = append(commands, &DeleteSecretContentCommand{Previous: s.Secret.Content})
 		}
 		if s.Api != nil {
-			commands = append(commands, Command{
-				Data: &DeleteClientInApiCommand{
+			switch s.Crd.Spec.DeletionPolicy {
+			case resourcesv1alpha1.DeletionPolicyRetain:
+				// Marked so that the orphan collector leaves the client for adoption by another cluster
+				if !IsRetainedClient(s.Api.Req.Description) {
+					commands = append(commands, &UpdateClientInApiCommand{
+						Previous: s.Api,
+						Api: &ApiState{
+							Profile:  s.Api.Profile,
+							ClientId: s.Api.ClientId,
+							Req:      s.retainedApiReq(),
+							Jwks:     nil, // signals no update
+						},
+					})
+				}
+			case resourcesv1alpha1.DeletionPolicyDeactivate:
+				if s.Api.Req.Active == nil || *s.Api.Req.Active {
+					commands = append(commands, &UpdateClientInApiCommand{
+						Previous: s.Api,
+						Api: &ApiState{
+							Profile:  s.Api.Profile,
+							ClientId: s.Api.ClientId,
+							Req:      s.deactivatedApiReq(),
+							Jwks:     nil, // signals no update
+						},
+					})
+				}
+			default:
+				commands = append(commands, &DeleteClientInApiCommand{
+					Profile:  s.Api.Profile,
 					ClientId: s.Api.ClientId,
-				},
-				Callback: nil,
-			})
+				})
+			}
 		}
 	} else if s.Api == nil {
 		// The initial case, where we have to create everything
 		// There may be the case that the `api` resource is null,
 		// but the secret output exists, in which case we just overwrite it
-		req := s.buildApiReq(context)
-		jwks, err := crypto.CreateJwks(s.AppId, s.getNotAfter(clock))
+		createCommands, err := s.createClientCommands(context, crypto, clock, profile, authority)
 		if err != nil {
 			return nil, err
 		}
-		publicJwks, err := jwks.ToPublic()
+		commands = append(commands, createCommands...)
+	} else if s.Api.Profile != profile {
+		// The client is moving to another Maskinporten profile. We create the client in the new profile
+		// and switch the secret over before deleting the previous client,
+		// so that failing halfway never leaves the app without working credentials.
+		// A client left in the new profile by a failed attempt is reused, so that retries don't leak clients
+		var moveCommands []Command
+		var err error
+		if s.MovingTo != nil && s.MovingTo.Profile == profile {
+			moveCommands, err = s.reuseClientCommands(context, crypto, clock, profile, authority)
+		} else {
+			moveCommands, err = s.createClientCommands(context, crypto, clock, profile, authority)
+		}
 		if err != nil {
 			return nil, err
 		}
-		apiState := &ApiState{
-			ClientId: "", // don't know yet
-			Req:      req,
-			Jwks:     publicJwks,
-		}
-		secretStateContent := &SecretStateContent{
-			ClientId:  "", // set via the callback below
-			Authority: config.MaskinportenApi.AuthorityUrl,
-			Jwks:      jwks,
-			Jwk:       jwks.Keys[0],
-		}
-		commands = append(commands, Command{
-			Data: &CreateClientInApiCommand{
-				Api: apiState,
-			},
-			Callback: func(respObj any) error {
-				resp := respObj.(*CreateClientInApiCommandResponse)
-				apiState.ClientId = resp.Resp.ClientId
-				secretStateContent.ClientId = resp.Resp.ClientId
-				return nil
-			},
-		})
-		commands = append(commands, Command{
-			Data: &UpdateSecretContentCommand{
-				SecretContent: secretStateContent,
-			},
-			Callback: nil,
+		commands = append(commands, moveCommands...)
+		commands = append(commands, &DeleteClientInApiCommand{
+			Profile:  s.Api.Profile,
+			ClientId: s.Api.ClientId,
 		})
 	} else {
-		if s.Secret.Content == nil {
-			// In this case, there are two possible scenarios
+		if s.Adopting {
+			adoptCommands, err := s.adoptClientCommands(context, crypto, clock, profile, authority)
+			if err != nil {
+				return nil, err
+			}
+			commands = append(commands, adoptCommands...)
+		} else if s.Secret.Content == nil || s.Secret.Content.Jwks == nil {
+			// In this case, there are three possible scenarios
 			// * The API client was created, but we failed to update the secret content
 			// * Someone else created the API client
+			// * The secret references keys in a key store that is no longer configured
 
 			// Since the private JWKS is stored in the secret, it has been lost and we need to create a new one
 			jwks, err := crypto.CreateJwks(s.AppId, s.getNotAfter(clock))
@@ -266,30 +384,36 @@ func (s *ClientState) Reconcile(
 				return nil, err
 			}
 			apiState := &ApiState{
+				Profile:  profile,
 				ClientId: s.Api.ClientId,
 				Req:      nil, // signals no update
 				Jwks:     publicJwks,
 			}
-			commands = append(commands, Command{
-				Data: &UpdateClientInApiCommand{
-					Api: apiState,
-				},
+			// Scopes may have changed while the secret was missing, and a recreated resource
+			// may find the client deactivated or retained by its predecessor
+			if s.apiReqOutdated() {
+				apiState.Req = s.buildApiReq(context)
+			}
+			commands = append(commands, &UpdateClientInApiCommand{
+				Previous: s.Api,
+				Api:      apiState,
 			})
 			secretStateContent := &SecretStateContent{
 				ClientId:  s.Api.ClientId,
-				Authority: config.MaskinportenApi.AuthorityUrl,
+				Authority: authority,
+				Profile:   profile,
 				Jwks:      jwks,
 				Jwk:       jwks.Keys[0],
 			}
-			commands = append(commands, Command{
-				Data: &UpdateSecretContentCommand{
-					SecretContent: secretStateContent,
-				},
-				Callback: nil,
+			commands = append(commands, &UpdateSecretContentCommand{
+				Previous:      s.Secret.Content,
+				SecretContent: secretStateContent,
 			})
 		} else {
-			authorityChanged := config.MaskinportenApi.AuthorityUrl != s.Secret.Content.Authority
-			scopesChanged := !reflect.DeepEqual(s.Crd.Spec.Scopes, s.Api.Req.Scopes)
+			authorityChanged := authority != s.Secret.Content.Authority
+			// Secrets written before profiles were introduced get the profile recorded
+			profileChanged := profile != s.Secret.Content.Profile
+			apiReqOutdated := s.apiReqOutdated()
 			jwks, err := crypto.RotateIfNeeded(s.AppId, s.getNotAfter(clock), s.Secret.Content.Jwks)
 			if err != nil {
 				return nil, err
@@ -297,24 +421,23 @@ func (s *ClientState) Reconcile(
 			jwksChanged := jwks != nil
 
 			// Handle state changes that are contained in the secret
-			if authorityChanged || jwksChanged {
+			if authorityChanged || profileChanged || jwksChanged {
 				if !jwksChanged {
 					jwks = s.Secret.Content.Jwks
 				}
 
 				secretStateContent := &SecretStateContent{
 					ClientId:  s.Api.ClientId,
-					Authority: config.MaskinportenApi.AuthorityUrl,
+					Authority: authority,
+					Profile:   profile,
 					Jwks:      jwks,
 					Jwk:       jwks.Keys[0],
 				}
-				commands = append(commands, Command{
-					// TODO: what happens if we succeed in updating the secret but fail in updating the client in API?
-					// Update API before secret
-					Data: &UpdateSecretContentCommand{
-						SecretContent: secretStateContent,
-					},
-					Callback: nil,
+				// TODO: what happens if we succeed in updating the secret but fail in updating the client in API?
+				// Update API before secret
+				commands = append(commands, &UpdateSecretContentCommand{
+					Previous:      s.Secret.Content,
+					SecretContent: secretStateContent,
 				})
 			}
 
@@ -325,28 +448,28 @@ func (s *ClientState) Reconcile(
 					return nil, err
 				}
 				apiState := &ApiState{
+					Profile:  profile,
 					ClientId: s.Api.ClientId,
 					Req:      nil, // signals no update
 					Jwks:     publicJwks,
 				}
-				commands = append(commands, Command{
-					Data: &UpdateClientInApiCommand{
-						Api: apiState,
-					},
+				commands = append(commands, &UpdateClientInApiCommand{
+					Previous: s.Api,
+					Api:      apiState,
 				})
 			}
 
 			// Handle client endpoint state changes
-			if scopesChanged {
+			if apiReqOutdated {
 				apiState := &ApiState{
+					Profile:  profile,
 					ClientId: s.Api.ClientId,
 					Req:      s.buildApiReq(context), // this reads scopes from CRD
 					Jwks:     nil,                    // signals no update
 				}
-				commands = append(commands, Command{
-					Data: &UpdateClientInApiCommand{
-						Api: apiState,
-					},
+				commands = append(commands, &UpdateClientInApiCommand{
+					Previous: s.Api,
+					Api:      apiState,
 				})
 			}
 		}
@@ -356,6 +479,194 @@ func (s *ClientState) Reconcile(
 	return commands, nil
 }
 
+// createClientCommands creates the client and JWKS in the API of the profile, and writes the secret
+func (s *ClientState) createClientCommands(
+	context *operatorcontext.Context,
+	crypto *crypto.CryptoService,
+	clock clockwork.Clock,
+	profile string,
+	authority string,
+) ([]Command, error) {
+	req := s.buildApiReq(context)
+	jwks, err := crypto.CreateJwks(s.AppId, s.getNotAfter(clock))
+	if err != nil {
+		return nil, err
+	}
+	publicJwks, err := jwks.ToPublic()
+	if err != nil {
+		return nil, err
+	}
+	apiState := &ApiState{
+		Profile:  profile,
+		ClientId: "", // don't know yet
+		Req:      req,
+		Jwks:     publicJwks,
+	}
+	secretStateContent := &SecretStateContent{
+		ClientId:  "", // set via the callback below
+		Authority: authority,
+		Profile:   profile,
+		Jwks:      jwks,
+		Jwk:       jwks.Keys[0],
+	}
+	return []Command{
+		&CreateClientInApiCommand{
+			Api: apiState,
+			Callback: func(resp *CreateClientInApiCommandResponse) error {
+				apiState.ClientId = resp.Resp.ClientId
+				secretStateContent.ClientId = resp.Resp.ClientId
+				return nil
+			},
+		},
+		&UpdateSecretContentCommand{
+			Previous:      s.Secret.Content,
+			SecretContent: secretStateContent,
+		},
+	}, nil
+}
+
+// reuseClientCommands takes over `MovingTo` with a fresh JWKS, since the private keys of the client were never stored
+func (s *ClientState) reuseClientCommands(
+	context *operatorcontext.Context,
+	crypto *crypto.CryptoService,
+	clock clockwork.Clock,
+	profile string,
+	authority string,
+) ([]Command, error) {
+	jwks, err := crypto.CreateJwks(s.AppId, s.getNotAfter(clock))
+	if err != nil {
+		return nil, err
+	}
+	publicJwks, err := jwks.ToPublic()
+	if err != nil {
+		return nil, err
+	}
+	return []Command{
+		&UpdateClientInApiCommand{
+			Previous: s.MovingTo,
+			Api: &ApiState{
+				Profile:  profile,
+				ClientId: s.MovingTo.ClientId,
+				Req:      s.buildApiReq(context),
+				Jwks:     publicJwks,
+			},
+		},
+		&UpdateSecretContentCommand{
+			Previous: s.Secret.Content,
+			SecretContent: &SecretStateContent{
+				ClientId:  s.MovingTo.ClientId,
+				Authority: authority,
+				Profile:   profile,
+				Jwks:      jwks,
+				Jwk:       jwks.Keys[0],
+			},
+		},
+	}, nil
+}
+
+// adoptClientCommands takes over a client registered outside the operator. The client is renamed
+// to the operator convention and gets a fresh JWKS, since the private keys of the client are unknown.
+func (s *ClientState) adoptClientCommands(
+	context *operatorcontext.Context,
+	crypto *crypto.CryptoService,
+	clock clockwork.Clock,
+	profile string,
+	authority string,
+) ([]Command, error) {
+	clientOrgNo := ""
+	if s.Api.Req.ClientOrgno != nil {
+		clientOrgNo = *s.Api.Req.ClientOrgno
+	}
+	if clientOrgNo != context.ServiceOwnerOrgNo {
+		return nil, errors.Errorf(
+			"can't adopt client %s owned by org '%s', expected '%s'",
+			s.Api.ClientId, clientOrgNo, context.ServiceOwnerOrgNo,
+		)
+	}
+	if s.Api.Req.ClientName != nil {
+		clientName := *s.Api.Req.ClientName
+		if strings.HasPrefix(clientName, getClientNamePrefix(context)) && clientName != GetClientName(context, s.AppId) {
+			return nil, errors.Errorf("can't adopt client %s, it is managed for another app: %s", s.Api.ClientId, clientName)
+		}
+	}
+
+	jwks, err := crypto.CreateJwks(s.AppId, s.getNotAfter(clock))
+	if err != nil {
+		return nil, err
+	}
+	publicJwks, err := jwks.ToPublic()
+	if err != nil {
+		return nil, err
+	}
+	return []Command{
+		&AdoptClientInApiCommand{
+			Previous: s.Api,
+			Api: &ApiState{
+				Profile:  profile,
+				ClientId: s.Api.ClientId,
+				Req:      s.buildApiReq(context),
+				Jwks:     nil, // uploaded by the next command
+			},
+		},
+		&UpdateClientInApiCommand{
+			Previous: s.Api,
+			Api: &ApiState{
+				Profile:  profile,
+				ClientId: s.Api.ClientId,
+				Req:      nil, // signals no update
+				Jwks:     publicJwks,
+			},
+		},
+		&UpdateSecretContentCommand{
+			Previous: s.Secret.Content,
+			SecretContent: &SecretStateContent{
+				ClientId:  s.Api.ClientId,
+				Authority: authority,
+				Profile:   profile,
+				Jwks:      jwks,
+				Jwk:       jwks.Keys[0],
+			},
+		},
+	}, nil
+}
+
+// deactivatedApiReq is the current client definition with the client deactivated,
+// since updates replace the whole definition
+func (s *ClientState) deactivatedApiReq() *AddClientRequest {
+	req := *s.Api.Req
+	active := false
+	req.Active = &active
+	return &req
+}
+
+// retainedApiReq marks the client as retained in its description. The marker is removed by `buildApiReq`
+// once a MaskinportenClient manages the client again, see `apiReqOutdated`
+func (s *ClientState) retainedApiReq() *AddClientRequest {
+	req := *s.Api.Req
+	description := RetainedClientMarker
+	if req.Description != nil {
+		description += *req.Description
+	}
+	req.Description = &description
+	return &req
+}
+
+// apiReqOutdated is true if the registered client definition differs from the one `buildApiReq` produces:
+// the scopes changed, or the client was deactivated or marked as retained when a previous resource was deleted
+func (s *ClientState) apiReqOutdated() bool {
+	inactive := s.Api.Req.Active != nil && !*s.Api.Req.Active
+	return !reflect.DeepEqual(s.Crd.Spec.Scopes, s.Api.Req.Scopes) || inactive || IsRetainedClient(s.Api.Req.Description)
+}
+
+// IsRetainedClient is true if the client description is marked by `DeletionPolicyRetain`
+func IsRetainedClient(description *string) bool {
+	return description != nil && strings.HasPrefix(*description, RetainedClientMarker)
+}
+
+func normalizeProfile(name string) string {
+	return config.NormalizeMaskinportenProfile(name)
+}
+
 func getClientNamePrefix(context *operatorcontext.Context) string {
 	return fmt.Sprintf("altinnoperator-%s-%s-", context.ServiceOwnerName, context.Environment)
 }
@@ -368,6 +679,7 @@ func (s *ClientState) buildApiReq(context *operatorcontext.Context) *AddClientRe
 	integrationType := IntegrationTypeMaskinporten
 	appType := ApplicationTypeWeb
 	tokenEndpointMethod := TokenEndpointAuthMethodPrivateKeyJwt
+	active := true
 	clientName := GetClientName(context, s.AppId)
 	description := fmt.Sprintf(
 		"Altinn Operator managed client for %s/%s/%s",
@@ -386,43 +698,10 @@ func (s *ClientState) buildApiReq(context *operatorcontext.Context) *AddClientRe
 		IntegrationType:         &integrationType,
 		ApplicationType:         &appType,
 		TokenEndpointAuthMethod: &tokenEndpointMethod,
+		Active:                  &active,
 	}
 }
 
-type Command struct {
-	Data     any
-	Callback func(respObj any) error
-}
-
-type CommandList []Command
-
-func (l CommandList) Strings() []string {
-	result := make([]string, len(l))
-	for i := 0; i < len(l); i++ {
-		result[i] = reflect.ValueOf(l[i].Data).Elem().Type().Name()
-	}
-
-	return result
-}
-
-type CreateClientInApiCommand struct {
-	Api *ApiState
-}
-type CreateClientInApiCommandResponse struct {
-	Resp *ClientResponse
-}
-type UpdateSecretContentCommand struct {
-	SecretContent *SecretStateContent
-}
-type UpdateClientInApiCommand struct {
-	Api *ApiState
-}
-type DeleteClientInApiCommand struct {
-	ClientId string
-}
-type DeleteSecretContentCommand struct {
-}
-
 func mapClientResponseToAddRequest(api *ClientResponse) *AddClientRequest {
 	grantTypes := make([]GrantType, len(api.GrantTypes))
 	copy(grantTypes, api.GrantTypes)
@@ -435,6 +714,7 @@ func mapClientResponseToAddRequest(api *ClientResponse) *AddClientRequest {
 		IntegrationType:         api.IntegrationType,
 		ApplicationType:         api.ApplicationType,
 		TokenEndpointAuthMethod: api.TokenEndpointAuthMethod,
+		Active:                  api.Active,
 	}
 	return req
 }
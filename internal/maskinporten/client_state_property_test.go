@@ -0,0 +1,484 @@
+package maskinporten
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	mathrand "math/rand/v2"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gkampitakis/go-snaps/snaps"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	resourcesv1alpha1 "github.com/altinn/altinn-k8s-operator/api/v1alpha1"
+	"github.com/altinn/altinn-k8s-operator/internal/config"
+	"github.com/altinn/altinn-k8s-operator/internal/crypto"
+	"github.com/altinn/altinn-k8s-operator/internal/operatorcontext"
+)
+
+// The tests in this file generate combinations of CRD, API and secret state, plan them with `ClientState.Reconcile`
+// and execute the plan against an in-memory model of the Maskinporten API and the app secret.
+// Every plan must uphold the invariants in `expectReconcileInvariants`.
+// Cases are generated from a fixed seed, so a failure is reproduced by running the test again.
+
+const reconcilePropertySeed = 20240101
+const reconcilePropertyCases = 300
+
+type apiVariant string
+
+const (
+	apiNone          apiVariant = "none"
+	apiInSync        apiVariant = "inSync"
+	apiScopesDrifted apiVariant = "scopesDrifted"
+	apiOtherProfile  apiVariant = "otherProfile"
+	apiDeactivated   apiVariant = "deactivated"
+	apiUnmanaged     apiVariant = "unmanaged"
+)
+
+type apiJwksVariant string
+
+const (
+	apiJwksMatching apiJwksVariant = "matching"
+	apiJwksForeign  apiJwksVariant = "foreign"
+	apiJwksMissing  apiJwksVariant = "missing"
+)
+
+type secretVariant string
+
+const (
+	secretNone           secretVariant = "none"
+	secretValid          secretVariant = "valid"
+	secretStaleAuthority secretVariant = "staleAuthority"
+	secretExpiringKeys   secretVariant = "expiringKeys"
+	secretLegacy         secretVariant = "legacy"
+)
+
+type reconcileScenario struct {
+	Deleting       bool
+	DeletionPolicy resourcesv1alpha1.DeletionPolicy
+	Scopes         []string
+	Profile        string
+	Api            apiVariant
+	ApiJwks        apiJwksVariant
+	Secret         secretVariant
+}
+
+// apiProfile is the normalized profile the client is currently registered in
+func (s reconcileScenario) apiProfile() string {
+	profile := normalizeProfile(s.Profile)
+	if s.Api != apiOtherProfile {
+		return profile
+	}
+	if profile == config.DefaultMaskinportenProfile {
+		return "ver2"
+	}
+	return config.DefaultMaskinportenProfile
+}
+
+func generateReconcileScenario(random *mathrand.Rand) reconcileScenario {
+	pick := func(n int) int { return random.IntN(n) }
+	deletionPolicies := []resourcesv1alpha1.DeletionPolicy{
+		"",
+		resourcesv1alpha1.DeletionPolicyDelete,
+		resourcesv1alpha1.DeletionPolicyRetain,
+		resourcesv1alpha1.DeletionPolicyDeactivate,
+	}
+	apiVariants := []apiVariant{apiNone, apiInSync, apiScopesDrifted, apiOtherProfile, apiDeactivated, apiUnmanaged}
+	apiJwksVariants := []apiJwksVariant{apiJwksMatching, apiJwksForeign, apiJwksMissing}
+	secretVariants := []secretVariant{secretNone, secretValid, secretStaleAuthority, secretExpiringKeys, secretLegacy}
+
+	scenario := reconcileScenario{
+		Deleting:       pick(4) == 0,
+		DeletionPolicy: deletionPolicies[pick(len(deletionPolicies))],
+		Profile:        []string{"", "ver2"}[pick(2)],
+		Api:            apiVariants[pick(len(apiVariants))],
+		ApiJwks:        apiJwksVariants[pick(len(apiJwksVariants))],
+		Secret:         secretVariants[pick(len(secretVariants))],
+	}
+	for _, scope := range []string{"altinn:a", "altinn:b", "altinn:c"} {
+		if pick(2) == 0 {
+			scenario.Scopes = append(scenario.Scopes, scope)
+		}
+	}
+
+	// Combinations the controller never observes, see `fetchCurrentState`
+	// * clients are only adopted before the secret is written, and not while deleting
+	// * the client is looked up in the profile of the secret, and in the desired profile without secret
+	// * secrets without profile were written for the default profile
+	if scenario.Api == apiUnmanaged && (scenario.Secret != secretNone || scenario.Deleting) {
+		scenario.Api = apiInSync
+	}
+	if scenario.Api == apiOtherProfile && scenario.Secret == secretNone {
+		scenario.Api = apiInSync
+	}
+	if scenario.Secret == secretLegacy && scenario.apiProfile() != config.DefaultMaskinportenProfile {
+		scenario.Secret = secretValid
+	}
+	return scenario
+}
+
+type reconcileFixture struct {
+	operatorContext *operatorcontext.Context
+	cfg             *config.Config
+	clock           clockwork.FakeClock
+	service         *crypto.CryptoService
+	// Created 25 days ago, due for rotation
+	expiringJwks *crypto.Jwks
+	validJwks    *crypto.Jwks
+	// Unknown to the secret, e.g. uploaded outside the operator
+	foreignJwks *crypto.Jwks
+}
+
+func newReconcileFixture(g *WithT) *reconcileFixture {
+	operatorContext, cfg := newProfileTestConfig()
+	clock := clockwork.NewFakeClockAt(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	// Key generation dominates the runtime, so smaller keys than the default are used
+	service := crypto.NewService(operatorContext, clock, rand.Reader, crypto.DefaultX509SignatureAlgo, 1024)
+	fixture := &reconcileFixture{
+		operatorContext: operatorContext,
+		cfg:             cfg,
+		clock:           clock,
+		service:         service,
+	}
+
+	var err error
+	fixture.expiringJwks, err = service.CreateJwks("app1", clock.Now().Add(30*24*time.Hour))
+	g.Expect(err).NotTo(HaveOccurred())
+	clock.Advance(25 * 24 * time.Hour)
+	fixture.validJwks, err = service.CreateJwks("app1", clock.Now().Add(30*24*time.Hour))
+	g.Expect(err).NotTo(HaveOccurred())
+	fixture.foreignJwks, err = service.CreateJwks("app1", clock.Now().Add(30*24*time.Hour))
+	g.Expect(err).NotTo(HaveOccurred())
+	return fixture
+}
+
+func (f *reconcileFixture) authority(g *WithT, profile string) string {
+	apiConfig, err := f.cfg.MaskinportenProfile(profile)
+	g.Expect(err).NotTo(HaveOccurred())
+	return apiConfig.AuthorityUrl
+}
+
+func (f *reconcileFixture) buildState(g *WithT, scenario reconcileScenario) *ClientState {
+	crd := &resourcesv1alpha1.MaskinportenClient{
+		ObjectMeta: metav1.ObjectMeta{Name: "ttd-app1", Namespace: "default"},
+		Spec: resourcesv1alpha1.MaskinportenClientSpec{
+			Scopes:         scenario.Scopes,
+			Profile:        scenario.Profile,
+			DeletionPolicy: scenario.DeletionPolicy,
+		},
+	}
+	if scenario.Deleting {
+		deletionTimestamp := metav1.NewTime(f.clock.Now())
+		crd.DeletionTimestamp = &deletionTimestamp
+	}
+	state := &ClientState{
+		AppId: "app1",
+		Crd:   crd,
+		Secret: SecretState{
+			Manifest: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "ttd-app1-secret", Namespace: "default"}},
+		},
+	}
+
+	apiProfile := scenario.apiProfile()
+	secretJwks := f.validJwks
+	if scenario.Secret == secretExpiringKeys {
+		secretJwks = f.expiringJwks
+	}
+
+	if scenario.Api != apiNone {
+		req := state.buildApiReq(f.operatorContext)
+		switch scenario.Api {
+		case apiScopesDrifted:
+			req.Scopes = append(slices.Clone(scenario.Scopes), "altinn:drifted")
+		case apiDeactivated:
+			active := false
+			req.Active = &active
+		case apiUnmanaged:
+			clientName := "Client registered by hand"
+			req.ClientName = &clientName
+		}
+
+		var apiJwks *crypto.Jwks
+		var err error
+		switch {
+		case scenario.Api == apiUnmanaged:
+			// The JWKS of unmanaged clients is not read
+		case scenario.ApiJwks == apiJwksMatching:
+			apiJwks, err = secretJwks.ToPublic()
+		case scenario.ApiJwks == apiJwksForeign:
+			apiJwks, err = f.foreignJwks.ToPublic()
+		}
+		g.Expect(err).NotTo(HaveOccurred())
+
+		state.Api = &ApiState{Profile: apiProfile, ClientId: "client-id", Req: req, Jwks: apiJwks}
+		state.Adopting = scenario.Api == apiUnmanaged
+	}
+
+	if scenario.Secret != secretNone {
+		content := &SecretStateContent{
+			ClientId:  "client-id",
+			Authority: f.authority(g, apiProfile),
+			Profile:   apiProfile,
+			Jwks:      secretJwks,
+			Jwk:       secretJwks.Keys[0],
+		}
+		if state.Api == nil {
+			// The client was deleted outside the operator
+			content.ClientId = "deleted-client-id"
+		}
+		switch scenario.Secret {
+		case secretStaleAuthority:
+			content.Authority = "https://stale.example.com"
+		case secretLegacy:
+			content.Profile = ""
+		}
+		state.Secret.Content = content
+	}
+
+	return state
+}
+
+// reconcileModel is the state of the Maskinporten API and the app secret, as changed by executed commands
+type reconcileModel struct {
+	clients        map[string]*ApiState
+	secret         *SecretStateContent
+	createdClients int
+}
+
+func newReconcileModel(state *ClientState) *reconcileModel {
+	model := &reconcileModel{clients: map[string]*ApiState{}, secret: state.Secret.Content}
+	if state.Api != nil {
+		api := *state.Api
+		model.clients[api.ClientId] = &api
+	}
+	return model
+}
+
+func (m *reconcileModel) execute(g *WithT, commands CommandList) {
+	for _, cmd := range commands {
+		switch cmd := cmd.(type) {
+		case *CreateClientInApiCommand:
+			expectPublicJwks(g, cmd.Api.Jwks)
+			m.createdClients++
+			clientId := fmt.Sprintf("created-client-id-%d", m.createdClients)
+			g.Expect(cmd.Callback(&CreateClientInApiCommandResponse{Resp: &ClientResponse{ClientId: clientId}})).To(Succeed())
+			m.clients[clientId] = &ApiState{Profile: cmd.Api.Profile, ClientId: clientId, Req: cmd.Api.Req, Jwks: cmd.Api.Jwks}
+		case *UpdateClientInApiCommand:
+			client := m.clients[cmd.Api.ClientId]
+			g.Expect(client).NotTo(BeNil(), "updated client should exist")
+			g.Expect(client.Profile).To(Equal(cmd.Api.Profile))
+			if cmd.Api.Req != nil {
+				client.Req = cmd.Api.Req
+			}
+			if cmd.Api.Jwks != nil {
+				expectPublicJwks(g, cmd.Api.Jwks)
+				client.Jwks = cmd.Api.Jwks
+			}
+		case *AdoptClientInApiCommand:
+			client := m.clients[cmd.Api.ClientId]
+			g.Expect(client).NotTo(BeNil(), "adopted client should exist")
+			g.Expect(cmd.Api.Jwks).To(BeNil(), "keys are uploaded separately")
+			client.Req = cmd.Api.Req
+		case *DeleteClientInApiCommand:
+			client := m.clients[cmd.ClientId]
+			g.Expect(client).NotTo(BeNil(), "deleted client should exist")
+			g.Expect(client.Profile).To(Equal(cmd.Profile))
+			delete(m.clients, cmd.ClientId)
+		case *UpdateSecretContentCommand:
+			g.Expect(cmd.SecretContent.ClientId).NotTo(BeEmpty(), "secret should be written after the client is created")
+			m.secret = cmd.SecretContent
+		case *DeleteSecretContentCommand:
+			m.secret = nil
+		default:
+			g.Expect(cmd).To(BeNil(), "unknown command")
+		}
+	}
+}
+
+// nextState is the state observed by the next reconciliation
+func (m *reconcileModel) nextState(state *ClientState) *ClientState {
+	next := &ClientState{
+		AppId:  state.AppId,
+		Crd:    state.Crd,
+		Secret: SecretState{Manifest: state.Secret.Manifest, Content: m.secret},
+	}
+	if m.secret != nil {
+		next.Api = m.clients[m.secret.ClientId]
+	}
+	return next
+}
+
+func expectPublicJwks(g *WithT, jwks *crypto.Jwks) {
+	g.Expect(jwks).NotTo(BeNil())
+	for _, key := range jwks.Keys {
+		g.Expect(key.IsPublic()).To(BeTrue(), "private key %s should never be uploaded", key.KeyID())
+	}
+}
+
+func expectReconcileInvariants(
+	g *WithT,
+	f *reconcileFixture,
+	scenario reconcileScenario,
+	state *ClientState,
+	model *reconcileModel,
+) {
+	if scenario.Deleting {
+		g.Expect(model.secret).To(BeNil(), "secret content should be removed")
+		g.Expect(model.createdClients).To(BeZero())
+		if state.Api == nil {
+			g.Expect(model.clients).To(BeEmpty())
+			return
+		}
+		switch scenario.DeletionPolicy {
+		case resourcesv1alpha1.DeletionPolicyRetain:
+			g.Expect(model.clients).To(HaveKey(state.Api.ClientId))
+			g.Expect(IsRetainedClient(model.clients[state.Api.ClientId].Req.Description)).To(BeTrue())
+			g.Expect(model.clients[state.Api.ClientId].Req.Active).To(Equal(state.Api.Req.Active))
+		case resourcesv1alpha1.DeletionPolicyDeactivate:
+			g.Expect(model.clients).To(HaveKey(state.Api.ClientId))
+			active := model.clients[state.Api.ClientId].Req.Active
+			g.Expect(active).NotTo(BeNil())
+			g.Expect(*active).To(BeFalse())
+		default:
+			g.Expect(model.clients).To(BeEmpty())
+		}
+		return
+	}
+
+	profile := normalizeProfile(scenario.Profile)
+	secret := model.secret
+	g.Expect(secret).NotTo(BeNil(), "secret content should be written")
+
+	// The secret refers to the only client, registered in the desired profile
+	g.Expect(model.clients).To(HaveLen(1))
+	client := model.clients[secret.ClientId]
+	g.Expect(client).NotTo(BeNil(), "client ID of the secret should match the API")
+	g.Expect(client.Profile).To(Equal(profile))
+	g.Expect(secret.Profile).To(Equal(profile))
+	g.Expect(secret.Authority).To(Equal(f.authority(g, profile)))
+	g.Expect(*client.Req.ClientName).To(Equal(GetClientName(f.operatorContext, state.AppId)))
+	g.Expect(client.Req.Scopes).To(Equal(scenario.Scopes))
+
+	// A key valid beyond the rotation threshold is always kept
+	g.Expect(secret.Jwks).NotTo(BeNil())
+	g.Expect(secret.Jwk).To(BeIdenticalTo(secret.Jwks.Keys[0]))
+	rotationThreshold := f.clock.Now().Add(7 * 24 * time.Hour)
+	g.Expect(secret.Jwk.Certificates()[0].NotAfter).To(BeTemporally(">", rotationThreshold))
+
+	previous := state.Secret.Content
+	if previous == nil || !slices.Equal(keyIds(previous.Jwks), keyIds(secret.Jwks)) {
+		// New keys are uploaded along with the secret
+		g.Expect(keyIds(client.Jwks)).To(Equal(keyIds(secret.Jwks)))
+		if previous != nil && previous.ClientId == secret.ClientId {
+			// Tokens signed with the previous key stay valid while apps pick up the rotated key
+			g.Expect(keyIds(secret.Jwks)).To(ContainElement(previous.Jwk.KeyID()))
+		}
+	}
+}
+
+func TestReconcileInvariants(t *testing.T) {
+	g := NewWithT(t)
+	fixture := newReconcileFixture(g)
+	random := mathrand.New(mathrand.NewPCG(reconcilePropertySeed, reconcilePropertySeed))
+
+	for i := 0; i < reconcilePropertyCases; i++ {
+		scenario := generateReconcileScenario(random)
+		t.Run(fmt.Sprintf("case-%d", i), func(t *testing.T) {
+			g := NewWithT(t)
+			t.Logf("scenario: %+v", scenario)
+
+			state := fixture.buildState(g, scenario)
+			commands, err := state.Reconcile(fixture.operatorContext, fixture.cfg, fixture.service, fixture.clock)
+			g.Expect(err).NotTo(HaveOccurred())
+
+			model := newReconcileModel(state)
+			model.execute(g, commands)
+			expectReconcileInvariants(g, fixture, scenario, state, model)
+
+			if !scenario.Deleting {
+				// Reconciliation converges, the next plan is empty
+				next := model.nextState(state)
+				commands, err := next.Reconcile(fixture.operatorContext, fixture.cfg, fixture.service, fixture.clock)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(commands.Strings()).To(BeEmpty())
+			}
+		})
+	}
+}
+
+var keyIdPattern = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+
+// normalizeKeyIds replaces the random part of key IDs, in order of appearance
+func normalizeKeyIds(data []byte) []byte {
+	seen := map[string]string{}
+	return keyIdPattern.ReplaceAllFunc(data, func(id []byte) []byte {
+		replacement, ok := seen[string(id)]
+		if !ok {
+			replacement = fmt.Sprintf("key%d", len(seen)+1)
+			seen[string(id)] = replacement
+		}
+		return []byte(replacement)
+	})
+}
+
+func TestReconcilePlanSnapshots(t *testing.T) {
+	g := NewWithT(t)
+	fixture := newReconcileFixture(g)
+	scopes := []string{"altinn:a"}
+
+	scenarios := []struct {
+		name     string
+		scenario reconcileScenario
+	}{
+		{"create", reconcileScenario{Scopes: scopes, Api: apiNone, Secret: secretNone}},
+		{"in sync", reconcileScenario{Scopes: scopes, Api: apiInSync, ApiJwks: apiJwksMatching, Secret: secretValid}},
+		{"scopes drifted", reconcileScenario{
+			Scopes: scopes, Api: apiScopesDrifted, ApiJwks: apiJwksMatching, Secret: secretValid,
+		}},
+		{"stale authority", reconcileScenario{
+			Scopes: scopes, Api: apiInSync, ApiJwks: apiJwksMatching, Secret: secretStaleAuthority,
+		}},
+		{"expiring keys", reconcileScenario{
+			Scopes: scopes, Api: apiInSync, ApiJwks: apiJwksMatching, Secret: secretExpiringKeys,
+		}},
+		{"legacy secret", reconcileScenario{Scopes: scopes, Api: apiInSync, ApiJwks: apiJwksMatching, Secret: secretLegacy}},
+		{"secret lost", reconcileScenario{Scopes: scopes, Api: apiInSync, ApiJwks: apiJwksForeign, Secret: secretNone}},
+		{"client lost", reconcileScenario{Scopes: scopes, Api: apiNone, Secret: secretValid}},
+		{"move profile", reconcileScenario{
+			Scopes: scopes, Profile: "ver2", Api: apiOtherProfile, ApiJwks: apiJwksMatching, Secret: secretValid,
+		}},
+		{"adopt", reconcileScenario{Scopes: scopes, Api: apiUnmanaged, Secret: secretNone}},
+		{"delete", reconcileScenario{Deleting: true, Api: apiInSync, ApiJwks: apiJwksMatching, Secret: secretValid}},
+		{"delete and retain", reconcileScenario{
+			Deleting: true, DeletionPolicy: resourcesv1alpha1.DeletionPolicyRetain,
+			Api: apiInSync, ApiJwks: apiJwksMatching, Secret: secretValid,
+		}},
+		{"delete and deactivate", reconcileScenario{
+			Deleting: true, DeletionPolicy: resourcesv1alpha1.DeletionPolicyDeactivate,
+			Api: apiInSync, ApiJwks: apiJwksMatching, Secret: secretValid,
+		}},
+		{"delete deactivated", reconcileScenario{
+			Deleting: true, DeletionPolicy: resourcesv1alpha1.DeletionPolicyDeactivate,
+			Api: apiDeactivated, ApiJwks: apiJwksMatching, Secret: secretValid,
+		}},
+	}
+
+	for _, tc := range scenarios {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			state := fixture.buildState(g, tc.scenario)
+			commands, err := state.Reconcile(fixture.operatorContext, fixture.cfg, fixture.service, fixture.clock)
+			g.Expect(err).NotTo(HaveOccurred())
+
+			plan, err := json.Marshal(commands.Describe())
+			g.Expect(err).NotTo(HaveOccurred())
+			snaps.MatchJSON(t, normalizeKeyIds(plan))
+		})
+	}
+}
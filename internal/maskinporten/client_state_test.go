@@ -0,0 +1,439 @@
+package maskinporten
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	resourcesv1alpha1 "github.com/altinn/altinn-k8s-operator/api/v1alpha1"
+	"github.com/altinn/altinn-k8s-operator/internal/config"
+	"github.com/altinn/altinn-k8s-operator/internal/crypto"
+	"github.com/altinn/altinn-k8s-operator/internal/operatorcontext"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newProfileTestConfig() (*operatorcontext.Context, *config.Config) {
+	operatorContext := operatorcontext.DiscoverOrDie(context.Background())
+	cfg := config.GetConfigOrDie(operatorContext, config.ConfigSourceDefault, "")
+	profile := cfg.MaskinportenApi
+	profile.AuthorityUrl = "http://localhost:8060"
+	cfg.MaskinportenProfiles = map[string]config.MaskinportenApiConfig{"ver2": profile}
+	return operatorContext, cfg
+}
+
+func newProfileTestState(
+	g *WithT,
+	operatorContext *operatorcontext.Context,
+	cfg *config.Config,
+	service *crypto.CryptoService,
+	clock clockwork.Clock,
+	specProfile string,
+	apiProfile string,
+) *ClientState {
+	crd := &resourcesv1alpha1.MaskinportenClient{
+		ObjectMeta: metav1.ObjectMeta{Name: "ttd-app1", Namespace: "default"},
+		Spec:       resourcesv1alpha1.MaskinportenClientSpec{Scopes: []string{"scope"}, Profile: specProfile},
+	}
+	apiConfig, err := cfg.MaskinportenProfile(apiProfile)
+	g.Expect(err).NotTo(HaveOccurred())
+	jwks, err := service.CreateJwks("app1", clock.Now().Add(30*24*time.Hour))
+	g.Expect(err).NotTo(HaveOccurred())
+	publicJwks, err := jwks.ToPublic()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	state := &ClientState{AppId: "app1", Crd: crd}
+	state.Api = &ApiState{
+		Profile:  config.NormalizeMaskinportenProfile(apiProfile),
+		ClientId: "client-id",
+		Req:      state.buildApiReq(operatorContext),
+		Jwks:     publicJwks,
+	}
+	state.Secret = SecretState{
+		Manifest: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "ttd-app1-secret", Namespace: "default"}},
+		Content: &SecretStateContent{
+			ClientId:  "client-id",
+			Authority: apiConfig.AuthorityUrl,
+			Profile:   config.NormalizeMaskinportenProfile(apiProfile),
+			Jwks:      jwks,
+			Jwk:       jwks.Keys[0],
+		},
+	}
+	return state
+}
+
+func TestReconcileIsStableWithinProfile(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext, cfg := newProfileTestConfig()
+	clock := clockwork.NewFakeClock()
+	service := crypto.NewDefaultService(operatorContext, clock, rand.Reader)
+
+	for _, profile := range []string{"", "ver2"} {
+		state := newProfileTestState(g, operatorContext, cfg, service, clock, profile, profile)
+		commands, err := state.Reconcile(operatorContext, cfg, service, clock)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(commands).To(BeEmpty())
+	}
+}
+
+func TestReconcileMovesClientToNewProfile(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext, cfg := newProfileTestConfig()
+	clock := clockwork.NewFakeClock()
+	service := crypto.NewDefaultService(operatorContext, clock, rand.Reader)
+	state := newProfileTestState(g, operatorContext, cfg, service, clock, "ver2", "")
+
+	commands, err := state.Reconcile(operatorContext, cfg, service, clock)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(commands.Strings()).To(Equal([]string{
+		"CreateClientInApiCommand",
+		"UpdateSecretContentCommand",
+		"DeleteClientInApiCommand",
+	}))
+
+	create := commands[0].(*CreateClientInApiCommand)
+	g.Expect(create.Api.Profile).To(Equal("ver2"))
+	g.Expect(create.Callback(&CreateClientInApiCommandResponse{Resp: &ClientResponse{ClientId: "new-client-id"}})).To(Succeed())
+
+	update := commands[1].(*UpdateSecretContentCommand)
+	g.Expect(update.SecretContent.ClientId).To(Equal("new-client-id"))
+	g.Expect(update.SecretContent.Profile).To(Equal("ver2"))
+	g.Expect(update.SecretContent.Authority).To(Equal("http://localhost:8060"))
+
+	// The previous client is deleted from the profile it was registered in
+	del := commands[2].(*DeleteClientInApiCommand)
+	g.Expect(del.ClientId).To(Equal("client-id"))
+	g.Expect(del.Profile).To(Equal(config.DefaultMaskinportenProfile))
+}
+
+func TestReconcileReusesClientLeftInNewProfile(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext, cfg := newProfileTestConfig()
+	clock := clockwork.NewFakeClock()
+	service := crypto.NewDefaultService(operatorContext, clock, rand.Reader)
+	state := newProfileTestState(g, operatorContext, cfg, service, clock, "ver2", "")
+	state.MovingTo = &ApiState{Profile: "ver2", ClientId: "leftover-client-id", Req: state.buildApiReq(operatorContext)}
+
+	commands, err := state.Reconcile(operatorContext, cfg, service, clock)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(commands.Strings()).To(Equal([]string{
+		"UpdateClientInApiCommand",
+		"UpdateSecretContentCommand",
+		"DeleteClientInApiCommand",
+	}))
+
+	// The client gets the keys written to the secret
+	reuse := commands[0].(*UpdateClientInApiCommand)
+	g.Expect(reuse.Api.Profile).To(Equal("ver2"))
+	g.Expect(reuse.Api.ClientId).To(Equal("leftover-client-id"))
+	update := commands[1].(*UpdateSecretContentCommand)
+	g.Expect(update.SecretContent.ClientId).To(Equal("leftover-client-id"))
+	g.Expect(update.SecretContent.Profile).To(Equal("ver2"))
+	g.Expect(reuse.Api.Jwks.Keys[0].KeyID()).To(Equal(update.SecretContent.Jwk.KeyID()))
+	g.Expect(reuse.Api.Jwks.Keys[0].IsPublic()).To(BeTrue())
+
+	del := commands[2].(*DeleteClientInApiCommand)
+	g.Expect(del.ClientId).To(Equal("client-id"))
+	g.Expect(del.Profile).To(Equal(config.DefaultMaskinportenProfile))
+}
+
+func TestReconcileRecordsProfileOfLegacySecret(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext, cfg := newProfileTestConfig()
+	clock := clockwork.NewFakeClock()
+	service := crypto.NewDefaultService(operatorContext, clock, rand.Reader)
+	state := newProfileTestState(g, operatorContext, cfg, service, clock, "", "")
+	state.Secret.Content.Profile = ""
+
+	commands, err := state.Reconcile(operatorContext, cfg, service, clock)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(commands.Strings()).To(Equal([]string{"UpdateSecretContentCommand"}))
+	update := commands[0].(*UpdateSecretContentCommand)
+	g.Expect(update.SecretContent.Profile).To(Equal(config.DefaultMaskinportenProfile))
+	g.Expect(update.SecretContent.Jwks).To(BeIdenticalTo(state.Secret.Content.Jwks))
+}
+
+func TestReconcileFailsForUnknownProfile(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext, cfg := newProfileTestConfig()
+	clock := clockwork.NewFakeClock()
+	service := crypto.NewDefaultService(operatorContext, clock, rand.Reader)
+	state := newProfileTestState(g, operatorContext, cfg, service, clock, "prod", "")
+
+	_, err := state.Reconcile(operatorContext, cfg, service, clock)
+	g.Expect(err).To(MatchError(ContainSubstring("unknown Maskinporten profile")))
+
+	// Deletion doesn't depend on the profile being configured
+	now := metav1.Now()
+	state.Crd.DeletionTimestamp = &now
+	commands, err := state.Reconcile(operatorContext, cfg, service, clock)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(commands.Strings()).To(Equal([]string{"DeleteSecretContentCommand", "DeleteClientInApiCommand"}))
+}
+
+func newAdoptTestState(
+	g *WithT,
+	operatorContext *operatorcontext.Context,
+	cfg *config.Config,
+	service *crypto.CryptoService,
+	clock clockwork.Clock,
+	clientName string,
+	orgNo string,
+) *ClientState {
+	state := newProfileTestState(g, operatorContext, cfg, service, clock, "", "")
+	state.Api.Req.ClientName = &clientName
+	state.Api.Req.ClientOrgno = &orgNo
+	state.Api.Req.Scopes = []string{"hand-made:scope"}
+	state.Api.Jwks = nil
+	state.Secret.Content = nil
+	state.Adopting = true
+	return state
+}
+
+func TestReconcileAdoptsExistingClient(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext, cfg := newProfileTestConfig()
+	clock := clockwork.NewFakeClock()
+	service := crypto.NewDefaultService(operatorContext, clock, rand.Reader)
+	state := newAdoptTestState(g, operatorContext, cfg, service, clock, "hand-made-client", operatorContext.ServiceOwnerOrgNo)
+
+	commands, err := state.Reconcile(operatorContext, cfg, service, clock)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(commands.Strings()).To(Equal([]string{
+		"AdoptClientInApiCommand",
+		"UpdateClientInApiCommand",
+		"UpdateSecretContentCommand",
+	}))
+
+	adopt := commands[0].(*AdoptClientInApiCommand)
+	g.Expect(adopt.Api.ClientId).To(Equal("client-id"))
+	g.Expect(*adopt.Api.Req.ClientName).To(Equal(GetClientName(operatorContext, "app1")))
+	g.Expect(adopt.Api.Req.Scopes).To(Equal([]string{"scope"}))
+	g.Expect(adopt.Api.Jwks).To(BeNil())
+
+	// Keys are uploaded after the adoption, which is recorded in the status even if the upload fails
+	keys := commands[1].(*UpdateClientInApiCommand)
+	g.Expect(keys.Api.ClientId).To(Equal("client-id"))
+	g.Expect(keys.Api.Req).To(BeNil())
+	g.Expect(keys.Api.Jwks.Keys).To(HaveLen(1))
+	g.Expect(keys.Api.Jwks.Keys[0].IsPublic()).To(BeTrue())
+
+	update := commands[2].(*UpdateSecretContentCommand)
+	g.Expect(update.SecretContent.ClientId).To(Equal("client-id"))
+	g.Expect(update.SecretContent.Jwk.KeyID()).To(Equal(keys.Api.Jwks.Keys[0].KeyID()))
+}
+
+func TestReconcileRefusesToAdoptForeignClients(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext, cfg := newProfileTestConfig()
+	clock := clockwork.NewFakeClock()
+	service := crypto.NewDefaultService(operatorContext, clock, rand.Reader)
+
+	state := newAdoptTestState(g, operatorContext, cfg, service, clock, "hand-made-client", "123456789")
+	_, err := state.Reconcile(operatorContext, cfg, service, clock)
+	g.Expect(err).To(MatchError(ContainSubstring("owned by org '123456789'")))
+
+	otherApp := GetClientName(operatorContext, "app2")
+	state = newAdoptTestState(g, operatorContext, cfg, service, clock, otherApp, operatorContext.ServiceOwnerOrgNo)
+	_, err = state.Reconcile(operatorContext, cfg, service, clock)
+	g.Expect(err).To(MatchError(ContainSubstring("managed for another app")))
+}
+
+func TestReconcileRespectsDeletionPolicy(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext, cfg := newProfileTestConfig()
+	clock := clockwork.NewFakeClock()
+	service := crypto.NewDefaultService(operatorContext, clock, rand.Reader)
+
+	cases := map[resourcesv1alpha1.DeletionPolicy][]string{
+		"":                                     {"DeleteSecretContentCommand", "DeleteClientInApiCommand"},
+		resourcesv1alpha1.DeletionPolicyDelete: {"DeleteSecretContentCommand", "DeleteClientInApiCommand"},
+		resourcesv1alpha1.DeletionPolicyRetain: {"DeleteSecretContentCommand", "UpdateClientInApiCommand"},
+		resourcesv1alpha1.DeletionPolicyDeactivate: {"DeleteSecretContentCommand", "UpdateClientInApiCommand"},
+	}
+	for policy, expected := range cases {
+		state := newProfileTestState(g, operatorContext, cfg, service, clock, "", "")
+		now := metav1.Now()
+		state.Crd.DeletionTimestamp = &now
+		state.Crd.Spec.DeletionPolicy = policy
+
+		commands, err := state.Reconcile(operatorContext, cfg, service, clock)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(commands.Strings()).To(Equal(expected), "policy %q", policy)
+	}
+}
+
+func TestReconcileMarksRetainedClientOnce(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext, cfg := newProfileTestConfig()
+	clock := clockwork.NewFakeClock()
+	service := crypto.NewDefaultService(operatorContext, clock, rand.Reader)
+	state := newProfileTestState(g, operatorContext, cfg, service, clock, "", "")
+	now := metav1.Now()
+	state.Crd.DeletionTimestamp = &now
+	state.Crd.Spec.DeletionPolicy = resourcesv1alpha1.DeletionPolicyRetain
+	state.Secret.Content = nil
+
+	commands, err := state.Reconcile(operatorContext, cfg, service, clock)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(commands.Strings()).To(Equal([]string{"UpdateClientInApiCommand"}))
+	update := commands[0].(*UpdateClientInApiCommand)
+	g.Expect(IsRetainedClient(update.Api.Req.Description)).To(BeTrue())
+	g.Expect(*update.Api.Req.Active).To(BeTrue())
+	g.Expect(update.Api.Jwks).To(BeNil())
+	g.Expect(IsRetainedClient(state.Api.Req.Description)).To(BeFalse())
+
+	state.Api.Req = update.Api.Req
+	commands, err = state.Reconcile(operatorContext, cfg, service, clock)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(commands).To(BeEmpty())
+}
+
+func TestReconcileDeactivatesClientOnce(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext, cfg := newProfileTestConfig()
+	clock := clockwork.NewFakeClock()
+	service := crypto.NewDefaultService(operatorContext, clock, rand.Reader)
+	state := newProfileTestState(g, operatorContext, cfg, service, clock, "", "")
+	now := metav1.Now()
+	state.Crd.DeletionTimestamp = &now
+	state.Crd.Spec.DeletionPolicy = resourcesv1alpha1.DeletionPolicyDeactivate
+	state.Secret.Content = nil
+
+	commands, err := state.Reconcile(operatorContext, cfg, service, clock)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(commands.Strings()).To(Equal([]string{"UpdateClientInApiCommand"}))
+	update := commands[0].(*UpdateClientInApiCommand)
+	g.Expect(update.Api.ClientId).To(Equal("client-id"))
+	g.Expect(*update.Api.Req.Active).To(BeFalse())
+	g.Expect(update.Api.Req.Scopes).To(Equal(state.Api.Req.Scopes))
+	g.Expect(update.Api.Jwks).To(BeNil())
+	g.Expect(*state.Api.Req.Active).To(BeTrue())
+
+	state.Api.Req = update.Api.Req
+	commands, err = state.Reconcile(operatorContext, cfg, service, clock)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(commands).To(BeEmpty())
+}
+
+func TestReconcileUpdatesScopesWhenSecretIsLost(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext, cfg := newProfileTestConfig()
+	clock := clockwork.NewFakeClock()
+	service := crypto.NewDefaultService(operatorContext, clock, rand.Reader)
+
+	// Without scope changes only the keys are replaced
+	state := newProfileTestState(g, operatorContext, cfg, service, clock, "", "")
+	state.Secret.Content = nil
+	commands, err := state.Reconcile(operatorContext, cfg, service, clock)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(commands.Strings()).To(Equal([]string{"UpdateClientInApiCommand", "UpdateSecretContentCommand"}))
+	update := commands[0].(*UpdateClientInApiCommand)
+	g.Expect(update.Api.Req).To(BeNil())
+	g.Expect(update.Api.Jwks).NotTo(BeNil())
+
+	// Scopes changed while the secret was missing are sent along with the new keys
+	state = newProfileTestState(g, operatorContext, cfg, service, clock, "", "")
+	state.Secret.Content = nil
+	state.Crd.Spec.Scopes = []string{"scope", "other-scope"}
+	commands, err = state.Reconcile(operatorContext, cfg, service, clock)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(commands.Strings()).To(Equal([]string{"UpdateClientInApiCommand", "UpdateSecretContentCommand"}))
+	update = commands[0].(*UpdateClientInApiCommand)
+	g.Expect(update.Api.Req.Scopes).To(Equal([]string{"scope", "other-scope"}))
+	g.Expect(update.Api.Jwks).NotTo(BeNil())
+}
+
+func TestReconcileReplacesKeysOfUnconfiguredKeyStore(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext, cfg := newProfileTestConfig()
+	clock := clockwork.NewFakeClock()
+	service := crypto.NewDefaultService(operatorContext, clock, rand.Reader)
+	state := newProfileTestState(g, operatorContext, cfg, service, clock, "", "")
+	// Keys were kept in Azure Key Vault before switching back to the secret backend
+	refs := []crypto.KeyRef{{KeyID: state.Secret.Content.Jwk.KeyID(), Uri: "https://vault.azure.net/secrets/app1/1"}}
+	state.Secret.Content = &SecretStateContent{
+		ClientId:  state.Secret.Content.ClientId,
+		Authority: state.Secret.Content.Authority,
+		Profile:   state.Secret.Content.Profile,
+		KeyRefs:   refs,
+	}
+
+	g.Expect(state.Secret.Content.LoadKeys(context.Background(), crypto.NewSecretKeyStore())).To(Succeed())
+	g.Expect(state.Secret.Content.Jwks).To(BeNil())
+
+	commands, err := state.Reconcile(operatorContext, cfg, service, clock)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(commands.Strings()).To(Equal([]string{"UpdateClientInApiCommand", "UpdateSecretContentCommand"}))
+	update := commands[1].(*UpdateSecretContentCommand)
+	g.Expect(update.SecretContent.ClientId).To(Equal("client-id"))
+	g.Expect(update.SecretContent.Jwks.Keys).To(HaveLen(1))
+	g.Expect(update.SecretContent.KeyRefs).To(BeEmpty())
+	g.Expect(state.Secret.Content.StaleKeyRefs(update.SecretContent)).To(Equal(refs))
+}
+
+func TestReconcileReactivatesClientOfRecreatedResource(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext, cfg := newProfileTestConfig()
+	clock := clockwork.NewFakeClock()
+	service := crypto.NewDefaultService(operatorContext, clock, rand.Reader)
+
+	for _, policy := range []resourcesv1alpha1.DeletionPolicy{
+		resourcesv1alpha1.DeletionPolicyDeactivate,
+		resourcesv1alpha1.DeletionPolicyRetain,
+	} {
+		state := newProfileTestState(g, operatorContext, cfg, service, clock, "", "")
+		now := metav1.Now()
+		state.Crd.DeletionTimestamp = &now
+		state.Crd.Spec.DeletionPolicy = policy
+		commands, err := state.Reconcile(operatorContext, cfg, service, clock)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(commands.Strings()).To(ContainElement("UpdateClientInApiCommand"), "policy %q", policy)
+		deleted := commands[len(commands)-1].(*UpdateClientInApiCommand).Api.Req
+
+		// A resource with the same name finds the client by name, the secret content was deleted with the resource
+		for _, secretLost := range []bool{true, false} {
+			recreated := newProfileTestState(g, operatorContext, cfg, service, clock, "", "")
+			recreated.Api.Req = deleted
+			if secretLost {
+				recreated.Secret.Content = nil
+			}
+			commands, err = recreated.Reconcile(operatorContext, cfg, service, clock)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(commands.Strings()).To(ContainElement("UpdateClientInApiCommand"), "policy %q", policy)
+			var update *UpdateClientInApiCommand
+			for _, cmd := range commands {
+				if cmd, ok := cmd.(*UpdateClientInApiCommand); ok && cmd.Api.Req != nil {
+					update = cmd
+				}
+			}
+			g.Expect(update).NotTo(BeNil(), "policy %q", policy)
+			g.Expect(*update.Api.Req.Active).To(BeTrue())
+			g.Expect(IsRetainedClient(update.Api.Req.Description)).To(BeFalse())
+
+			recreated.Api.Req = update.Api.Req
+			if secretLost {
+				recreated.Secret.Content = commands[1].(*UpdateSecretContentCommand).SecretContent
+			}
+			commands, err = recreated.Reconcile(operatorContext, cfg, service, clock)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(commands).To(BeEmpty(), "policy %q", policy)
+		}
+	}
+}
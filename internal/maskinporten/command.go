@@ -0,0 +1,183 @@
+package maskinporten
+
+import (
+	"context"
+
+	resourcesv1alpha1 "github.com/altinn/altinn-k8s-operator/api/v1alpha1"
+)
+
+// Command is a step towards the desired state, as planned by `ClientState.Reconcile`.
+// Commands are only implemented in this package, so every command has to implement
+// execution, description and status handling - there is no fallback for unhandled commands.
+type Command interface {
+	// Execute performs the side effects of the command
+	Execute(ctx context.Context, executor Executor) error
+	// Describe returns what the command changes relative to the state it was planned from,
+	// e.g. which scopes are added and which keys are rotated
+	Describe() resourcesv1alpha1.ActionDescription
+	// ApplyStatus records the outcome of the executed command in the status of the MaskinportenClient
+	ApplyStatus(status *resourcesv1alpha1.MaskinportenClientStatus)
+
+	command()
+}
+
+var _ Command = (*CreateClientInApiCommand)(nil)
+var _ Command = (*UpdateClientInApiCommand)(nil)
+var _ Command = (*AdoptClientInApiCommand)(nil)
+var _ Command = (*UpdateSecretContentCommand)(nil)
+var _ Command = (*DeleteClientInApiCommand)(nil)
+var _ Command = (*DeleteSecretContentCommand)(nil)
+
+type CommandList []Command
+
+func (l CommandList) Strings() []string {
+	result := make([]string, len(l))
+	for i := 0; i < len(l); i++ {
+		result[i] = l[i].Describe().Action
+	}
+
+	return result
+}
+
+// The `Previous` fields of commands hold the state the command was planned from, used by `Describe`
+
+type CreateClientInApiCommand struct {
+	Api *ApiState
+	// Callback receives the created client, so that later commands can refer to the client ID
+	Callback func(resp *CreateClientInApiCommandResponse) error
+}
+type CreateClientInApiCommandResponse struct {
+	Resp *ClientResponse
+}
+type UpdateSecretContentCommand struct {
+	Previous      *SecretStateContent
+	SecretContent *SecretStateContent
+}
+type UpdateClientInApiCommand struct {
+	Previous *ApiState
+	Api      *ApiState
+}
+
+// AdoptClientInApiCommand replaces the definition of a client registered outside the operator.
+// The JWKS is replaced by a separate `UpdateClientInApiCommand`, so that the adoption is recorded
+// in the status even if uploading the keys fails
+type AdoptClientInApiCommand struct {
+	Previous *ApiState
+	Api      *ApiState
+}
+type DeleteClientInApiCommand struct {
+	Profile  string
+	ClientId string
+}
+type DeleteSecretContentCommand struct {
+	Previous *SecretStateContent
+}
+
+func (*CreateClientInApiCommand) command()   {}
+func (*UpdateClientInApiCommand) command()   {}
+func (*AdoptClientInApiCommand) command()    {}
+func (*UpdateSecretContentCommand) command() {}
+func (*DeleteClientInApiCommand) command()   {}
+func (*DeleteSecretContentCommand) command() {}
+
+func (c *CreateClientInApiCommand) Execute(ctx context.Context, executor Executor) error {
+	apiClient, err := executor.ApiClientFor(c.Api.Profile)
+	if err != nil {
+		return err
+	}
+	resp, err := apiClient.CreateClient(ctx, c.Api.Req, c.Api.Jwks)
+	if err != nil {
+		return err
+	}
+	if c.Callback == nil {
+		return nil
+	}
+	return c.Callback(&CreateClientInApiCommandResponse{Resp: resp})
+}
+
+func (c *UpdateClientInApiCommand) Execute(ctx context.Context, executor Executor) error {
+	apiClient, err := executor.ApiClientFor(c.Api.Profile)
+	if err != nil {
+		return err
+	}
+	if c.Api.Req != nil {
+		updateReq := ConvertAddRequestToUpdateRequest(c.Api.Req)
+		if _, err := apiClient.UpdateClient(ctx, c.Api.ClientId, updateReq); err != nil {
+			return err
+		}
+	}
+	if c.Api.Jwks != nil {
+		// TODO: verify assumed behavior of JWKS endpoints
+		if err := apiClient.CreateClientJwks(ctx, c.Api.ClientId, c.Api.Jwks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *AdoptClientInApiCommand) Execute(ctx context.Context, executor Executor) error {
+	apiClient, err := executor.ApiClientFor(c.Api.Profile)
+	if err != nil {
+		return err
+	}
+	updateReq := ConvertAddRequestToUpdateRequest(c.Api.Req)
+	_, err = apiClient.UpdateClient(ctx, c.Api.ClientId, updateReq)
+	return err
+}
+
+func (c *UpdateSecretContentCommand) Execute(ctx context.Context, executor Executor) error {
+	return executor.WriteSecretContent(ctx, c.SecretContent)
+}
+
+func (c *DeleteClientInApiCommand) Execute(ctx context.Context, executor Executor) error {
+	apiClient, err := executor.ApiClientFor(c.Profile)
+	if err != nil {
+		return err
+	}
+	return apiClient.DeleteClient(ctx, c.ClientId)
+}
+
+func (c *DeleteSecretContentCommand) Execute(ctx context.Context, executor Executor) error {
+	return executor.DeleteSecretContent(ctx)
+}
+
+func (c *CreateClientInApiCommand) ApplyStatus(status *resourcesv1alpha1.MaskinportenClientStatus) {
+	status.ClientId = c.Api.ClientId
+	status.Origin = resourcesv1alpha1.ClientOriginCreated
+}
+
+func (c *UpdateClientInApiCommand) ApplyStatus(status *resourcesv1alpha1.MaskinportenClientStatus) {
+	status.ClientId = c.Api.ClientId
+}
+
+func (c *AdoptClientInApiCommand) ApplyStatus(status *resourcesv1alpha1.MaskinportenClientStatus) {
+	status.ClientId = c.Api.ClientId
+	status.Origin = resourcesv1alpha1.ClientOriginAdopted
+}
+
+// IsRotation is true when the command replaces the active key of a client that already has one
+func (c *UpdateSecretContentCommand) IsRotation() bool {
+	if c.Previous == nil || c.Previous.Jwk == nil || c.SecretContent.Jwk == nil {
+		return false
+	}
+	return c.Previous.ClientId == c.SecretContent.ClientId && c.Previous.Jwk.KeyID() != c.SecretContent.Jwk.KeyID()
+}
+
+func (c *UpdateSecretContentCommand) ApplyStatus(status *resourcesv1alpha1.MaskinportenClientStatus) {
+	status.Authority = c.SecretContent.Authority
+	status.Profile = c.SecretContent.Profile
+	status.KeyIds = keyIds(c.SecretContent.Jwks)
+}
+
+func (c *DeleteClientInApiCommand) ApplyStatus(status *resourcesv1alpha1.MaskinportenClientStatus) {
+	// When moving between profiles, the previous client is deleted after the new one is created
+	if status.ClientId == c.ClientId {
+		status.ClientId = ""
+	}
+}
+
+func (c *DeleteSecretContentCommand) ApplyStatus(status *resourcesv1alpha1.MaskinportenClientStatus) {
+	status.Authority = ""
+	status.Profile = ""
+	status.KeyIds = nil
+}
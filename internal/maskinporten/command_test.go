@@ -0,0 +1,162 @@
+package maskinporten
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	resourcesv1alpha1 "github.com/altinn/altinn-k8s-operator/api/v1alpha1"
+	"github.com/altinn/altinn-k8s-operator/internal/config"
+	"github.com/altinn/altinn-k8s-operator/internal/crypto"
+)
+
+// recordingApiClient records calls, only the methods used by commands are implemented
+type recordingApiClient struct {
+	ApiClient
+	profile string
+	calls   *[]string
+}
+
+func (c *recordingApiClient) CreateClient(
+	ctx context.Context,
+	client *AddClientRequest,
+	jwks *crypto.Jwks,
+) (*ClientResponse, error) {
+	*c.calls = append(*c.calls, fmt.Sprintf("%s: create", c.profile))
+	return &ClientResponse{ClientId: "new-client-id"}, nil
+}
+
+func (c *recordingApiClient) DeleteClient(ctx context.Context, clientId string) error {
+	*c.calls = append(*c.calls, fmt.Sprintf("%s: delete %s", c.profile, clientId))
+	return nil
+}
+
+type recordingExecutor struct {
+	calls []string
+}
+
+func (e *recordingExecutor) ApiClientFor(profile string) (ApiClient, error) {
+	return &recordingApiClient{profile: profile, calls: &e.calls}, nil
+}
+
+func (e *recordingExecutor) WriteSecretContent(ctx context.Context, content *SecretStateContent) error {
+	e.calls = append(e.calls, fmt.Sprintf("secret: write %s", content.ClientId))
+	return nil
+}
+
+func (e *recordingExecutor) DeleteSecretContent(ctx context.Context) error {
+	e.calls = append(e.calls, "secret: delete")
+	return nil
+}
+
+func TestExecuteCommandsInOrder(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext, cfg := newProfileTestConfig()
+	clock := clockwork.NewFakeClock()
+	service := crypto.NewDefaultService(operatorContext, clock, rand.Reader)
+	state := newProfileTestState(g, operatorContext, cfg, service, clock, "ver2", "")
+
+	commands, err := state.Reconcile(operatorContext, cfg, service, clock)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	executor := &recordingExecutor{}
+	status := resourcesv1alpha1.MaskinportenClientStatus{ClientId: "client-id"}
+	for _, cmd := range commands {
+		g.Expect(cmd.Execute(context.Background(), executor)).To(Succeed())
+		cmd.ApplyStatus(&status)
+	}
+
+	// The client ID of the created client is passed on to the secret
+	g.Expect(executor.calls).To(Equal([]string{
+		"ver2: create",
+		"secret: write new-client-id",
+		fmt.Sprintf("%s: delete client-id", config.DefaultMaskinportenProfile),
+	}))
+	g.Expect(status.ClientId).To(Equal("new-client-id"))
+	g.Expect(status.Origin).To(Equal(resourcesv1alpha1.ClientOriginCreated))
+	g.Expect(status.Profile).To(Equal("ver2"))
+	g.Expect(status.KeyIds).To(HaveLen(1))
+}
+
+func TestUpdateSecretContentIsRotation(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext, _ := newProfileTestConfig()
+	clock := clockwork.NewFakeClock()
+	service := crypto.NewDefaultService(operatorContext, clock, rand.Reader)
+	notAfter := clock.Now().Add(30 * 24 * time.Hour)
+	oldJwks, err := service.CreateJwks("app1", notAfter)
+	g.Expect(err).NotTo(HaveOccurred())
+	newJwks, err := service.CreateJwks("app1", notAfter)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	content := func(clientId string, jwks *crypto.Jwks) *SecretStateContent {
+		return &SecretStateContent{ClientId: clientId, Jwks: jwks, Jwk: jwks.Keys[0]}
+	}
+
+	// A new key for the same client
+	cmd := &UpdateSecretContentCommand{Previous: content("client-id", oldJwks), SecretContent: content("client-id", newJwks)}
+	g.Expect(cmd.IsRotation()).To(BeTrue())
+	// First key of a client
+	cmd = &UpdateSecretContentCommand{Previous: nil, SecretContent: content("client-id", newJwks)}
+	g.Expect(cmd.IsRotation()).To(BeFalse())
+	// Same key, e.g. when only the authority changed
+	cmd = &UpdateSecretContentCommand{Previous: content("client-id", oldJwks), SecretContent: content("client-id", oldJwks)}
+	g.Expect(cmd.IsRotation()).To(BeFalse())
+	// Key of a replacement client
+	cmd = &UpdateSecretContentCommand{Previous: content("client-id", oldJwks), SecretContent: content("new-client-id", newJwks)}
+	g.Expect(cmd.IsRotation()).To(BeFalse())
+}
+
+// Switching back to the secret key store leaves the keys in the previous key store behind, without failing the write
+func TestWriteSecretContentAbandonsUnresolvableKeys(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	operatorContext, _ := newProfileTestConfig()
+	clock := clockwork.NewFakeClock()
+	service := crypto.NewDefaultService(operatorContext, clock, rand.Reader)
+	jwks, err := service.CreateJwks("app1", clock.Now().Add(30*24*time.Hour))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	previous := &SecretStateContent{
+		ClientId: "client-id",
+		KeyRefs:  []crypto.KeyRef{{KeyID: "kid", Uri: "https://other.vault.azure.net/secrets/app1--kid/1"}},
+	}
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "app1-secret", Namespace: "default"}}
+	g.Expect(previous.SerializeTo(secret)).To(Succeed())
+	scheme := k8sruntime.NewScheme()
+	g.Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(secret).Build()
+
+	keyStore := crypto.NewSecretKeyStore()
+	g.Expect(previous.LoadKeys(ctx, keyStore)).To(Succeed())
+	g.Expect(previous.Jwks).To(BeNil())
+
+	state := &ClientState{AppId: "app1", Secret: SecretState{Manifest: secret, Content: previous}}
+	executor := NewClientExecutor(nil, k8sClient, keyStore, operatorContext, state)
+	g.Expect(executor.WriteSecretContent(ctx, &SecretStateContent{
+		ClientId: "client-id",
+		Jwks:     jwks,
+		Jwk:      jwks.Keys[0],
+	})).To(Succeed())
+
+	updated := &corev1.Secret{}
+	g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(secret), updated)).To(Succeed())
+	content, err := DeserializeSecretStateContent(updated)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(content.KeyRefs).To(BeEmpty())
+	g.Expect(content.Jwk.KeyID()).To(Equal(jwks.Keys[0].KeyID()))
+}
@@ -0,0 +1,235 @@
+package maskinporten
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	resourcesv1alpha1 "github.com/altinn/altinn-k8s-operator/api/v1alpha1"
+	"github.com/altinn/altinn-k8s-operator/internal/crypto"
+)
+
+func (l CommandList) Describe() []resourcesv1alpha1.ActionDescription {
+	result := make([]resourcesv1alpha1.ActionDescription, len(l))
+	for i := range l {
+		result[i] = l[i].Describe()
+	}
+	return result
+}
+
+// Summaries returns the human-readable summary of each command
+func (l CommandList) Summaries() []string {
+	result := make([]string, len(l))
+	for i := range l {
+		result[i] = l[i].Describe().Summary
+	}
+	return result
+}
+
+func (c *CreateClientInApiCommand) Describe() resourcesv1alpha1.ActionDescription {
+	keys := keyIds(c.Api.Jwks)
+	return resourcesv1alpha1.ActionDescription{
+		Action: "CreateClientInApiCommand",
+		Summary: fmt.Sprintf(
+			"create client %s in profile '%s' with scopes %s and keys %s",
+			stringOrEmpty(c.Api.Req.ClientName), c.Api.Profile, formatList(c.Api.Req.Scopes), formatList(keys),
+		),
+		ClientId:    c.Api.ClientId,
+		Profile:     c.Api.Profile,
+		ScopesAdded: c.Api.Req.Scopes,
+		KeysAdded:   keys,
+	}
+}
+
+func (c *AdoptClientInApiCommand) Describe() resourcesv1alpha1.ActionDescription {
+	previousName := ""
+	if c.Previous != nil && c.Previous.Req != nil {
+		previousName = stringOrEmpty(c.Previous.Req.ClientName)
+	}
+	name := stringOrEmpty(c.Api.Req.ClientName)
+	description := resourcesv1alpha1.ActionDescription{
+		Action:   "AdoptClientInApiCommand",
+		ClientId: c.Api.ClientId,
+		Profile:  c.Api.Profile,
+		Changes:  []resourcesv1alpha1.ValueChange{{Field: "clientName", From: previousName, To: name}},
+	}
+	description.ScopesAdded, description.ScopesRemoved = diff(apiScopes(c.Previous), c.Api.Req.Scopes)
+	description.Summary = fmt.Sprintf("adopt client %s, renaming '%s' to '%s'", c.Api.ClientId, previousName, name)
+	return description
+}
+
+func (c *UpdateClientInApiCommand) Describe() resourcesv1alpha1.ActionDescription {
+	description := resourcesv1alpha1.ActionDescription{
+		Action:   "UpdateClientInApiCommand",
+		ClientId: c.Api.ClientId,
+		Profile:  c.Api.Profile,
+	}
+	var changes []string
+	if c.Api.Req != nil {
+		description.ScopesAdded, description.ScopesRemoved = diff(apiScopes(c.Previous), c.Api.Req.Scopes)
+		if len(description.ScopesAdded) > 0 {
+			changes = append(changes, "add scopes "+formatList(description.ScopesAdded))
+		}
+		if len(description.ScopesRemoved) > 0 {
+			changes = append(changes, "remove scopes "+formatList(description.ScopesRemoved))
+		}
+		var previousReq *AddClientRequest
+		if c.Previous != nil {
+			previousReq = c.Previous.Req
+		}
+		wasActive := previousReq == nil || previousReq.Active == nil || *previousReq.Active
+		if c.Api.Req.Active != nil && !*c.Api.Req.Active {
+			description.Changes = append(description.Changes, resourcesv1alpha1.ValueChange{
+				Field: "active", From: "true", To: "false",
+			})
+			changes = append(changes, "deactivate")
+		} else if !wasActive {
+			description.Changes = append(description.Changes, resourcesv1alpha1.ValueChange{
+				Field: "active", From: "false", To: "true",
+			})
+			changes = append(changes, "activate")
+		}
+		wasRetained := previousReq != nil && IsRetainedClient(previousReq.Description)
+		if IsRetainedClient(c.Api.Req.Description) && !wasRetained {
+			changes = append(changes, "mark as retained")
+		} else if !IsRetainedClient(c.Api.Req.Description) && wasRetained {
+			changes = append(changes, "unmark as retained")
+		}
+	}
+	if c.Api.Jwks != nil {
+		var previousKeys []string
+		if c.Previous != nil {
+			previousKeys = keyIds(c.Previous.Jwks)
+		}
+		keys := keyIds(c.Api.Jwks)
+		description.KeysAdded, description.KeysRetired = diff(previousKeys, keys)
+		changes = append(changes, describeKeys(keys, description.KeysAdded, description.KeysRetired))
+	}
+	if len(changes) == 0 {
+		changes = append(changes, "no changes")
+	}
+	description.Summary = fmt.Sprintf("update client %s: %s", c.Api.ClientId, strings.Join(changes, ", "))
+	return description
+}
+
+func (c *UpdateSecretContentCommand) Describe() resourcesv1alpha1.ActionDescription {
+	content := c.SecretContent
+	previous := c.Previous
+	if previous == nil {
+		previous = &SecretStateContent{}
+	}
+	description := resourcesv1alpha1.ActionDescription{
+		Action:   "UpdateSecretContentCommand",
+		ClientId: content.ClientId,
+		Profile:  content.Profile,
+	}
+
+	clientId := content.ClientId
+	if clientId == "" {
+		// Set once the client is created by a previous command
+		clientId = "<created client>"
+	}
+	for _, change := range []resourcesv1alpha1.ValueChange{
+		{Field: "clientId", From: previous.ClientId, To: clientId},
+		{Field: "authority", From: previous.Authority, To: content.Authority},
+		{Field: "profile", From: previous.Profile, To: content.Profile},
+	} {
+		if change.From != change.To {
+			description.Changes = append(description.Changes, change)
+		}
+	}
+
+	var changes []string
+	for _, change := range description.Changes {
+		from := change.From
+		if from == "" {
+			from = "<none>"
+		}
+		changes = append(changes, fmt.Sprintf("%s %s -> %s", change.Field, from, change.To))
+	}
+	previousKeys := keyIds(previous.Jwks)
+	keys := keyIds(content.Jwks)
+	if !slices.Equal(previousKeys, keys) {
+		description.KeysAdded, description.KeysRetired = diff(previousKeys, keys)
+		changes = append(changes, describeKeys(keys, description.KeysAdded, description.KeysRetired))
+	}
+	if len(changes) == 0 {
+		changes = append(changes, "no changes")
+	}
+	description.Summary = "update secret: " + strings.Join(changes, ", ")
+	return description
+}
+
+func (c *DeleteClientInApiCommand) Describe() resourcesv1alpha1.ActionDescription {
+	return resourcesv1alpha1.ActionDescription{
+		Action:   "DeleteClientInApiCommand",
+		Summary:  fmt.Sprintf("delete client %s in profile '%s'", c.ClientId, c.Profile),
+		ClientId: c.ClientId,
+		Profile:  c.Profile,
+	}
+}
+
+func (c *DeleteSecretContentCommand) Describe() resourcesv1alpha1.ActionDescription {
+	description := resourcesv1alpha1.ActionDescription{
+		Action:  "DeleteSecretContentCommand",
+		Summary: "delete secret content",
+	}
+	if c.Previous != nil {
+		description.ClientId = c.Previous.ClientId
+		description.Profile = c.Previous.Profile
+		description.KeysRetired = keyIds(c.Previous.Jwks)
+		description.Summary = fmt.Sprintf(
+			"delete secret content of client %s, retiring keys %s",
+			c.Previous.ClientId, formatList(description.KeysRetired),
+		)
+	}
+	return description
+}
+
+func describeKeys(keys []string, added []string, retired []string) string {
+	return fmt.Sprintf("keys %s (added %s, retired %s)", formatList(keys), formatList(added), formatList(retired))
+}
+
+// diff returns the values only in `next`, and the values only in `previous`
+func diff(previous []string, next []string) (added []string, removed []string) {
+	for _, value := range next {
+		if !slices.Contains(previous, value) {
+			added = append(added, value)
+		}
+	}
+	for _, value := range previous {
+		if !slices.Contains(next, value) {
+			removed = append(removed, value)
+		}
+	}
+	return added, removed
+}
+
+func apiScopes(api *ApiState) []string {
+	if api == nil || api.Req == nil {
+		return nil
+	}
+	return api.Req.Scopes
+}
+
+func keyIds(jwks *crypto.Jwks) []string {
+	if jwks == nil {
+		return nil
+	}
+	result := make([]string, 0, len(jwks.Keys))
+	for _, key := range jwks.Keys {
+		result = append(result, key.KeyID())
+	}
+	return result
+}
+
+func formatList(values []string) string {
+	return "[" + strings.Join(values, ", ") + "]"
+}
+
+func stringOrEmpty(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
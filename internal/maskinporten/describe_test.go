@@ -0,0 +1,92 @@
+package maskinporten
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	resourcesv1alpha1 "github.com/altinn/altinn-k8s-operator/api/v1alpha1"
+	"github.com/altinn/altinn-k8s-operator/internal/crypto"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/gomega"
+)
+
+func TestDescribeScopeChanges(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext, cfg := newProfileTestConfig()
+	clock := clockwork.NewFakeClock()
+	service := crypto.NewDefaultService(operatorContext, clock, rand.Reader)
+	state := newProfileTestState(g, operatorContext, cfg, service, clock, "", "")
+	state.Crd.Spec.Scopes = []string{"scope:new"}
+
+	commands, err := state.Reconcile(operatorContext, cfg, service, clock)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(commands.Summaries()).To(Equal([]string{
+		"update client client-id: add scopes [scope:new], remove scopes [scope]",
+	}))
+	description := commands[0].Describe()
+	g.Expect(description.Action).To(Equal("UpdateClientInApiCommand"))
+	g.Expect(description.ClientId).To(Equal("client-id"))
+	g.Expect(description.ScopesAdded).To(Equal([]string{"scope:new"}))
+	g.Expect(description.ScopesRemoved).To(Equal([]string{"scope"}))
+	g.Expect(description.KeysAdded).To(BeEmpty())
+}
+
+func TestDescribeKeyRotation(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext, cfg := newProfileTestConfig()
+	clock := clockwork.NewFakeClock()
+	service := crypto.NewDefaultService(operatorContext, clock, rand.Reader)
+	state := newProfileTestState(g, operatorContext, cfg, service, clock, "", "")
+	previousKid := state.Secret.Content.Jwks.Keys[0].KeyID()
+
+	clock.Advance(29 * 24 * time.Hour)
+	commands, err := state.Reconcile(operatorContext, cfg, service, clock)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(commands.Strings()).To(Equal([]string{"UpdateSecretContentCommand", "UpdateClientInApiCommand"}))
+
+	rotated := commands[0].(*UpdateSecretContentCommand).SecretContent.Jwks
+	g.Expect(rotated.Keys).To(HaveLen(2))
+	newKid := rotated.Keys[0].KeyID()
+	if newKid == previousKid {
+		newKid = rotated.Keys[1].KeyID()
+	}
+	descriptions := commands.Summaries()
+	g.Expect(descriptions[0]).To(HavePrefix("update secret: keys "))
+	g.Expect(descriptions[0]).To(ContainSubstring("added [" + newKid + "], retired []"))
+	g.Expect(descriptions[1]).To(HavePrefix("update client client-id: keys "))
+	g.Expect(descriptions[1]).To(ContainSubstring("added [" + newKid + "]"))
+
+	secretDescription := commands[0].Describe()
+	g.Expect(secretDescription.KeysAdded).To(Equal([]string{newKid}))
+	g.Expect(secretDescription.KeysRetired).To(BeEmpty())
+	g.Expect(secretDescription.Changes).To(BeEmpty())
+}
+
+func TestDescribeProfileMove(t *testing.T) {
+	g := NewWithT(t)
+
+	operatorContext, cfg := newProfileTestConfig()
+	clock := clockwork.NewFakeClock()
+	service := crypto.NewDefaultService(operatorContext, clock, rand.Reader)
+	state := newProfileTestState(g, operatorContext, cfg, service, clock, "ver2", "")
+
+	commands, err := state.Reconcile(operatorContext, cfg, service, clock)
+	g.Expect(err).NotTo(HaveOccurred())
+	descriptions := commands.Summaries()
+	g.Expect(descriptions).To(HaveLen(3))
+	g.Expect(descriptions[0]).To(HavePrefix("create client " + GetClientName(operatorContext, "app1") + " in profile 'ver2'"))
+	g.Expect(descriptions[1]).To(ContainSubstring("clientId client-id -> <created client>"))
+	g.Expect(descriptions[1]).To(ContainSubstring("authority " + state.Secret.Content.Authority + " -> http://localhost:8060"))
+	g.Expect(descriptions[1]).To(ContainSubstring("profile default -> ver2"))
+	g.Expect(descriptions[2]).To(Equal("delete client client-id in profile 'default'"))
+
+	secretDescription := commands[1].Describe()
+	g.Expect(secretDescription.Changes).To(ConsistOf(
+		resourcesv1alpha1.ValueChange{Field: "clientId", From: "client-id", To: "<created client>"},
+		resourcesv1alpha1.ValueChange{Field: "authority", From: state.Secret.Content.Authority, To: "http://localhost:8060"},
+		resourcesv1alpha1.ValueChange{Field: "profile", From: "default", To: "ver2"},
+	))
+}
@@ -0,0 +1,118 @@
+package maskinporten
+
+import (
+	"context"
+
+	"github.com/go-errors/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/altinn/altinn-k8s-operator/internal/crypto"
+	"github.com/altinn/altinn-k8s-operator/internal/operatorcontext"
+)
+
+// Executor performs the side effects of commands, against the Maskinporten API and the app secret.
+// `ClientExecutor` is the implementation used by the controller.
+type Executor interface {
+	// ApiClientFor returns the API client of the Maskinporten profile
+	ApiClientFor(profile string) (ApiClient, error)
+	// WriteSecretContent hands the keys to the key store and writes the content to the app secret
+	WriteSecretContent(ctx context.Context, content *SecretStateContent) error
+	// DeleteSecretContent removes the content from the app secret, along with the keys in the key store
+	DeleteSecretContent(ctx context.Context) error
+}
+
+// ApiClientProvider returns the API client of a Maskinporten profile, implemented by the runtime
+type ApiClientProvider interface {
+	GetMaskinportenApiClientFor(profile string) (ApiClient, error)
+}
+
+// ClientExecutor executes the commands planned from a `ClientState`
+type ClientExecutor struct {
+	apiClients ApiClientProvider
+	k8sClient  client.Client
+	keyStore   crypto.KeyStore
+	context    *operatorcontext.Context
+	state      *ClientState
+}
+
+var _ Executor = (*ClientExecutor)(nil)
+
+func NewClientExecutor(
+	apiClients ApiClientProvider,
+	k8sClient client.Client,
+	keyStore crypto.KeyStore,
+	context *operatorcontext.Context,
+	state *ClientState,
+) *ClientExecutor {
+	return &ClientExecutor{
+		apiClients: apiClients,
+		k8sClient:  k8sClient,
+		keyStore:   keyStore,
+		context:    context,
+		state:      state,
+	}
+}
+
+func (e *ClientExecutor) ApiClientFor(profile string) (ApiClient, error) {
+	return e.apiClients.GetMaskinportenApiClientFor(profile)
+}
+
+func (e *ClientExecutor) WriteSecretContent(ctx context.Context, content *SecretStateContent) error {
+	if content.ClientId == "" {
+		return errors.Errorf("secret content for app %s should always have client ID", e.state.AppId)
+	}
+	clientName := GetClientName(e.context, e.state.AppId)
+	secretContent, err := content.StoreKeys(ctx, e.keyStore, clientName)
+	if err != nil {
+		return err
+	}
+
+	updatedSecret := e.state.Secret.Manifest.DeepCopy()
+	if err := secretContent.SerializeTo(updatedSecret); err != nil {
+		return err
+	}
+	if err := e.k8sClient.Update(ctx, updatedSecret); err != nil {
+		return err
+	}
+
+	// Only remove retired keys once the secret no longer references them
+	if e.state.Secret.Content != nil {
+		staleKeyRefs := e.state.Secret.Content.StaleKeyRefs(secretContent)
+		if err := e.deleteKeys(ctx, staleKeyRefs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *ClientExecutor) DeleteSecretContent(ctx context.Context) error {
+	updatedSecret := e.state.Secret.Manifest.DeepCopy()
+	DeleteSecretStateContent(updatedSecret)
+
+	// TODO: ownerreference?
+	if err := e.k8sClient.Update(ctx, updatedSecret); err != nil {
+		return err
+	}
+
+	if e.state.Secret.Content != nil {
+		if err := e.deleteKeys(ctx, e.state.Secret.Content.KeyRefs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteKeys removes keys from the key store. Keys left behind in a key store that is no longer configured,
+// e.g. after switching back to the secret key store, can't be reached by the operator and are logged instead
+func (e *ClientExecutor) deleteKeys(ctx context.Context, refs []crypto.KeyRef) error {
+	err := e.keyStore.Delete(ctx, refs)
+	if errors.Is(err, crypto.ErrKeyRefsUnresolvable) {
+		log.FromContext(ctx).Info(
+			"WARNING: keys abandoned in a key store that is no longer configured must be deleted manually",
+			"appId", e.state.AppId, "keyStore", e.keyStore.Backend(), "reason", err.Error(),
+		)
+		return nil
+	}
+	return err
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -22,6 +23,8 @@ import (
 	"github.com/jonboulle/clockwork"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
 )
 
@@ -55,6 +58,9 @@ type HttpApiClient struct {
 	tracer      trace.Tracer
 	clock       clockwork.Clock
 
+	requestDuration metric.Float64Histogram
+	cacheLookups    metric.Int64Counter
+
 	clientNamePrefix string
 }
 
@@ -83,6 +89,24 @@ func NewHttpApiClient(
 	client.wellKnown = caching.NewCachedAtom(5*time.Minute, clock, client.wellKnownFetcher)
 	client.accessToken = caching.NewCachedAtom(1*time.Minute, clock, client.accessTokenFetcher)
 
+	meter := otel.Meter(telemetry.ServiceName)
+	var err error
+	client.requestDuration, err = meter.Float64Histogram(
+		"maskinporten.api.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of HTTP requests to the Maskinporten APIs, by endpoint and status"),
+	)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "couldn't create API duration histogram", 0)
+	}
+	client.cacheLookups, err = meter.Int64Counter(
+		TokenCacheLookupsMetric,
+		metric.WithDescription("Number of access token cache lookups, by cache and result"),
+	)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "couldn't create token cache counter", 0)
+	}
+
 	return client, nil
 }
 
@@ -93,7 +117,8 @@ func (c *HttpApiClient) createReq(
 	body io.Reader,
 ) (*http.Request, error) {
 	// Fetch the access token from the cache.
-	tokenResponse, err := c.accessToken.Get(ctx)
+	tokenResponse, hit, err := c.accessToken.Lookup(ctx)
+	RecordTokenCacheLookup(ctx, c.cacheLookups, "self_service", hit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get access token: %w", err)
 	}
@@ -121,7 +146,9 @@ func (c *HttpApiClient) GetAccessToken(ctx context.Context) (*TokenResponse, err
 	ctx, span := c.tracer.Start(ctx, "GetAccessToken")
 	defer span.End()
 
-	return c.accessToken.Get(ctx)
+	tokenResponse, hit, err := c.accessToken.Lookup(ctx)
+	RecordTokenCacheLookup(ctx, c.cacheLookups, "self_service", hit)
+	return tokenResponse, err
 }
 
 func (c *HttpApiClient) GetAllClients(ctx context.Context) ([]ClientResponse, error) {
@@ -139,7 +166,7 @@ func (c *HttpApiClient) GetAllClients(ctx context.Context) ([]ClientResponse, er
 	}
 
 	req.Header.Set("Accept", "application/json")
-	resp, err := c.retryableHTTPDo(req)
+	resp, err := c.retryableHTTPDo("GetAllClients", req)
 	if err != nil {
 		return nil, err
 	}
@@ -180,45 +207,60 @@ func (c *HttpApiClient) GetClient(
 	ctx, span := c.tracer.Start(ctx, "GetClient")
 	defer span.End()
 
-	url, err := url.JoinPath(c.config.SelfServiceUrl, "/api/v1/altinn/admin/clients", clientId)
+	dto, err := c.getClient(ctx, clientId)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	req, err := c.createReq(ctx, url, "GET", nil)
-	if err != nil {
-		return nil, nil, err
+	if dto.ClientName == nil {
+		return nil, nil, errors.New("client name is nil")
+	}
+	clientName := strings.TrimPrefix(*dto.ClientName, c.clientNamePrefix)
+	if clientName == *dto.ClientName {
+		return nil, nil, errors.New(fmt.Errorf("unexpected client name: %s", *dto.ClientName))
 	}
 
-	req.Header.Set("Accept", "application/json")
-	resp, err := c.retryableHTTPDo(req)
+	jwks, err := c.getClientJwks(ctx, clientId)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	if resp.StatusCode != 200 {
-		return nil, nil, c.handleErrorResponse(resp)
+	return dto, jwks, nil
+}
+
+func (c *HttpApiClient) GetUnmanagedClient(ctx context.Context, clientId string) (*ClientResponse, error) {
+	ctx, span := c.tracer.Start(ctx, "GetUnmanagedClient")
+	defer span.End()
+
+	return c.getClient(ctx, clientId)
+}
+
+func (c *HttpApiClient) getClient(ctx context.Context, clientId string) (*ClientResponse, error) {
+	url, err := url.JoinPath(c.config.SelfServiceUrl, "/api/v1/altinn/admin/clients", clientId)
+	if err != nil {
+		return nil, err
 	}
 
-	dto, err := deserialize[ClientResponse](resp)
+	req, err := c.createReq(ctx, url, "GET", nil)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	if dto.ClientName == nil {
-		return nil, nil, errors.New("client name is nil")
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.retryableHTTPDo("GetClient", req)
+	if err != nil {
+		return nil, err
 	}
-	clientName := strings.TrimPrefix(*dto.ClientName, c.clientNamePrefix)
-	if clientName == *dto.ClientName {
-		return nil, nil, errors.New(fmt.Errorf("unexpected client name: %s", *dto.ClientName))
+
+	if resp.StatusCode != 200 {
+		return nil, c.handleErrorResponse(resp)
 	}
 
-	jwks, err := c.getClientJwks(ctx, clientId)
+	dto, err := deserialize[ClientResponse](resp)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-
-	return &dto, jwks, nil
+	return &dto, nil
 }
 
 func (c *HttpApiClient) getClientJwks(ctx context.Context, clientId string) (*crypto.Jwks, error) {
@@ -240,7 +282,7 @@ func (c *HttpApiClient) getClientJwks(ctx context.Context, clientId string) (*cr
 	}
 
 	req.Header.Set("Accept", "application/json")
-	resp, err := c.retryableHTTPDo(req)
+	resp, err := c.retryableHTTPDo("GetClientJwks", req)
 	if err != nil {
 		return nil, err
 	}
@@ -288,7 +330,7 @@ func (c *HttpApiClient) CreateClient(
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
-	resp, err := c.retryableHTTPDo(req)
+	resp, err := c.retryableHTTPDo("CreateClient", req)
 	if err != nil {
 		return nil, err
 	}
@@ -347,7 +389,7 @@ func (c *HttpApiClient) UpdateClient(
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
-	resp, err := c.retryableHTTPDo(req)
+	resp, err := c.retryableHTTPDo("UpdateClient", req)
 	if err != nil {
 		return nil, err
 	}
@@ -398,7 +440,7 @@ func (c *HttpApiClient) CreateClientJwks(ctx context.Context, clientId string, j
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
-	resp, err := c.retryableHTTPDo(req)
+	resp, err := c.retryableHTTPDo("CreateClientJwks", req)
 	if err != nil {
 		return err
 	}
@@ -424,7 +466,7 @@ func (c *HttpApiClient) DeleteClient(ctx context.Context, clientId string) error
 		return err
 	}
 
-	resp, err := c.retryableHTTPDo(req)
+	resp, err := c.retryableHTTPDo("DeleteClient", req)
 	if err != nil {
 		return err
 	}
@@ -467,6 +509,40 @@ func (c *HttpApiClient) accessTokenFetcher(ctx context.Context) (*TokenResponse,
 		return nil, err
 	}
 
+	return c.exchangeGrant(ctx, *grant)
+}
+
+// GetAccessTokenFor mints an access token on behalf of an app client,
+// signing the JWT grant with the given private key of the client.
+// Tokens are not cached here, callers are expected to cache as appropriate.
+func (c *HttpApiClient) GetAccessTokenFor(
+	ctx context.Context,
+	clientId string,
+	jwk *crypto.Jwk,
+	scopes []string,
+) (*TokenResponse, error) {
+	ctx, span := c.tracer.Start(ctx, "GetAccessTokenFor")
+	defer span.End()
+
+	if jwk == nil {
+		return nil, errors.New("can't mint access token without JWK")
+	}
+
+	wellKnown, err := c.wellKnown.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	exp := c.clock.Now().Add(60 * time.Second)
+	grant, err := crypto.NewJWT(jwk, []string{wellKnown.Issuer}, clientId, strings.Join(scopes, " "), exp, c.clock)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.exchangeGrant(ctx, grant)
+}
+
+func (c *HttpApiClient) exchangeGrant(ctx context.Context, grant string) (*TokenResponse, error) {
 	endpoint, err := url.JoinPath(c.config.AuthorityUrl, "/token")
 	if err != nil {
 		return nil, err
@@ -474,7 +550,7 @@ func (c *HttpApiClient) accessTokenFetcher(ctx context.Context) (*TokenResponse,
 
 	urlEncodedContent := url.Values{
 		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
-		"assertion":  {*grant},
+		"assertion":  {grant},
 	}
 
 	endpoint += "?" + urlEncodedContent.Encode()
@@ -485,7 +561,7 @@ func (c *HttpApiClient) accessTokenFetcher(ctx context.Context) (*TokenResponse,
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	resp, err := c.retryableHTTPDo(req)
+	resp, err := c.retryableHTTPDo("Token", req)
 	if err != nil {
 		return nil, err
 	}
@@ -520,7 +596,7 @@ func (c *HttpApiClient) wellKnownFetcher(ctx context.Context) (*WellKnownRespons
 		return nil, err
 	}
 
-	resp, err := c.retryableHTTPDo(req)
+	resp, err := c.retryableHTTPDo("WellKnown", req)
 	if err != nil {
 		return nil, err
 	}
@@ -556,19 +632,24 @@ func deserialize[T any](resp *http.Response) (T, error) {
 func (c *HttpApiClient) handleErrorResponse(resp *http.Response) error {
 	defer func() { _ = resp.Body.Close() }()
 
-	// Try to parse as structured API error response
-	// var apiError ApiErrorResponse
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return fmt.Errorf("HTTP %d: failed to read response body: %w", resp.StatusCode, err)
 	}
 
+	// Try to parse as structured API error response
+	var apiError ApiErrorResponse
+	if err := json.Unmarshal(body, &apiError); err == nil && (apiError.Error != nil || len(apiError.Errors) > 0) {
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, apiError.String())
+	}
+
 	// Fallback to raw body if structured parsing failed or no error message found
 	return fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
 }
 
 // retryableHTTPDo performs an HTTP request with retry logic.
-func (c *HttpApiClient) retryableHTTPDo(req *http.Request) (*http.Response, error) {
+// Every attempt is recorded in the API duration histogram, labeled with `endpoint`.
+func (c *HttpApiClient) retryableHTTPDo(endpoint string, req *http.Request) (*http.Response, error) {
 	var resp *http.Response
 	var err error
 
@@ -577,7 +658,17 @@ func (c *HttpApiClient) retryableHTTPDo(req *http.Request) (*http.Response, erro
 	// TODO: different strategy??
 
 	operation := func() error {
+		start := c.clock.Now()
 		resp, err = c.client.Do(req)
+		status := "error"
+		if err == nil {
+			status = strconv.Itoa(resp.StatusCode)
+		}
+		c.requestDuration.Record(req.Context(), c.clock.Since(start).Seconds(), metric.WithAttributes(
+			attribute.String("endpoint", endpoint),
+			attribute.String("method", req.Method),
+			attribute.String("status", status),
+		))
 		if err != nil {
 			return err // Network error, retry.
 		}
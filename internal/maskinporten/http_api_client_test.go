@@ -3,9 +3,11 @@ package maskinporten
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
@@ -16,6 +18,7 @@ import (
 	"github.com/jonboulle/clockwork"
 	"github.com/onsi/gomega"
 	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel/metric/noop"
 )
 
 type testApi struct {
@@ -332,6 +335,7 @@ func TestCreateReq(t *testing.T) {
 			// Return a mock tokenResponse
 			return &TokenResponse{AccessToken: accessToken}, nil
 		}),
+		cacheLookups: noop.Int64Counter{},
 	}
 
 	var url = "http://example.com/api/endpoint"
@@ -344,3 +348,18 @@ func TestCreateReq(t *testing.T) {
 	expectedHeader := fmt.Sprintf("Bearer %s", accessToken)
 	g.Expect(req.Header.Get("Authorization")).To(Equal(expectedHeader))
 }
+
+func TestHandleErrorResponse(t *testing.T) {
+	g := NewWithT(t)
+	client := &HttpApiClient{}
+
+	body := `{"status":400,"correlation_id":"abc-123","error":"bad_request","error_description":"invalid client",` +
+		`"errors":[{"errorMessage":"must not be empty","isFieldError":true,"fieldIdentifier":"description"}]}`
+	err := client.handleErrorResponse(&http.Response{StatusCode: 400, Body: io.NopCloser(strings.NewReader(body))})
+	g.Expect(err).To(MatchError(
+		"HTTP 400: bad_request: invalid client; description must not be empty (correlation_id=abc-123)",
+	))
+
+	err = client.handleErrorResponse(&http.Response{StatusCode: 502, Body: io.NopCloser(strings.NewReader("Bad Gateway"))})
+	g.Expect(err).To(MatchError("HTTP 502: Bad Gateway"))
+}
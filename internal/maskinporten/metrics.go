@@ -0,0 +1,24 @@
+package maskinporten
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// TokenCacheLookupsMetric counts lookups in the access token caches, shared with the token service
+const TokenCacheLookupsMetric = "maskinporten.token_cache.lookups"
+
+// RecordTokenCacheLookup counts a lookup in the named token cache as a hit or a miss.
+// Failed lookups count as misses, since a token had to be fetched.
+func RecordTokenCacheLookup(ctx context.Context, counter metric.Int64Counter, cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	counter.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("cache", cache),
+		attribute.String("result", result),
+	))
+}
@@ -0,0 +1,299 @@
+package operatorcontext
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-errors/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Environment variables read by `EnvSource`
+const (
+	EnvServiceOwnerName  = "OPERATOR_SERVICE_OWNER_NAME"
+	EnvServiceOwnerOrgNo = "OPERATOR_SERVICE_OWNER_ORG_NO"
+	EnvEnvironment       = "OPERATOR_ENVIRONMENT"
+	// Namespace the operator runs in, set through the downward API
+	EnvPodNamespace = "POD_NAMESPACE"
+	// Node the operator runs on, set through the downward API
+	EnvNodeName = "NODE_NAME"
+)
+
+// Name and keys of the ConfigMap read by `ConfigMapSource`
+const (
+	ConfigMapName              = "altinn-operator-context"
+	ConfigMapServiceOwnerName  = "serviceOwnerName"
+	ConfigMapServiceOwnerOrgNo = "serviceOwnerOrgNo"
+	ConfigMapEnvironment       = "environment"
+)
+
+// Node labels read by `NodeLabelSource`
+const (
+	LabelServiceOwnerName  = "altinn.studio/service-owner-name"
+	LabelServiceOwnerOrgNo = "altinn.studio/service-owner-org-no"
+	LabelEnvironment       = "altinn.studio/environment"
+)
+
+// Defaults used when running outside of a cluster (local development, tests)
+const (
+	localServiceOwnerName  = "local"
+	localServiceOwnerOrgNo = "991825827"
+)
+
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+var serviceOwnerNamePattern = regexp.MustCompile(`^[a-z][a-z0-9]{1,29}$`)
+
+// KnownEnvironments are the environments the operator can run in.
+// Local and dev are for development, the rest map to Altinn environments.
+var KnownEnvironments = []string{
+	EnvironmentLocal,
+	EnvironmentDev,
+	"at22",
+	"at23",
+	"at24",
+	"tt02",
+	"yt01",
+	"prod",
+}
+
+// Values are the discovered properties of the operator context. Empty fields are unknown.
+type Values struct {
+	ServiceOwnerName  string
+	ServiceOwnerOrgNo string
+	Environment       string
+}
+
+func (v *Values) merge(other *Values) {
+	if v.ServiceOwnerName == "" {
+		v.ServiceOwnerName = other.ServiceOwnerName
+	}
+	if v.ServiceOwnerOrgNo == "" {
+		v.ServiceOwnerOrgNo = other.ServiceOwnerOrgNo
+	}
+	if v.Environment == "" {
+		v.Environment = other.Environment
+	}
+}
+
+func (v *Values) missing() []string {
+	var missing []string
+	if v.ServiceOwnerName == "" {
+		missing = append(missing, "service owner name")
+	}
+	if v.ServiceOwnerOrgNo == "" {
+		missing = append(missing, "service owner org number")
+	}
+	if v.Environment == "" {
+		missing = append(missing, "environment")
+	}
+	return missing
+}
+
+// Validate checks the format of the values, including the org number checksum
+func (v *Values) Validate() error {
+	if !serviceOwnerNamePattern.MatchString(v.ServiceOwnerName) {
+		return errors.Errorf("invalid service owner name: '%s'", v.ServiceOwnerName)
+	}
+	if !IsValidOrgNo(v.ServiceOwnerOrgNo) {
+		return errors.Errorf("invalid service owner org number: '%s'", v.ServiceOwnerOrgNo)
+	}
+	if !slices.Contains(KnownEnvironments, v.Environment) {
+		return errors.Errorf("unknown environment: '%s' (known: %s)", v.Environment, strings.Join(KnownEnvironments, ", "))
+	}
+	return nil
+}
+
+// IsValidOrgNo checks that the org number has 9 digits with a valid modulus 11 check digit,
+// as assigned by Brønnøysundregistrene
+func IsValidOrgNo(orgNo string) bool {
+	if len(orgNo) != 9 {
+		return false
+	}
+	weights := [8]int{3, 2, 7, 6, 5, 4, 3, 2}
+	sum := 0
+	for i, r := range orgNo {
+		if r < '0' || r > '9' {
+			return false
+		}
+		if i < len(weights) {
+			sum += int(r-'0') * weights[i]
+		}
+	}
+	checkDigit := 11 - sum%11
+	if checkDigit == 11 {
+		checkDigit = 0
+	}
+	if checkDigit == 10 {
+		return false
+	}
+	return int(orgNo[8]-'0') == checkDigit
+}
+
+// Source is a place to discover operator context values from
+type Source interface {
+	Name() string
+	Lookup(ctx context.Context) (*Values, error)
+}
+
+// EnvSource reads values from `OPERATOR_*` environment variables
+type EnvSource struct{}
+
+func (s *EnvSource) Name() string {
+	return "env"
+}
+
+func (s *EnvSource) Lookup(ctx context.Context) (*Values, error) {
+	return &Values{
+		ServiceOwnerName:  os.Getenv(EnvServiceOwnerName),
+		ServiceOwnerOrgNo: os.Getenv(EnvServiceOwnerOrgNo),
+		Environment:       os.Getenv(EnvEnvironment),
+	}, nil
+}
+
+// ConfigMapSource reads values from the `altinn-operator-context` ConfigMap in the operator namespace.
+// A missing ConfigMap is not an error, the values are just unknown.
+type ConfigMapSource struct {
+	Client    kubernetes.Interface
+	Namespace string
+}
+
+func (s *ConfigMapSource) Name() string {
+	return "configmap"
+}
+
+func (s *ConfigMapSource) Lookup(ctx context.Context) (*Values, error) {
+	configMap, err := s.Client.CoreV1().ConfigMaps(s.Namespace).Get(ctx, ConfigMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return &Values{}, nil
+	}
+	if err != nil {
+		return nil, errors.WrapPrefix(err, fmt.Sprintf("error getting ConfigMap %s/%s", s.Namespace, ConfigMapName), 0)
+	}
+
+	return &Values{
+		ServiceOwnerName:  configMap.Data[ConfigMapServiceOwnerName],
+		ServiceOwnerOrgNo: configMap.Data[ConfigMapServiceOwnerOrgNo],
+		Environment:       configMap.Data[ConfigMapEnvironment],
+	}, nil
+}
+
+// NodeLabelSource reads values from `altinn.studio/*` labels on the node the operator runs on
+type NodeLabelSource struct {
+	Client   kubernetes.Interface
+	NodeName string
+}
+
+func (s *NodeLabelSource) Name() string {
+	return "node"
+}
+
+func (s *NodeLabelSource) Lookup(ctx context.Context) (*Values, error) {
+	node, err := s.Client.CoreV1().Nodes().Get(ctx, s.NodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.WrapPrefix(err, fmt.Sprintf("error getting node %s", s.NodeName), 0)
+	}
+
+	return &Values{
+		ServiceOwnerName:  node.Labels[LabelServiceOwnerName],
+		ServiceOwnerOrgNo: node.Labels[LabelServiceOwnerOrgNo],
+		Environment:       node.Labels[LabelEnvironment],
+	}, nil
+}
+
+// IsInCluster reports whether we are running inside a Kubernetes pod
+func IsInCluster() bool {
+	return os.Getenv("KUBERNETES_SERVICE_HOST") != ""
+}
+
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get
+
+// DefaultSources returns the sources used by `Discover`, in order of precedence.
+// Cluster sources are only included when running in a cluster.
+func DefaultSources() ([]Source, error) {
+	sources := []Source{&EnvSource{}}
+	if !IsInCluster() {
+		return sources, nil
+	}
+
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "error loading in-cluster config", 0)
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "error creating kubernetes client", 0)
+	}
+
+	namespace := os.Getenv(EnvPodNamespace)
+	if namespace == "" {
+		if data, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+			namespace = strings.TrimSpace(string(data))
+		}
+	}
+	if namespace != "" {
+		sources = append(sources, &ConfigMapSource{Client: client, Namespace: namespace})
+	}
+	if nodeName := os.Getenv(EnvNodeName); nodeName != "" {
+		sources = append(sources, &NodeLabelSource{Client: client, NodeName: nodeName})
+	}
+
+	return sources, nil
+}
+
+// DiscoverValues looks up values from the sources in order, the first source to provide a value wins.
+// All values must be provided, except outside a cluster where local defaults are used if no source provides any value.
+func DiscoverValues(ctx context.Context, sources ...Source) (*Values, error) {
+	values := &Values{}
+	for _, source := range sources {
+		found, err := source.Lookup(ctx)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, fmt.Sprintf("error discovering operator context from %s", source.Name()), 0)
+		}
+		values.merge(found)
+	}
+
+	if *values == (Values{}) && !IsInCluster() {
+		values = &Values{
+			ServiceOwnerName:  localServiceOwnerName,
+			ServiceOwnerOrgNo: localServiceOwnerOrgNo,
+			Environment:       EnvironmentLocal,
+		}
+	}
+
+	if missing := values.missing(); len(missing) > 0 {
+		if IsInCluster() {
+			return nil, errors.Errorf(
+				"could not discover operator context in cluster, missing %s. Set %s, %s and %s, the '%s' ConfigMap or '%s' node labels",
+				strings.Join(missing, ", "),
+				EnvServiceOwnerName,
+				EnvServiceOwnerOrgNo,
+				EnvEnvironment,
+				ConfigMapName,
+				"altinn.studio/*",
+			)
+		}
+		// Mixing in local defaults would silently run a partly configured environment as local
+		return nil, errors.Errorf(
+			"could not discover operator context, missing %s. Set all of %s, %s and %s, or none of them for local defaults",
+			strings.Join(missing, ", "),
+			EnvServiceOwnerName,
+			EnvServiceOwnerOrgNo,
+			EnvEnvironment,
+		)
+	}
+
+	if err := values.Validate(); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
@@ -0,0 +1,148 @@
+package operatorcontext
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestIsValidOrgNo(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(IsValidOrgNo("991825827")).To(BeTrue()) // Digdir
+	Expect(IsValidOrgNo("974760673")).To(BeTrue()) // Brønnøysundregistrene
+	Expect(IsValidOrgNo("991825828")).To(BeFalse())
+	Expect(IsValidOrgNo("99182582")).To(BeFalse())
+	Expect(IsValidOrgNo("9918258270")).To(BeFalse())
+	Expect(IsValidOrgNo("99182582a")).To(BeFalse())
+	Expect(IsValidOrgNo("")).To(BeFalse())
+}
+
+func TestDiscoverDefaultsToLocalOutsideCluster(t *testing.T) {
+	RegisterTestingT(t)
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+	operatorContext, err := DiscoverFrom(context.Background())
+	Expect(err).NotTo(HaveOccurred())
+	Expect(operatorContext.ServiceOwnerName).To(Equal("local"))
+	Expect(operatorContext.ServiceOwnerOrgNo).To(Equal("991825827"))
+	Expect(operatorContext.Environment).To(Equal(EnvironmentLocal))
+	Expect(operatorContext.RunId).NotTo(BeEmpty())
+}
+
+func TestDiscoverFailsWithPartialValuesOutsideCluster(t *testing.T) {
+	RegisterTestingT(t)
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+	// Local defaults must not fill in for the service owner of a real environment
+	t.Setenv(EnvEnvironment, "tt02")
+	operatorContext, err := Discover(context.Background())
+	Expect(operatorContext).To(BeNil())
+	Expect(err).To(MatchError(ContainSubstring("missing service owner name, service owner org number")))
+
+	t.Setenv(EnvServiceOwnerName, "digdir")
+	t.Setenv(EnvServiceOwnerOrgNo, "991825827")
+	operatorContext, err = Discover(context.Background())
+	Expect(err).NotTo(HaveOccurred())
+	Expect(operatorContext.Environment).To(Equal("tt02"))
+}
+
+func TestDiscoverFromEnv(t *testing.T) {
+	RegisterTestingT(t)
+	t.Setenv(EnvServiceOwnerName, "digdir")
+	t.Setenv(EnvServiceOwnerOrgNo, "991825827")
+	t.Setenv(EnvEnvironment, "tt02")
+
+	operatorContext, err := Discover(context.Background())
+	Expect(err).NotTo(HaveOccurred())
+	Expect(operatorContext.ServiceOwnerName).To(Equal("digdir"))
+	Expect(operatorContext.ServiceOwnerOrgNo).To(Equal("991825827"))
+	Expect(operatorContext.Environment).To(Equal("tt02"))
+}
+
+func TestDiscoverRejectsInvalidValues(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Setenv(EnvServiceOwnerName, "digdir")
+	t.Setenv(EnvServiceOwnerOrgNo, "991825827")
+	t.Setenv(EnvEnvironment, "staging")
+	_, err := Discover(context.Background())
+	Expect(err).To(MatchError(ContainSubstring("unknown environment")))
+
+	t.Setenv(EnvEnvironment, "tt02")
+	t.Setenv(EnvServiceOwnerOrgNo, "991825828")
+	_, err = Discover(context.Background())
+	Expect(err).To(MatchError(ContainSubstring("invalid service owner org number")))
+
+	t.Setenv(EnvServiceOwnerOrgNo, "991825827")
+	t.Setenv(EnvServiceOwnerName, "Digdir!")
+	_, err = Discover(context.Background())
+	Expect(err).To(MatchError(ContainSubstring("invalid service owner name")))
+}
+
+func TestDiscoverInClusterFromConfigMapAndNodeLabels(t *testing.T) {
+	RegisterTestingT(t)
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+
+	client := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: ConfigMapName, Namespace: "altinn-operator"},
+			Data: map[string]string{
+				ConfigMapServiceOwnerName:  "ttd",
+				ConfigMapServiceOwnerOrgNo: "991825827",
+			},
+		},
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "node1",
+				Labels: map[string]string{
+					LabelServiceOwnerName: "digdir",
+					LabelEnvironment:      "at22",
+				},
+			},
+		},
+	)
+	sources := []Source{
+		&EnvSource{},
+		&ConfigMapSource{Client: client, Namespace: "altinn-operator"},
+		&NodeLabelSource{Client: client, NodeName: "node1"},
+	}
+
+	// Earlier sources take precedence
+	t.Setenv(EnvEnvironment, "tt02")
+	operatorContext, err := DiscoverFrom(context.Background(), sources...)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(operatorContext.ServiceOwnerName).To(Equal("ttd"))
+	Expect(operatorContext.ServiceOwnerOrgNo).To(Equal("991825827"))
+	Expect(operatorContext.Environment).To(Equal("tt02"))
+
+	t.Setenv(EnvEnvironment, "")
+	operatorContext, err = DiscoverFrom(context.Background(), sources...)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(operatorContext.Environment).To(Equal("at22"))
+}
+
+func TestDiscoverInClusterFailsWithoutValues(t *testing.T) {
+	RegisterTestingT(t)
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+
+	client := fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}})
+	sources := []Source{
+		&EnvSource{},
+		&ConfigMapSource{Client: client, Namespace: "altinn-operator"},
+		&NodeLabelSource{Client: client, NodeName: "node1"},
+	}
+
+	t.Setenv(EnvServiceOwnerName, "ttd")
+	operatorContext, err := DiscoverFrom(context.Background(), sources...)
+	Expect(operatorContext).To(BeNil())
+	Expect(err).To(MatchError(ContainSubstring("missing service owner org number, environment")))
+
+	// Node must exist when configured
+	_, err = DiscoverFrom(context.Background(), &NodeLabelSource{Client: client, NodeName: "node2"})
+	Expect(err).To(HaveOccurred())
+}
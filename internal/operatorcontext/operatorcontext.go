@@ -34,26 +34,42 @@ func (c *Context) OverrideEnvironment(env string) {
 	c.Environment = env
 }
 
+// Discover discovers the operator context from the environment, see `DefaultSources`
 func Discover(ctx context.Context) (*Context, error) {
 	err := ctx.Err()
 	if err != nil {
 		return nil, err
 	}
 
-	// TODO: this should come from the environment/context somewhere
-	// there should be 1:1 mapping between TE/env:cluster
-	serviceOwnerName := "local"
-	serviceOwnerOrgNo := "991825827"
-	environment := EnvironmentLocal
+	sources, err := DefaultSources()
+	if err != nil {
+		return nil, err
+	}
+
+	return DiscoverFrom(ctx, sources...)
+}
+
+// DiscoverFrom discovers the operator context from the given sources, see `DiscoverValues`
+func DiscoverFrom(ctx context.Context, sources ...Source) (*Context, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := DiscoverValues(ctx, sources...)
+	if err != nil {
+		return nil, err
+	}
+
 	runId, err := uuid.NewRandom()
 	if err != nil {
 		return nil, err
 	}
 
 	return &Context{
-		ServiceOwnerName:  serviceOwnerName,
-		ServiceOwnerOrgNo: serviceOwnerOrgNo,
-		Environment:       environment,
+		ServiceOwnerName:  values.ServiceOwnerName,
+		ServiceOwnerOrgNo: values.ServiceOwnerOrgNo,
+		Environment:       values.Environment,
 		RunId:             runId.String(),
 		Context:           ctx,
 		tracer:            otel.Tracer(telemetry.ServiceName),
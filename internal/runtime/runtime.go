@@ -11,10 +11,18 @@ import (
 )
 
 type Runtime interface {
+	// GetConfig returns the active config, which can be replaced on reload.
+	// The returned config must not be modified.
 	GetConfig() *config.Config
+	GetConfigWatcher() *config.Watcher
 	GetOperatorContext() *operatorcontext.Context
 	GetCrypto() *crypto.CryptoService
-	GetMaskinportenApiClient() *maskinporten.HttpApiClient
+	GetKeyStore() crypto.KeyStore
+	// GetMaskinportenApiClient returns the client of the default profile for the active config,
+	// rebuilt when its config changes
+	GetMaskinportenApiClient() maskinporten.ApiClient
+	// GetMaskinportenApiClientFor returns the client of the named Maskinporten profile
+	GetMaskinportenApiClientFor(profile string) (maskinporten.ApiClient, error)
 	GetClock() clockwork.Clock
 	Tracer() trace.Tracer
 	Meter() metric.Meter
@@ -12,12 +12,14 @@ import (
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/prometheus"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 	"k8s.io/client-go/rest"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
 const ServiceName string = "altinn-k8s-operator"
@@ -112,10 +114,21 @@ func newMeterProvider(ctx context.Context, res *resource.Resource) (*metric.Mete
 		return nil, err
 	}
 
+	// Metrics are also served on the controller-runtime /metrics endpoint, next to the controller metrics
+	prometheusExporter, err := prometheus.New(
+		prometheus.WithRegisterer(ctrlmetrics.Registry),
+		prometheus.WithNamespace("altinn_operator"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
 	meterProvider := metric.NewMeterProvider(
 		metric.WithReader(metric.NewPeriodicReader(metricExporter,
 			// Default is 1m. Set to 3s for demonstrative purposes.
-			metric.WithInterval(5*time.Second))), metric.WithResource(res),
+			metric.WithInterval(5*time.Second))),
+		metric.WithReader(prometheusExporter),
+		metric.WithResource(res),
 	)
 	return meterProvider, nil
 }
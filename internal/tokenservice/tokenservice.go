@@ -0,0 +1,396 @@
+// Package tokenservice mints Maskinporten access tokens on behalf of apps.
+// When enabled, apps request tokens from the operator over HTTP instead of signing
+// grants with the private JWKS from the app secret, so private keys never leave the operator.
+//
+// Apps authenticate using their (projected, pod bound) ServiceAccount token which is verified
+// using the TokenReview API. A token is only handed out if the pod the ServiceAccount token is bound to
+// belongs to the app of the requested MaskinportenClient, in the same namespace.
+package tokenservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	resourcesv1alpha1 "github.com/altinn/altinn-k8s-operator/api/v1alpha1"
+	"github.com/altinn/altinn-k8s-operator/internal/caching"
+	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
+	rt "github.com/altinn/altinn-k8s-operator/internal/runtime"
+)
+
+const serviceAccountUserPrefix = "system:serviceaccount:"
+
+// Tokens are refetched this long before they expire, so that callers have time to use them
+const tokenExpirySkew = 10 * time.Second
+
+// Extra info set by the API server for pod bound ServiceAccount tokens
+const (
+	podNameExtraKey = "authentication.kubernetes.io/pod-name"
+	podUidExtraKey  = "authentication.kubernetes.io/pod-uid"
+)
+
+// mintedToken is an access token along with the time it was received from Maskinporten,
+// so that we can report the remaining lifetime when serving it from cache
+type mintedToken struct {
+	Response maskinporten.TokenResponse
+	MintedAt time.Time
+}
+
+type caller struct {
+	Namespace      string
+	ServiceAccount string
+	PodName        string
+	PodUid         string
+}
+
+// Service serves Maskinporten access tokens for MaskinportenClient resources.
+// It implements `manager.Runnable` so it can be added to the controller manager.
+type Service struct {
+	runtime rt.Runtime
+	// Used for creating TokenReviews
+	client client.Client
+	// Uncached reads, we don't want to set up informers for pods and secrets in all namespaces
+	reader client.Reader
+	tracer trace.Tracer
+
+	cacheLookups metric.Int64Counter
+
+	lock   sync.Mutex
+	tokens map[types.NamespacedName]*caching.CachedAtom[mintedToken]
+}
+
+var _ manager.Runnable = (*Service)(nil)
+var _ manager.LeaderElectionRunnable = (*Service)(nil)
+
+// +kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list
+
+func NewService(runtime rt.Runtime, client client.Client, reader client.Reader) (*Service, error) {
+	cacheLookups, err := runtime.Meter().Int64Counter(
+		maskinporten.TokenCacheLookupsMetric,
+		metric.WithDescription("Number of access token cache lookups, by cache and result"),
+	)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "couldn't create token cache counter", 0)
+	}
+
+	return &Service{
+		runtime:      runtime,
+		client:       client,
+		reader:       reader,
+		tracer:       runtime.Tracer(),
+		cacheLookups: cacheLookups,
+		tokens:       make(map[types.NamespacedName]*caching.CachedAtom[mintedToken]),
+	}, nil
+}
+
+// NeedLeaderElection is false since every replica can serve tokens
+func (s *Service) NeedLeaderElection() bool {
+	return false
+}
+
+// Start serves the token endpoint until the context is cancelled
+func (s *Service) Start(ctx context.Context) error {
+	cfg := &s.runtime.GetConfig().TokenService
+	logger := log.FromContext(ctx).WithName("tokenservice")
+
+	server := &http.Server{
+		Addr:              cfg.BindAddress,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+		BaseContext:       func(_ net.Listener) context.Context { return logr.NewContext(ctx, logger) },
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		logger.Info("starting token service", "addr", cfg.BindAddress)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			errCh <- err
+		}
+		close(errCh)
+	}()
+
+	select {
+	case err := <-errCh:
+		return errors.WrapPrefix(err, "token service failed", 0)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
+
+// Handler returns the HTTP handler serving
+//   - GET /v1/token/{name} - access token for the MaskinportenClient with the given name in the callers namespace
+func (s *Service) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/token/{name}", s.handleToken)
+	return mux
+}
+
+func (s *Service) handleToken(w http.ResponseWriter, r *http.Request) {
+	ctx, span := s.tracer.Start(r.Context(), "TokenService.handleToken")
+	defer span.End()
+
+	name := r.PathValue("name")
+	logger := log.FromContext(ctx).WithValues("name", name)
+
+	caller, err := s.authenticate(ctx, r)
+	if err != nil {
+		logger.Info("unauthenticated token request", "error", err.Error())
+		span.SetStatus(codes.Error, "unauthenticated")
+		writeError(w, http.StatusUnauthorized, "invalid_client", "ServiceAccount token could not be verified")
+		return
+	}
+	key := types.NamespacedName{Namespace: caller.Namespace, Name: name}
+	span.SetAttributes(attribute.String("namespace", key.Namespace), attribute.String("name", key.Name))
+	logger = logger.WithValues("namespace", key.Namespace, "serviceAccount", caller.ServiceAccount, "pod", caller.PodName)
+
+	instance := &resourcesv1alpha1.MaskinportenClient{}
+	if err := s.reader.Get(ctx, key, instance); err != nil {
+		if apierrors.IsNotFound(err) {
+			s.evictToken(key)
+			writeError(w, http.StatusNotFound, "invalid_request", "MaskinportenClient not found")
+			return
+		}
+		logger.Error(err, "failed to get MaskinportenClient")
+		span.SetStatus(codes.Error, "failed to get MaskinportenClient")
+		writeError(w, http.StatusInternalServerError, "server_error", "failed to get MaskinportenClient")
+		return
+	}
+
+	if err := s.authorize(ctx, caller, instance); err != nil {
+		logger.Info("unauthorized token request", "error", err.Error())
+		span.SetStatus(codes.Error, "unauthorized")
+		writeError(w, http.StatusForbidden, "access_denied", "caller is not allowed to get tokens for this client")
+		return
+	}
+
+	token, err := s.getToken(ctx, key)
+	if err != nil {
+		logger.Error(err, "failed to mint access token")
+		span.SetStatus(codes.Error, "failed to mint access token")
+		writeError(w, http.StatusBadGateway, "server_error", "failed to mint access token")
+		return
+	}
+
+	resp := token.Response
+	elapsed := int(s.runtime.GetClock().Since(token.MintedAt).Seconds())
+	resp.ExpiresIn = max(resp.ExpiresIn-elapsed, 0)
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJson(w, http.StatusOK, resp)
+}
+
+func (s *Service) authenticate(ctx context.Context, r *http.Request) (*caller, error) {
+	ctx, span := s.tracer.Start(ctx, "TokenService.authenticate")
+	defer span.End()
+
+	authHeader := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(authHeader, "Bearer ")
+	if !ok || token == "" {
+		return nil, errors.New("missing bearer token")
+	}
+
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}
+	if audience := s.runtime.GetConfig().TokenService.Audience; audience != "" {
+		review.Spec.Audiences = []string{audience}
+	}
+	if err := s.client.Create(ctx, review); err != nil {
+		return nil, errors.WrapPrefix(err, "error creating TokenReview", 0)
+	}
+	if !review.Status.Authenticated {
+		return nil, errors.Errorf("token not authenticated: %s", review.Status.Error)
+	}
+
+	username := review.Status.User.Username
+	serviceAccount, ok := strings.CutPrefix(username, serviceAccountUserPrefix)
+	if !ok {
+		return nil, errors.Errorf("token does not belong to a ServiceAccount: %s", username)
+	}
+	namespace, serviceAccountName, ok := strings.Cut(serviceAccount, ":")
+	if !ok || namespace == "" || serviceAccountName == "" {
+		return nil, errors.Errorf("invalid ServiceAccount username: %s", username)
+	}
+
+	podName := review.Status.User.Extra[podNameExtraKey]
+	podUid := review.Status.User.Extra[podUidExtraKey]
+	if len(podName) != 1 || len(podUid) != 1 {
+		return nil, errors.New("token is not bound to a pod")
+	}
+
+	return &caller{
+		Namespace:      namespace,
+		ServiceAccount: serviceAccountName,
+		PodName:        podName[0],
+		PodUid:         podUid[0],
+	}, nil
+}
+
+func (s *Service) authorize(
+	ctx context.Context,
+	caller *caller,
+	instance *resourcesv1alpha1.MaskinportenClient,
+) error {
+	ctx, span := s.tracer.Start(ctx, "TokenService.authorize")
+	defer span.End()
+
+	if caller.Namespace != instance.Namespace {
+		return errors.Errorf("namespace mismatch: '%s' != '%s'", caller.Namespace, instance.Namespace)
+	}
+
+	appLabel, err := s.getAppLabel(instance.Name)
+	if err != nil {
+		return err
+	}
+
+	pod := &corev1.Pod{}
+	if err := s.reader.Get(ctx, types.NamespacedName{Namespace: caller.Namespace, Name: caller.PodName}, pod); err != nil {
+		return errors.WrapPrefix(err, "error getting caller pod", 0)
+	}
+	if string(pod.UID) != caller.PodUid {
+		return errors.Errorf("pod UID mismatch for pod '%s'", caller.PodName)
+	}
+	if pod.Labels["app"] != appLabel {
+		return errors.Errorf("pod '%s' does not belong to app '%s'", caller.PodName, appLabel)
+	}
+
+	return nil
+}
+
+func (s *Service) getToken(ctx context.Context, key types.NamespacedName) (*mintedToken, error) {
+	s.lock.Lock()
+	atom, ok := s.tokens[key]
+	if !ok {
+		cfg := &s.runtime.GetConfig().TokenService
+		value := caching.NewCachedAtomWithMaxAge(
+			cfg.CacheDuration,
+			s.runtime.GetClock(),
+			func(ctx context.Context) (*mintedToken, error) {
+				return s.mintToken(ctx, key)
+			},
+			// Never serve tokens that are about to expire, Maskinporten tokens may be shorter lived than the cache
+			func(token *mintedToken) time.Duration {
+				return time.Duration(token.Response.ExpiresIn)*time.Second - tokenExpirySkew
+			},
+		)
+		atom = &value
+		s.tokens[key] = atom
+	}
+	s.lock.Unlock()
+
+	token, hit, err := atom.Lookup(ctx)
+	maskinporten.RecordTokenCacheLookup(ctx, s.cacheLookups, "token_service", hit)
+	return token, err
+}
+
+// evictToken drops the cached token of a MaskinportenClient that no longer exists
+func (s *Service) evictToken(key types.NamespacedName) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.tokens, key)
+}
+
+func (s *Service) mintToken(ctx context.Context, key types.NamespacedName) (*mintedToken, error) {
+	ctx, span := s.tracer.Start(ctx, "TokenService.mintToken")
+	defer span.End()
+
+	// Read the current state, scopes and keys may have changed since the last token was minted
+	instance := &resourcesv1alpha1.MaskinportenClient{}
+	if err := s.reader.Get(ctx, key, instance); err != nil {
+		return nil, errors.WrapPrefix(err, "error getting MaskinportenClient", 0)
+	}
+
+	appLabel, err := s.getAppLabel(instance.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var secrets corev1.SecretList
+	err = s.reader.List(ctx, &secrets, client.InNamespace(key.Namespace), client.MatchingLabels{"app": appLabel})
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "error listing secrets", 0)
+	}
+	if len(secrets.Items) != 1 {
+		return nil, errors.Errorf("unexpected number of secrets found: %d", len(secrets.Items))
+	}
+
+	content, err := maskinporten.DeserializeSecretStateContent(&secrets.Items[0])
+	if err != nil {
+		return nil, err
+	}
+	if content == nil || content.ClientId == "" {
+		return nil, errors.New("client has not been provisioned yet")
+	}
+	if err := content.LoadKeys(ctx, s.runtime.GetKeyStore()); err != nil {
+		return nil, err
+	}
+
+	// Tokens are issued by the authority of the profile the client is registered in
+	apiClient, err := s.runtime.GetMaskinportenApiClientFor(content.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	mintedAt := s.runtime.GetClock().Now()
+	resp, err := apiClient.GetAccessTokenFor(
+		ctx,
+		content.ClientId,
+		content.Jwk,
+		instance.Spec.Scopes,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mintedToken{Response: *resp, MintedAt: mintedAt}, nil
+}
+
+// MaskinportenClient resources are named '<service owner>-<app id>' and
+// app resources are labeled 'app=<service owner>-<app id>-deployment'
+func (s *Service) getAppLabel(name string) (string, error) {
+	nameSplit := strings.Split(name, "-")
+	if len(nameSplit) < 2 {
+		return "", errors.Errorf("unexpected name format for MaskinportenClient resource: %s", name)
+	}
+	appId := nameSplit[1]
+	operatorContext := s.runtime.GetOperatorContext()
+	return fmt.Sprintf("%s-%s-deployment", operatorContext.ServiceOwnerName, appId), nil
+}
+
+func writeJson(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// Errors follow the OAuth 2.0 error response format
+func writeError(w http.ResponseWriter, status int, code string, description string) {
+	writeJson(w, status, map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
@@ -0,0 +1,392 @@
+package tokenservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/trace"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	resourcesv1alpha1 "github.com/altinn/altinn-k8s-operator/api/v1alpha1"
+	"github.com/altinn/altinn-k8s-operator/internal/config"
+	"github.com/altinn/altinn-k8s-operator/internal/crypto"
+	"github.com/altinn/altinn-k8s-operator/internal/fakes/keyvault"
+	"github.com/altinn/altinn-k8s-operator/internal/maskinporten"
+	"github.com/altinn/altinn-k8s-operator/internal/operatorcontext"
+	rt "github.com/altinn/altinn-k8s-operator/internal/runtime"
+	"github.com/altinn/altinn-k8s-operator/test/utils"
+)
+
+const namespace string = "default"
+const clientName string = "local-app1"
+const appLabel string = "local-app1-deployment"
+const podName string = "app1-pod"
+const podUid types.UID = "4b5d0f8e-2f1c-4a8e-9d60-3f8b7c2a1e10"
+
+type testRuntime struct {
+	config          *config.Config
+	operatorContext *operatorcontext.Context
+	crypto          *crypto.CryptoService
+	keyStore        crypto.KeyStore
+	apiClient       maskinporten.ApiClient
+	clock           clockwork.Clock
+	meter           metric.Meter
+}
+
+var _ rt.Runtime = (*testRuntime)(nil)
+
+func (r *testRuntime) GetConfig() *config.Config                    { return r.config }
+func (r *testRuntime) GetConfigWatcher() *config.Watcher            { return nil }
+func (r *testRuntime) GetOperatorContext() *operatorcontext.Context { return r.operatorContext }
+func (r *testRuntime) GetCrypto() *crypto.CryptoService             { return r.crypto }
+func (r *testRuntime) GetKeyStore() crypto.KeyStore                 { return r.keyStore }
+func (r *testRuntime) GetMaskinportenApiClient() maskinporten.ApiClient {
+	return r.apiClient
+}
+func (r *testRuntime) GetMaskinportenApiClientFor(profile string) (maskinporten.ApiClient, error) {
+	return r.apiClient, nil
+}
+func (r *testRuntime) GetClock() clockwork.Clock { return r.clock }
+func (r *testRuntime) Tracer() trace.Tracer      { return otel.Tracer("test") }
+func (r *testRuntime) Meter() metric.Meter       { return r.meter }
+
+type fixture struct {
+	service     *Service
+	handler     http.Handler
+	clock       clockwork.FakeClock
+	jwks        *crypto.Jwks
+	tokenCalls  *atomic.Int32
+	lastGrant   *atomic.Value
+	authorityMp *httptest.Server
+	keyVault    *keyvault.Server
+	reader      client.Reader
+	metrics     *sdkmetric.ManualReader
+}
+
+func (f *fixture) Close() {
+	f.authorityMp.Close()
+	f.keyVault.Close()
+}
+
+func newFixture(g *WithT) *fixture {
+	operatorContext := operatorcontext.DiscoverOrDie(context.Background())
+	cfg := config.GetConfigOrDie(operatorContext, config.ConfigSourceDefault, "")
+	cfg.TokenService = config.TokenServiceConfig{
+		Enabled:       true,
+		BindAddress:   ":0",
+		CacheDuration: time.Minute,
+	}
+	// Private keys are kept out of app secrets when the token service is enabled
+	keyVault := keyvault.NewServer()
+	cfg.KeyStore = config.KeyStoreConfig{Backend: "azure_key_vault", AzureKeyVaultUrl: keyVault.URL()}
+	keyStore, err := crypto.NewAzureKeyVaultKeyStore(keyVault.URL(), keyVault.Credential(), keyVault.ClientOptions())
+	g.Expect(err).NotTo(HaveOccurred())
+
+	clock := clockwork.NewFakeClockAt(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	cryptoService := crypto.NewDefaultService(operatorContext, clock, utils.NewDeterministicRand())
+	jwks, err := cryptoService.CreateJwks("app1", clock.Now().Add(time.Hour*24*30))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	f := &fixture{
+		clock:      clock,
+		jwks:       jwks,
+		keyVault:   keyVault,
+		tokenCalls: &atomic.Int32{},
+		lastGrant:  &atomic.Value{},
+		metrics:    sdkmetric.NewManualReader(),
+	}
+
+	f.authorityMp = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/oauth-authorization-server":
+			_, _ = fmt.Fprintf(w, `{"issuer":"%s/","token_endpoint":"%s/token"}`, cfg.MaskinportenApi.AuthorityUrl, cfg.MaskinportenApi.AuthorityUrl)
+		case "/token":
+			n := f.tokenCalls.Add(1)
+			f.lastGrant.Store(r.URL.Query().Get("assertion"))
+			_, _ = fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":120,"scope":"altinn:serviceowner"}`, n)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	cfg.MaskinportenApi.AuthorityUrl = f.authorityMp.URL
+
+	apiClient, err := maskinporten.NewHttpApiClient(&cfg.MaskinportenApi, operatorContext, clock)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	runtime := &testRuntime{
+		config:          cfg,
+		operatorContext: operatorContext,
+		crypto:          cryptoService,
+		keyStore:        keyStore,
+		apiClient:       apiClient,
+		clock:           clock,
+		meter:           sdkmetric.NewMeterProvider(sdkmetric.WithReader(f.metrics)).Meter("test"),
+	}
+
+	scheme := newScheme()
+	g.Expect(resourcesv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app1-secret", Namespace: namespace, Labels: map[string]string{"app": appLabel}},
+		Type:       corev1.SecretTypeOpaque,
+	}
+
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(
+			&resourcesv1alpha1.MaskinportenClient{
+				ObjectMeta: metav1.ObjectMeta{Name: clientName, Namespace: namespace},
+				Spec:       resourcesv1alpha1.MaskinportenClientSpec{Scopes: []string{"altinn:serviceowner"}},
+			},
+			&resourcesv1alpha1.MaskinportenClient{
+				ObjectMeta: metav1.ObjectMeta{Name: "local-app2", Namespace: namespace},
+			},
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: namespace, UID: podUid, Labels: map[string]string{"app": appLabel}},
+			},
+			secret,
+		).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+				review, ok := obj.(*authenticationv1.TokenReview)
+				if !ok {
+					return c.Create(ctx, obj, opts...)
+				}
+				// Tokens in tests have the format '<namespace>:<serviceaccount>:<pod>:<pod uid>'
+				parts := strings.Split(review.Spec.Token, ":")
+				if len(parts) != 4 {
+					review.Status = authenticationv1.TokenReviewStatus{Authenticated: false, Error: "invalid token"}
+					return nil
+				}
+				review.Status = authenticationv1.TokenReviewStatus{
+					Authenticated: true,
+					User: authenticationv1.UserInfo{
+						Username: "system:serviceaccount:" + parts[0] + ":" + parts[1],
+						Extra: map[string]authenticationv1.ExtraValue{
+							podNameExtraKey: {parts[2]},
+							podUidExtraKey:  {parts[3]},
+						},
+					},
+				}
+				return nil
+			},
+		}).
+		Build()
+
+	// Written the way the reconciler does it, so that the keys end up in the key store
+	state := &maskinporten.ClientState{AppId: "app1", Secret: maskinporten.SecretState{Manifest: secret}}
+	executor := maskinporten.NewClientExecutor(runtime, k8sClient, keyStore, operatorContext, state)
+	g.Expect(executor.WriteSecretContent(context.Background(), &maskinporten.SecretStateContent{
+		ClientId:  "client-id-1",
+		Authority: cfg.MaskinportenApi.AuthorityUrl,
+		Jwks:      jwks,
+		Jwk:       jwks.Keys[0],
+	})).To(Succeed())
+
+	service, err := NewService(runtime, k8sClient, k8sClient)
+	g.Expect(err).NotTo(HaveOccurred())
+	f.service = service
+	f.reader = k8sClient
+	f.handler = f.service.Handler()
+	return f
+}
+
+func newScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	return scheme
+}
+
+func (f *fixture) request(name string, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/v1/token/"+name, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	f.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// cacheLookups returns the number of token service cache lookups with the given result
+func (f *fixture) cacheLookups(g *WithT, result string) int64 {
+	var metrics metricdata.ResourceMetrics
+	g.Expect(f.metrics.Collect(context.Background(), &metrics)).To(Succeed())
+	for _, scope := range metrics.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			if m.Name != maskinporten.TokenCacheLookupsMetric {
+				continue
+			}
+			for _, point := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				cache, _ := point.Attributes.Value(attribute.Key("cache"))
+				res, _ := point.Attributes.Value(attribute.Key("result"))
+				if cache.AsString() == "token_service" && res.AsString() == result {
+					return point.Value
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func validToken() string {
+	return fmt.Sprintf("%s:app1:%s:%s", namespace, podName, podUid)
+}
+
+func TestTokenIsMintedAndCached(t *testing.T) {
+	g := NewWithT(t)
+	f := newFixture(g)
+	defer f.Close()
+
+	rec := f.request(clientName, validToken())
+	g.Expect(rec.Code).To(Equal(http.StatusOK))
+	var resp maskinporten.TokenResponse
+	g.Expect(json.Unmarshal(rec.Body.Bytes(), &resp)).To(Succeed())
+	g.Expect(resp.AccessToken).To(Equal("token-1"))
+	g.Expect(resp.ExpiresIn).To(Equal(120))
+	g.Expect(f.tokenCalls.Load()).To(Equal(int32(1)))
+
+	// The grant is signed with the app key, not the operator key
+	grant, err := crypto.ParseJWT(f.lastGrant.Load().(string))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(grant.KeyID()).To(Equal(f.jwks.Keys[0].KeyID()))
+	claims, err := grant.DecodeClaims(f.jwks.Keys[0].Public())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(claims.Issuer).To(Equal("client-id-1"))
+	g.Expect(claims.Scope).To(Equal("altinn:serviceowner"))
+
+	// Served from cache with reduced lifetime
+	f.clock.Advance(30 * time.Second)
+	rec = f.request(clientName, validToken())
+	g.Expect(rec.Code).To(Equal(http.StatusOK))
+	g.Expect(json.Unmarshal(rec.Body.Bytes(), &resp)).To(Succeed())
+	g.Expect(resp.AccessToken).To(Equal("token-1"))
+	g.Expect(resp.ExpiresIn).To(Equal(90))
+	g.Expect(f.tokenCalls.Load()).To(Equal(int32(1)))
+
+	// Cache expired
+	f.clock.Advance(time.Minute)
+	rec = f.request(clientName, validToken())
+	g.Expect(rec.Code).To(Equal(http.StatusOK))
+	g.Expect(json.Unmarshal(rec.Body.Bytes(), &resp)).To(Succeed())
+	g.Expect(resp.AccessToken).To(Equal("token-2"))
+	g.Expect(f.tokenCalls.Load()).To(Equal(int32(2)))
+
+	g.Expect(f.cacheLookups(g, "hit")).To(Equal(int64(1)))
+	g.Expect(f.cacheLookups(g, "miss")).To(Equal(int64(2)))
+}
+
+func TestAppSecretHasNoPrivateKeys(t *testing.T) {
+	g := NewWithT(t)
+	f := newFixture(g)
+	defer f.Close()
+
+	secret := &corev1.Secret{}
+	g.Expect(f.reader.Get(context.Background(), types.NamespacedName{Name: "app1-secret", Namespace: namespace}, secret)).To(Succeed())
+	content, err := maskinporten.DeserializeSecretStateContent(secret)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(content.Jwks).To(BeNil())
+	g.Expect(content.Jwk).To(BeNil())
+	g.Expect(content.KeyRefs).To(HaveLen(1))
+	for _, value := range secret.Data {
+		g.Expect(string(value)).NotTo(ContainSubstring(`"d":`))
+	}
+
+	// Tokens are still minted with the app key
+	rec := f.request(clientName, validToken())
+	g.Expect(rec.Code).To(Equal(http.StatusOK))
+	grant, err := crypto.ParseJWT(f.lastGrant.Load().(string))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(grant.KeyID()).To(Equal(f.jwks.Keys[0].KeyID()))
+}
+
+func TestTokenRequestsAreAuthenticatedAndAuthorized(t *testing.T) {
+	g := NewWithT(t)
+	f := newFixture(g)
+	defer f.Close()
+
+	g.Expect(f.request(clientName, "").Code).To(Equal(http.StatusUnauthorized))
+	g.Expect(f.request(clientName, "garbage").Code).To(Equal(http.StatusUnauthorized))
+
+	// Token from another namespace doesn't see the client
+	other := fmt.Sprintf("other:app1:%s:%s", podName, podUid)
+	g.Expect(f.request(clientName, other).Code).To(Equal(http.StatusNotFound))
+
+	// Pod doesn't belong to the app of the client
+	g.Expect(f.request("local-app2", validToken()).Code).To(Equal(http.StatusForbidden))
+
+	// Pod has been replaced
+	replaced := fmt.Sprintf("%s:app1:%s:%s", namespace, podName, "other-uid")
+	g.Expect(f.request(clientName, replaced).Code).To(Equal(http.StatusForbidden))
+
+	// Pod doesn't exist
+	missing := fmt.Sprintf("%s:app1:%s:%s", namespace, "missing", podUid)
+	g.Expect(f.request(clientName, missing).Code).To(Equal(http.StatusForbidden))
+
+	g.Expect(f.tokenCalls.Load()).To(Equal(int32(0)))
+}
+
+func TestTokenIsNotCachedBeyondItsExpiry(t *testing.T) {
+	g := NewWithT(t)
+	f := newFixture(g)
+	defer f.Close()
+	// Tokens from the fake authority expire after 120s
+	f.service.runtime.GetConfig().TokenService.CacheDuration = time.Hour
+
+	var resp maskinporten.TokenResponse
+	rec := f.request(clientName, validToken())
+	g.Expect(rec.Code).To(Equal(http.StatusOK))
+
+	f.clock.Advance(120*time.Second - tokenExpirySkew)
+	rec = f.request(clientName, validToken())
+	g.Expect(rec.Code).To(Equal(http.StatusOK))
+	g.Expect(json.Unmarshal(rec.Body.Bytes(), &resp)).To(Succeed())
+	g.Expect(resp.AccessToken).To(Equal("token-1"))
+	g.Expect(resp.ExpiresIn).To(Equal(int(tokenExpirySkew.Seconds())))
+
+	f.clock.Advance(time.Second)
+	rec = f.request(clientName, validToken())
+	g.Expect(rec.Code).To(Equal(http.StatusOK))
+	g.Expect(json.Unmarshal(rec.Body.Bytes(), &resp)).To(Succeed())
+	g.Expect(resp.AccessToken).To(Equal("token-2"))
+	g.Expect(resp.ExpiresIn).To(Equal(120))
+}
+
+func TestTokenIsEvictedWhenClientIsDeleted(t *testing.T) {
+	g := NewWithT(t)
+	f := newFixture(g)
+	defer f.Close()
+
+	g.Expect(f.request(clientName, validToken()).Code).To(Equal(http.StatusOK))
+	g.Expect(f.service.tokens).To(HaveLen(1))
+
+	instance := &resourcesv1alpha1.MaskinportenClient{}
+	key := types.NamespacedName{Namespace: namespace, Name: clientName}
+	g.Expect(f.service.client.Get(context.Background(), key, instance)).To(Succeed())
+	g.Expect(f.service.client.Delete(context.Background(), instance)).To(Succeed())
+
+	g.Expect(f.request(clientName, validToken()).Code).To(Equal(http.StatusNotFound))
+	g.Expect(f.service.tokens).To(BeEmpty())
+}